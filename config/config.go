@@ -0,0 +1,320 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"golangdb/database"
+	"io"
+	"os"
+	"strconv"
+	"time"
+)
+
+/*
+   Unified configuration for the server binary.
+
+   Sources are merged in this order, later ones win:
+     defaults -> config file (JSON) -> environment -> command line flags
+
+   Arguments after the flags name a command instead of starting the server
+   ("golangdb -db-path x.db migrate status"); see Command.
+*/
+
+type Config struct {
+	Port      string `json:"port"`
+	JWTSecret string `json:"jwt_secret"`
+
+	DbPath        string   `json:"db_path"`
+	WalPath       string   `json:"wal_path"`
+	WalSizeLimit  int64    `json:"wal_size_limit"`
+	SnapshotEvery int64    `json:"snapshot_every"`
+	SyncMode      string   `json:"sync_mode"`
+	SyncInterval  Duration `json:"sync_interval"`
+	FileMode      FileMode `json:"file_mode"`
+	DirMode       FileMode `json:"dir_mode"`
+
+	// run the pending migrations before the server starts
+	AutoMigrate bool `json:"auto_migrate"`
+
+	// set from flags only
+	ConfigFile  string `json:"-"`
+	PrintConfig bool   `json:"-"`
+	// arguments after the flags, e.g. ["migrate", "up"]
+	Command []string `json:"-"`
+}
+
+// Duration is a time.Duration that reads and writes as "1s", "250ms", ...
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	return d.Set(s)
+}
+
+func (d *Duration) Set(s string) error {
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+// FileMode is an os.FileMode that reads and writes as an octal string such as "0644".
+type FileMode os.FileMode
+
+func (m FileMode) MarshalJSON() ([]byte, error) {
+	return json.Marshal(m.String())
+}
+
+func (m *FileMode) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	return m.Set(s)
+}
+
+func (m *FileMode) Set(s string) error {
+	v, err := strconv.ParseUint(s, 8, 32)
+	if err != nil {
+		return fmt.Errorf("file mode %q is not an octal number", s)
+	}
+	*m = FileMode(v)
+	return nil
+}
+
+func (m FileMode) String() string {
+	return fmt.Sprintf("%04o", uint32(m))
+}
+
+func Default() Config {
+	opts := database.DefaultOptions()
+
+	return Config{
+		Port:          "8080",
+		DbPath:        opts.DbPath,
+		WalPath:       opts.WalPath,
+		WalSizeLimit:  opts.WalSizeLimit,
+		SnapshotEvery: opts.SnapshotEvery,
+		SyncMode:      opts.SyncMode.String(),
+		SyncInterval:  Duration(opts.SyncInterval),
+		FileMode:      FileMode(opts.FileMode),
+		DirMode:       FileMode(opts.DirMode),
+	}
+}
+
+// Load builds the configuration from args (without the program name) and the environment
+// looked up through getenv. It returns flag.ErrHelp when -h was requested.
+func Load(args []string, getenv func(string) string) (Config, error) {
+	cfg := Default()
+
+	fs := flag.NewFlagSet("golangdb", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+
+	var flagged Config
+	fs.StringVar(&flagged.ConfigFile, "config", "", "path to a JSON config file")
+	fs.BoolVar(&flagged.PrintConfig, "print-config", false, "print the effective config and exit")
+	fs.StringVar(&flagged.Port, "port", "", "HTTP port")
+	fs.StringVar(&flagged.JWTSecret, "jwt-secret", "", "secret used to sign JWTs")
+	fs.StringVar(&flagged.DbPath, "db-path", "", "snapshot file path")
+	fs.StringVar(&flagged.WalPath, "wal-path", "", "write-ahead log path")
+	fs.Int64Var(&flagged.WalSizeLimit, "wal-size-limit", 0, "WAL size in bytes that triggers a snapshot")
+	fs.Int64Var(&flagged.SnapshotEvery, "snapshot-every", 0, "WAL records that trigger a snapshot (0 disables)")
+	fs.StringVar(&flagged.SyncMode, "sync-mode", "", "WAL fsync mode: always, interval or none")
+	fs.Var(&flagged.SyncInterval, "sync-interval", "fsync period in interval mode")
+	fs.Var(&flagged.FileMode, "file-mode", "permissions of data files (octal)")
+	fs.Var(&flagged.DirMode, "dir-mode", "permissions of data directories (octal)")
+	fs.BoolVar(&flagged.AutoMigrate, "auto-migrate", false, "run pending migrations on start")
+
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return cfg, err
+		}
+		return cfg, fmt.Errorf("invalid flags: %w", err)
+	}
+
+	path := flagged.ConfigFile
+	if path == "" {
+		path = getenv("GOLANGDB_CONFIG")
+	}
+	if path != "" {
+		if err := cfg.loadFile(path); err != nil {
+			return cfg, err
+		}
+	}
+
+	if err := cfg.loadEnv(getenv); err != nil {
+		return cfg, err
+	}
+
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "port":
+			cfg.Port = flagged.Port
+		case "jwt-secret":
+			cfg.JWTSecret = flagged.JWTSecret
+		case "db-path":
+			cfg.DbPath = flagged.DbPath
+		case "wal-path":
+			cfg.WalPath = flagged.WalPath
+		case "wal-size-limit":
+			cfg.WalSizeLimit = flagged.WalSizeLimit
+		case "snapshot-every":
+			cfg.SnapshotEvery = flagged.SnapshotEvery
+		case "sync-mode":
+			cfg.SyncMode = flagged.SyncMode
+		case "sync-interval":
+			cfg.SyncInterval = flagged.SyncInterval
+		case "file-mode":
+			cfg.FileMode = flagged.FileMode
+		case "dir-mode":
+			cfg.DirMode = flagged.DirMode
+		case "auto-migrate":
+			cfg.AutoMigrate = flagged.AutoMigrate
+		}
+	})
+	cfg.ConfigFile = path
+	cfg.PrintConfig = flagged.PrintConfig
+	cfg.Command = fs.Args()
+
+	if err := cfg.Validate(); err != nil {
+		return cfg, err
+	}
+	return cfg, nil
+}
+
+func (c *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+
+	if err := dec.Decode(c); err != nil {
+		return fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+	return nil
+}
+
+func (c *Config) loadEnv(getenv func(string) string) error {
+	strs := map[string]*string{
+		"PORT":               &c.Port,
+		"JWT_SECRET":         &c.JWTSecret,
+		"GOLANGDB_DB_PATH":   &c.DbPath,
+		"GOLANGDB_WAL_PATH":  &c.WalPath,
+		"GOLANGDB_SYNC_MODE": &c.SyncMode,
+	}
+	for name, dst := range strs {
+		if v := getenv(name); v != "" {
+			*dst = v
+		}
+	}
+
+	ints := map[string]*int64{
+		"GOLANGDB_WAL_SIZE_LIMIT": &c.WalSizeLimit,
+		"GOLANGDB_SNAPSHOT_EVERY": &c.SnapshotEvery,
+	}
+	for name, dst := range ints {
+		if v := getenv(name); v != "" {
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				return fmt.Errorf("%s: %q is not an integer", name, v)
+			}
+			*dst = n
+		}
+	}
+
+	if v := getenv("GOLANGDB_AUTO_MIGRATE"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("GOLANGDB_AUTO_MIGRATE: %q is not a boolean", v)
+		}
+		c.AutoMigrate = b
+	}
+
+	setters := map[string]flag.Value{
+		"GOLANGDB_SYNC_INTERVAL": &c.SyncInterval,
+		"GOLANGDB_FILE_MODE":     &c.FileMode,
+		"GOLANGDB_DIR_MODE":      &c.DirMode,
+	}
+	for name, dst := range setters {
+		if v := getenv(name); v != "" {
+			if err := dst.Set(v); err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (c Config) Validate() error {
+	var errs []error
+
+	// commands and -print-config do not sign tokens
+	if c.JWTSecret == "" && len(c.Command) == 0 && !c.PrintConfig {
+		errs = append(errs, errors.New("jwt secret is required (JWT_SECRET or -jwt-secret)"))
+	}
+
+	port, err := strconv.Atoi(c.Port)
+	if err != nil || port < 1 || port > 65535 {
+		errs = append(errs, fmt.Errorf("port %q is not a valid TCP port", c.Port))
+	}
+
+	opts, err := c.DatabaseOptions()
+	if err != nil {
+		errs = append(errs, err)
+	} else if err := opts.Validate(); err != nil {
+		errs = append(errs, err)
+	}
+
+	return errors.Join(errs...)
+}
+
+// DatabaseOptions converts the storage part of the config into options for database.Open.
+func (c Config) DatabaseOptions() (database.Options, error) {
+	mode, err := database.ParseSyncMode(c.SyncMode)
+	if err != nil {
+		return database.Options{}, err
+	}
+
+	opts := database.Options{
+		DbPath:        c.DbPath,
+		WalPath:       c.WalPath,
+		WalSizeLimit:  c.WalSizeLimit,
+		SnapshotEvery: c.SnapshotEvery,
+		SyncMode:      mode,
+		SyncInterval:  time.Duration(c.SyncInterval),
+		FileMode:      os.FileMode(c.FileMode),
+		DirMode:       os.FileMode(c.DirMode),
+	}
+	return opts, nil
+}
+
+// Print writes the effective config as JSON with the secret redacted.
+func (c Config) Print(w io.Writer) error {
+	if c.JWTSecret != "" {
+		c.JWTSecret = "<redacted>"
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+	return enc.Encode(c)
+}
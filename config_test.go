@@ -0,0 +1,77 @@
+package main_test
+
+import (
+	"golangdb/config"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestConfigPrecedence(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "golangdb.json")
+
+	err := os.WriteFile(file, []byte(`{"port": "7000", "wal_path": "/data/file.wal", "sync_mode": "none"}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	env := map[string]string{
+		"JWT_SECRET":         "secret",
+		"PORT":               "7100",
+		"GOLANGDB_SYNC_MODE": "interval",
+	}
+
+	cfg, err := config.Load([]string{"-config", file, "-port", "7200"}, func(k string) string { return env[k] })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.Port != "7200" {
+		t.Fatalf("flag should win, got port %s", cfg.Port)
+	}
+	if cfg.SyncMode != "interval" {
+		t.Fatalf("env should beat the file, got sync mode %s", cfg.SyncMode)
+	}
+	if cfg.WalPath != "/data/file.wal" {
+		t.Fatalf("file should beat defaults, got wal path %s", cfg.WalPath)
+	}
+	if cfg.DbPath != config.Default().DbPath {
+		t.Fatalf("expected default db path, got %s", cfg.DbPath)
+	}
+}
+
+func TestConfigValidation(t *testing.T) {
+	_, err := config.Load([]string{"-port", "99999", "-sync-mode", "sometimes"}, func(string) string { return "" })
+	if err == nil {
+		t.Fatalf("expected validation error")
+	}
+}
+
+func TestConfigCommand(t *testing.T) {
+	env := map[string]string{"GOLANGDB_AUTO_MIGRATE": "true"}
+
+	// commands run without a jwt secret
+	cfg, err := config.Load([]string{"-port", "7300", "migrate", "up", "-to", "4"}, func(k string) string { return env[k] })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !cfg.AutoMigrate {
+		t.Fatalf("expected auto migrate from the environment")
+	}
+	if len(cfg.Command) != 4 || cfg.Command[0] != "migrate" || cfg.Command[3] != "4" {
+		t.Fatalf("unexpected command %v", cfg.Command)
+	}
+}
+
+func TestConfigPrint(t *testing.T) {
+	// printing the config needs no jwt secret, but the rest is still checked
+	cfg, err := config.Load([]string{"-print-config"}, func(string) string { return "" })
+	if err != nil || !cfg.PrintConfig {
+		t.Fatalf("expected -print-config without a secret to load, got %v", err)
+	}
+	if _, err := config.Load([]string{"-print-config", "-port", "99999"}, func(string) string { return "" }); err == nil {
+		t.Fatalf("expected validation error for the port")
+	}
+}
@@ -0,0 +1,211 @@
+package database
+
+import (
+	"fmt"
+	"golangdb/errors_consts"
+	"strings"
+)
+
+/*
+   Aggregations and GroupBy for SelectQuery
+
+   Aggregates are computed in one streaming pass: visitRows reads the table (or the index
+   range) a page at a time and every matching row is decoded, folded into the state of
+   its group and dropped, so memory grows with the number of groups (and the distinct
+   values of count_distinct), not with the rows. The result has one row per group
+   holding the group fields and one field per aggregate (named by its alias). Having
+   filters those rows; OrderBy, Limit, Offset and Columns then work on them like on
+   ordinary rows.
+*/
+
+type aggregate struct {
+	fn    string
+	field string
+	alias string
+}
+
+type aggState struct {
+	count    int64
+	sum      numberSum
+	min      any
+	max      any
+	distinct map[string]struct{}
+}
+
+type groupState struct {
+	values map[string]any
+	aggs   []*aggState
+}
+
+type aggregator struct {
+	groupBy []string
+	aggs    []aggregate
+	groups  map[string]*groupState
+	order   []string
+}
+
+var aggregateFuncs = map[string]bool{
+	"count":          true,
+	"count_distinct": true,
+	"sum":            true,
+	"avg":            true,
+	"min":            true,
+	"max":            true,
+}
+
+func newAggregate(fn, field, alias string) (aggregate, error) {
+	fn = strings.ToLower(fn)
+	if !aggregateFuncs[fn] {
+		return aggregate{}, fmt.Errorf("%w: unknown aggregate %q", errors_consts.ErrInvalidQuery, fn)
+	}
+	if field == "" && fn != "count" {
+		return aggregate{}, fmt.Errorf("%w: aggregate %s needs a field", errors_consts.ErrInvalidQuery, fn)
+	}
+
+	if alias == "" {
+		alias = fn
+		if field != "" {
+			alias = fn + "_" + field
+		}
+	}
+	return aggregate{fn: fn, field: field, alias: alias}, nil
+}
+
+func newAggregator(groupBy []string, aggs []aggregate) *aggregator {
+	return &aggregator{
+		groupBy: groupBy,
+		aggs:    aggs,
+		groups:  make(map[string]*groupState),
+	}
+}
+
+// groupKey is a type-aware string form of the row's group values, so 1 and "1" differ
+func groupKey(values []any) string {
+	var b strings.Builder
+	for _, v := range values {
+		if enc, ok := encodeIndexValue(v); ok {
+			b.WriteString(enc)
+		} else {
+			fmt.Fprintf(&b, "%T:%v", v, v)
+		}
+		b.WriteByte(0)
+	}
+	return b.String()
+}
+
+func (a *aggregator) add(row map[string]any) {
+	values := make([]any, len(a.groupBy))
+	for i, field := range a.groupBy {
+		values[i] = fieldValue(row, field)
+	}
+
+	key := groupKey(values)
+
+	group, ok := a.groups[key]
+	if !ok {
+		group = a.newGroup(values)
+		a.groups[key] = group
+		a.order = append(a.order, key)
+	}
+
+	for i, agg := range a.aggs {
+		group.aggs[i].add(agg, row)
+	}
+}
+
+func (a *aggregator) newGroup(values []any) *groupState {
+	group := &groupState{
+		values: make(map[string]any, len(values)),
+		aggs:   make([]*aggState, len(a.aggs)),
+	}
+	for i, field := range a.groupBy {
+		if values[i] != nil {
+			group.values[field] = values[i]
+		}
+	}
+	for i := range group.aggs {
+		group.aggs[i] = &aggState{}
+	}
+	return group
+}
+
+func (st *aggState) add(agg aggregate, row map[string]any) {
+	if agg.field == "" {
+		st.count++
+		return
+	}
+
+	value, ok := lookupField(row, agg.field)
+	if !ok || value == nil {
+		return
+	}
+	st.count++
+
+	switch agg.fn {
+	case "count_distinct":
+		if st.distinct == nil {
+			st.distinct = make(map[string]struct{})
+		}
+		st.distinct[groupKey([]any{value})] = struct{}{}
+
+	case "sum", "avg":
+		if n, ok := toNumber(value); ok {
+			st.sum.add(n)
+		}
+
+	case "min":
+		if st.min == nil || compareValues(value, st.min) < 0 {
+			st.min = value
+		}
+
+	case "max":
+		if st.max == nil || compareValues(value, st.max) > 0 {
+			st.max = value
+		}
+	}
+}
+
+func (st *aggState) result(agg aggregate) any {
+	switch agg.fn {
+	case "count":
+		return st.count
+	case "count_distinct":
+		return int64(len(st.distinct))
+	case "sum":
+		return st.sum.total()
+	case "avg":
+		if st.sum.count == 0 {
+			return nil
+		}
+		return st.sum.float() / float64(st.sum.count)
+	case "min":
+		return st.min
+	case "max":
+		return st.max
+	}
+	return nil
+}
+
+// rows returns one row per group. Without GroupBy there is always exactly one row.
+func (a *aggregator) rows() []resultRow {
+	if len(a.groupBy) == 0 && len(a.groups) == 0 {
+		key := groupKey(nil)
+		a.groups[key] = a.newGroup(nil)
+		a.order = append(a.order, key)
+	}
+
+	out := make([]resultRow, 0, len(a.order))
+	for _, key := range a.order {
+		group := a.groups[key]
+
+		row := make(map[string]any, len(group.values)+len(a.aggs))
+		for k, v := range group.values {
+			row[k] = v
+		}
+		for i, agg := range a.aggs {
+			row[agg.alias] = group.aggs[i].result(agg)
+		}
+		out = append(out, resultRow{key: key, row: row})
+	}
+	return out
+}
@@ -0,0 +1,458 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"golangdb/errors_consts"
+	"reflect"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/*
+   Table catalog
+
+   A table has no key of its own; it is the set of keys its name owns:
+     <table>:<id>                                   rows (id is an integer)
+     __Meta__:<table>:next_id                       next id to hand out
+     __schema__:<table>                             schema (CreateTable)
+     __idxmeta__:<table>, __idx__:..., __uniq__:... indexes and unique keys
+     __fkref__:<referenced table>\x00<table>\x00...  its foreign keys
+     __ftsmeta__:<table>, __fts__:..., ...          full-text indexes
+   A table exists while it owns any of them, so an empty table created with CreateTable is
+   listed too. Table names may contain ':' ("user:1:contacts"), so the keys of table "t"
+   share their prefix with those of "t:x"; rows are told apart by their integer id.
+
+   RenameTable, DropTable and TruncateTable change all keys of the table in one atomic batch.
+*/
+
+const nextIDPrefix = "__Meta__:"
+
+// TableInfo describes a table, see DescribeTable.
+type TableInfo struct {
+	Name string `json:"name"`
+	Rows int    `json:"rows"`
+	// size of the stored rows, keys included
+	Bytes   int64        `json:"bytes"`
+	Columns []ColumnInfo `json:"columns"`
+	Indexes []IndexInfo  `json:"indexes"`
+	// fields with a full-text index
+	TextIndexes []string `json:"text_indexes"`
+	// nil for a table without a schema
+	Schema *Schema `json:"schema,omitempty"`
+	NextID int64   `json:"next_id"`
+}
+
+// ColumnInfo is a top-level field as found in the rows of a table.
+type ColumnInfo struct {
+	Name string `json:"name"`
+	// value types seen in the field
+	Types []ColumnType `json:"types"`
+	// rows holding the field
+	Rows     int  `json:"rows"`
+	Nullable bool `json:"nullable,omitempty"`
+}
+
+// rowID returns the id of key when key is a row of table
+func rowID(table, key string) (string, bool) {
+	id, ok := strings.CutPrefix(key, table+":")
+	if !ok {
+		return "", false
+	}
+	_, err := strconv.ParseInt(id, 10, 64)
+	return id, err == nil
+}
+
+// rowKeys returns the row keys of table
+func rowKeys(tx *Tx, table string) []string {
+	prefix := table + ":"
+
+	var out []string
+	for _, key := range tx.ScanKeys(prefix, prefixEnd(prefix)) {
+		if _, ok := rowID(table, key); ok {
+			out = append(out, key)
+		}
+	}
+	return out
+}
+
+func tableExists(tx *Tx, table string) bool {
+	for _, key := range []string{schemaKey(table), indexMetaKey(table), textMetaKey(table), nextIDKey(table)} {
+		if _, ok := tx.Get(key); ok {
+			return true
+		}
+	}
+
+	prefix := table + ":"
+	for _, key := range tx.ScanKeys(prefix, prefixEnd(prefix)) {
+		if _, ok := rowID(table, key); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// ListTables returns the names of the tables starting with prefix in ascending order;
+// an empty prefix lists every table.
+func (db *DB) ListTables(prefix string) ([]string, error) {
+	return db.ListTablesContext(context.Background(), prefix)
+}
+
+func (db *DB) ListTablesContext(ctx context.Context, prefix string) ([]string, error) {
+	seen := make(map[string]bool)
+
+	scan := func(start string, table func(key string) (string, bool)) error {
+		keys, err := db.Database.ScanKeys(ctx, start, prefixEnd(start))
+		if err != nil {
+			return err
+		}
+		for _, key := range keys {
+			if name, ok := table(key); ok && strings.HasPrefix(name, prefix) {
+				seen[name] = true
+			}
+		}
+		return nil
+	}
+
+	// rows: "<table>:<id>", skipping the catalog keys themselves
+	rows := func(key string) (string, bool) {
+		if isCatalogKey(key) {
+			return "", false
+		}
+		i := strings.LastIndexByte(key, ':')
+		if i <= 0 {
+			return "", false
+		}
+		_, ok := rowID(key[:i], key)
+		return key[:i], ok
+	}
+
+	afterPrefix := func(keyPrefix, suffix string) func(string) (string, bool) {
+		return func(key string) (string, bool) {
+			name, ok := strings.CutSuffix(strings.TrimPrefix(key, keyPrefix), suffix)
+			return name, ok && name != ""
+		}
+	}
+
+	scans := []struct {
+		start string
+		table func(string) (string, bool)
+	}{
+		{prefix, rows},
+		{nextIDPrefix + prefix, afterPrefix(nextIDPrefix, ":next_id")},
+		{schemaPrefix + prefix, afterPrefix(schemaPrefix, "")},
+		{indexMetaPrefix + prefix, afterPrefix(indexMetaPrefix, "")},
+		{textMetaPrefix + prefix, afterPrefix(textMetaPrefix, "")},
+	}
+	for _, s := range scans {
+		if err := scan(s.start, s.table); err != nil {
+			return nil, err
+		}
+	}
+
+	names := make([]string, 0, len(seen))
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// isCatalogKey reports whether key is one of the database's own bookkeeping keys
+func isCatalogKey(key string) bool {
+	for _, prefix := range []string{nextIDPrefix, schemaPrefix, indexMetaPrefix, indexPrefix, uniquePrefix, sequencePrefix, foreignKeyPrefix,
+		textPrefix, textDocPrefix, textStatPrefix, textMetaPrefix} {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// DescribeTable returns the size, inferred columns, indexes and schema of table.
+// It reads like a select and blocks no writer; rows written meanwhile may or may not count.
+func (db *DB) DescribeTable(table string) (*TableInfo, error) {
+	return db.DescribeTableContext(context.Background(), table)
+}
+
+func (db *DB) DescribeTableContext(ctx context.Context, table string) (*TableInfo, error) {
+	if table == "" {
+		return nil, errors_consts.ErrEmptyName
+	}
+
+	info := &TableInfo{Name: table, NextID: 1}
+
+	// read under the read lock like a select, so describing a big table blocks no writer
+	var err error
+	if info.Schema, err = loadSchema(db.Database.Get, table); err != nil {
+		return nil, err
+	}
+	if info.Indexes, err = loadIndexes(db.Database.Get, table); err != nil {
+		return nil, err
+	}
+	if info.TextIndexes, err = loadTextFields(db.Database.Get, table); err != nil {
+		return nil, err
+	}
+
+	exists := false
+	for _, key := range []string{schemaKey(table), indexMetaKey(table), textMetaKey(table)} {
+		if _, ok := db.Database.Get(key); ok {
+			exists = true
+		}
+	}
+	if raw, ok := db.Database.Get(nextIDKey(table)); ok {
+		exists = true
+		if err := json.Unmarshal(raw, &info.NextID); err != nil {
+			return nil, err
+		}
+	}
+
+	raw, err := db.Database.ScanPrefixContext(ctx, table+":")
+	if err != nil {
+		return nil, err
+	}
+
+	columns := make(map[string]*ColumnInfo)
+
+	i := 0
+	for key, data := range raw {
+		if _, ok := rowID(table, key); !ok {
+			continue
+		}
+		if i++; i%scanCheckEvery == 0 {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+		}
+
+		row, err := decodeRow(data)
+		if err != nil {
+			return nil, err
+		}
+
+		info.Rows++
+		info.Bytes += int64(len(key) + len(data))
+
+		for field, v := range row {
+			col := columns[field]
+			if col == nil {
+				col = &ColumnInfo{Name: field}
+				columns[field] = col
+			}
+			col.Rows++
+
+			if v == nil {
+				col.Nullable = true
+			} else if t := typeOf(v); !slices.Contains(col.Types, t) {
+				col.Types = append(col.Types, t)
+			}
+		}
+	}
+
+	if !exists && info.Rows == 0 {
+		return nil, fmt.Errorf("%w: %s", errors_consts.ErrTableNotFound, table)
+	}
+
+	info.Columns = make([]ColumnInfo, 0, len(columns))
+	for _, col := range columns {
+		slices.Sort(col.Types)
+		info.Columns = append(info.Columns, *col)
+	}
+	sort.Slice(info.Columns, func(i, j int) bool {
+		return info.Columns[i].Name < info.Columns[j].Name
+	})
+
+	if info.Indexes == nil {
+		info.Indexes = []IndexInfo{}
+	}
+	if info.TextIndexes == nil {
+		info.TextIndexes = []string{}
+	}
+	return info, nil
+}
+
+// typeOf names the column type of a stored value
+func typeOf(v any) ColumnType {
+	if n, ok := toNumber(v); ok {
+		if n.isInteger() {
+			return TypeInt
+		}
+		return TypeFloat
+	}
+
+	switch v.(type) {
+	case string:
+		return TypeString
+	case bool:
+		return TypeBool
+	}
+	for _, t := range []ColumnType{TypeTimestamp, TypeBytes, TypeUUID, TypeArray} {
+		if t.matches(v) {
+			return t
+		}
+	}
+	if reflect.ValueOf(v).Kind() == reflect.Map {
+		return TypeObject
+	}
+	return ColumnType(fmt.Sprintf("%T", v))
+}
+
+// RenameTable moves every row, the id counter, the schema and the indexes of table to
+// newName. It fails with ErrTableExists when newName already owns any key and with
+// ErrForeignKeyViolation while another table references table.
+func (db *DB) RenameTable(table, newName string) error {
+	if table == "" || newName == "" {
+		return errors_consts.ErrEmptyName
+	}
+	if table == newName {
+		return nil
+	}
+
+	return db.Database.Atomic(func(tx *Tx) error {
+		if !tableExists(tx, table) {
+			return fmt.Errorf("%w: %s", errors_consts.ErrTableNotFound, table)
+		}
+		if tableExists(tx, newName) {
+			return fmt.Errorf("%w: %s", errors_consts.ErrTableExists, newName)
+		}
+		if err := referencedByOthers(tx, table); err != nil {
+			return err
+		}
+
+		schema, err := loadSchema(tx.Get, table)
+		if err != nil {
+			return err
+		}
+		if schema != nil {
+			setReferences(tx, table, schema, false)
+			for i, col := range schema.Columns {
+				if col.References != nil && col.References.Table == table {
+					fk := *col.References
+					fk.Table = newName
+					schema.Columns[i].References = &fk
+				}
+			}
+
+			tx.Delete(schemaKey(table))
+			tx.Set(schemaKey(newName), encodeSchema(*schema))
+			setReferences(tx, newName, schema, true)
+		}
+
+		defs, err := loadIndexes(tx.Get, table)
+		if err != nil {
+			return err
+		}
+		for _, def := range defs {
+			clearIndex(tx, def)
+		}
+
+		texts, err := loadTextFields(tx.Get, table)
+		if err != nil {
+			return err
+		}
+		for _, field := range texts {
+			clearText(tx, table, field)
+		}
+
+		for _, key := range rowKeys(tx, table) {
+			id, _ := rowID(table, key)
+			data, _ := tx.Get(key)
+			tx.Set(rowKey(newName, id), data)
+			tx.Delete(key)
+		}
+
+		if data, ok := tx.Get(nextIDKey(table)); ok {
+			tx.Set(nextIDKey(newName), data)
+			tx.Delete(nextIDKey(table))
+		}
+
+		if len(texts) > 0 {
+			tx.Delete(textMetaKey(table))
+			for _, field := range texts {
+				if err := backfillText(tx, newName, field); err != nil {
+					return err
+				}
+			}
+			tx.Set(textMetaKey(newName), mustJson(texts))
+		}
+
+		if len(defs) == 0 {
+			return nil
+		}
+
+		tx.Delete(indexMetaKey(table))
+		for i := range defs {
+			defs[i].Table = newName
+			if err := backfillIndex(tx, defs[i], true); err != nil {
+				return err
+			}
+		}
+		tx.Set(indexMetaKey(newName), mustJson(defs))
+		return nil
+	})
+}
+
+// DropTable deletes table with its rows, id counter, schema and indexes.
+func (db *DB) DropTable(table string) error {
+	return db.dropTable(table, true)
+}
+
+// TruncateTable deletes every row of table and its index entries. The schema and the
+// index definitions stay; ids start again at 1.
+//
+// Both fail with ErrForeignKeyViolation while another table references table.
+func (db *DB) TruncateTable(table string) error {
+	return db.dropTable(table, false)
+}
+
+func (db *DB) dropTable(table string, definitions bool) error {
+	if table == "" {
+		return errors_consts.ErrEmptyName
+	}
+
+	return db.Database.Atomic(func(tx *Tx) error {
+		if !tableExists(tx, table) {
+			return fmt.Errorf("%w: %s", errors_consts.ErrTableNotFound, table)
+		}
+		if err := referencedByOthers(tx, table); err != nil {
+			return err
+		}
+
+		defs, err := loadIndexes(tx.Get, table)
+		if err != nil {
+			return err
+		}
+		for _, def := range defs {
+			clearIndex(tx, def)
+		}
+
+		texts, err := loadTextFields(tx.Get, table)
+		if err != nil {
+			return err
+		}
+		for _, field := range texts {
+			clearText(tx, table, field)
+		}
+
+		for _, key := range rowKeys(tx, table) {
+			tx.Delete(key)
+		}
+		tx.Delete(nextIDKey(table))
+
+		if definitions {
+			schema, err := loadSchema(tx.Get, table)
+			if err != nil {
+				return err
+			}
+			setReferences(tx, table, schema, false)
+
+			tx.Delete(schemaKey(table))
+			tx.Delete(indexMetaKey(table))
+			tx.Delete(textMetaKey(table))
+		}
+		return nil
+	})
+}
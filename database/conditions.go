@@ -0,0 +1,133 @@
+package database
+
+import (
+	"fmt"
+	"golangdb/errors_consts"
+)
+
+/*
+   Predicate tree
+
+   Leaves are WhereClause comparisons, inner nodes combine them with AND, OR and NOT.
+   Build trees with Cond, And, Or and Not, then hand them to WhereCond on a query:
+
+     db.Select().Table("users").
+         Where("age", ">", 18).
+         WhereCond(database.Or(
+             database.Cond("city", "=", "Oslo"),
+             database.Not(database.Cond("active", "=", false)),
+         ))
+*/
+
+type condKind int
+
+const (
+	condLeaf condKind = iota
+	condAnd
+	condOr
+	condNot
+)
+
+type Condition struct {
+	kind     condKind
+	clause   *WhereClause
+	children []*Condition
+	err      error
+}
+
+func Cond(field, op string, value any) *Condition {
+	c := &Condition{kind: condLeaf}
+
+	clause, err := newWhereClause(field, op, value)
+	if err != nil {
+		c.err = fmt.Errorf("%w: %v", errors_consts.ErrInvalidWhere, err)
+		return c
+	}
+
+	c.clause = clause
+	return c
+}
+
+// And matches when every condition matches. And() with no conditions matches every row.
+func And(conds ...*Condition) *Condition {
+	return &Condition{kind: condAnd, children: conds}
+}
+
+// Or matches when at least one condition matches. Or() with no conditions matches nothing.
+func Or(conds ...*Condition) *Condition {
+	return &Condition{kind: condOr, children: conds}
+}
+
+func Not(cond *Condition) *Condition {
+	return &Condition{kind: condNot, children: []*Condition{cond}}
+}
+
+// Err returns the first construction error found in the tree.
+func (c *Condition) Err() error {
+	if c == nil {
+		return fmt.Errorf("%w: nil condition", errors_consts.ErrInvalidWhere)
+	}
+	if c.err != nil {
+		return c.err
+	}
+	for _, child := range c.children {
+		if err := child.Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Condition) match(row map[string]any) bool {
+	switch c.kind {
+	case condLeaf:
+		return c.clause.match(row)
+	case condAnd:
+		for _, child := range c.children {
+			if !child.match(row) {
+				return false
+			}
+		}
+		return true
+	case condOr:
+		for _, child := range c.children {
+			if child.match(row) {
+				return true
+			}
+		}
+		return false
+	case condNot:
+		return !c.children[0].match(row)
+	}
+	return false
+}
+
+/*
+   filter is embedded by the queries that accept where conditions.
+   Every call adds one more condition, combined with the previous ones by AND.
+*/
+
+type filter struct {
+	conds []*Condition
+	err   error
+}
+
+func (f *filter) add(c *Condition) {
+	if f.err != nil {
+		return
+	}
+	if err := c.Err(); err != nil {
+		f.err = err
+		return
+	}
+	f.conds = append(f.conds, c)
+}
+
+func (f *filter) matches(row map[string]any) bool {
+	for _, c := range f.conds {
+		if !c.match(row) {
+			return false
+		}
+	}
+	return true
+}
@@ -2,13 +2,16 @@ package database
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
+	"encoding/json"
 	"fmt"
 	"io"
+	"log"
 	"os"
 	"path/filepath"
-	"strings"
 	"sync"
+	"time"
 )
 
 const (
@@ -22,10 +25,19 @@ type Database struct {
 	dbFile       *os.File
 	walFile      *os.File
 	mu           sync.RWMutex
-	mem          map[string][]byte
+	mem          *memTable
 	databasePath string
 	walPath      string
 	walSizeLimit int64
+
+	snapshotEvery int64
+	walRecords    int64
+	syncMode      SyncMode
+	fileMode      os.FileMode
+	logger        *log.Logger
+
+	stopSync chan struct{}
+	syncDone chan struct{}
 }
 
 type Record struct {
@@ -34,7 +46,7 @@ type Record struct {
 	Value []byte
 }
 
-func loadSnapshot(path string, mem map[string][]byte) error {
+func loadSnapshot(path string, mem *memTable) error {
 	f, err := os.Open(path)
 	if err != nil {
 		if os.IsNotExist(err) {
@@ -73,13 +85,13 @@ func loadSnapshot(path string, mem map[string][]byte) error {
 			return err
 		}
 
-		mem[string(key)] = val
+		mem.set(string(key), val)
 	}
 
 	return nil
 }
 
-func replayWal(path string, mem map[string][]byte) error {
+func replayWal(path string, mem *memTable) error {
 	f, err := os.Open(path)
 
 	if err != nil {
@@ -98,20 +110,65 @@ func replayWal(path string, mem map[string][]byte) error {
 		if err != nil {
 			return err // damaged
 		}
-		applyRecord(mem, rec)
+		if err := applyRecord(mem, rec); err != nil {
+			return err // damaged
+		}
 	}
 
 	return nil
 }
 
-func applyRecord(mem map[string][]byte, r *Record) {
+func applyRecord(mem *memTable, r *Record) error {
 	switch r.Op {
 	case 'S':
 		v := make([]byte, len(r.Value))
 		copy(v, r.Value)
-		mem[string(r.Key)] = v
+		mem.set(string(r.Key), v)
 	case 'D':
-		delete(mem, string(r.Key))
+		mem.delete(string(r.Key))
+	case 'B':
+		// a batch is applied completely: it is decoded up front, so a damaged
+		// batch changes nothing
+		recs, err := decodeBatch(r.Value)
+		if err != nil {
+			return err
+		}
+		for _, rec := range recs {
+			if err := applyRecord(mem, rec); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// batch records ('B') carry their sub-records, encoded with writeRecord, as the value
+func encodeBatch(recs []*Record) (*Record, error) {
+	var buf bytes.Buffer
+	for _, rec := range recs {
+		if err := writeRecord(&buf, rec); err != nil {
+			return nil, err
+		}
+	}
+	return &Record{Op: 'B', Value: buf.Bytes()}, nil
+}
+
+func decodeBatch(data []byte) ([]*Record, error) {
+	var recs []*Record
+
+	r := bytes.NewReader(data)
+	for {
+		rec, err := ReadRecord(r)
+		if err == io.EOF {
+			return recs, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if rec.Op == 'B' {
+			return nil, fmt.Errorf("nested batch record")
+		}
+		recs = append(recs, rec)
 	}
 }
 
@@ -215,14 +272,29 @@ func ReadRecord(r io.Reader) (*Record, error) {
 }
 
 func OpenDB(dbPath, walPath string, walSizeLimit int64) (*Database, error) {
-	for _, path := range []string{dbPath, walPath} {
+	opts := DefaultOptions()
+	opts.DbPath = dbPath
+	opts.WalPath = walPath
+	opts.WalSizeLimit = walSizeLimit
+
+	return Open(opts)
+}
+
+func Open(opts Options) (*Database, error) {
+	opts = opts.withDefaults()
+
+	if err := opts.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid database options: %w", err)
+	}
+
+	for _, path := range []string{opts.DbPath, opts.WalPath} {
 		dir := filepath.Dir(path)
-		if err := os.MkdirAll(dir, 0755); err != nil {
+		if err := os.MkdirAll(dir, opts.DirMode); err != nil {
 			return nil, fmt.Errorf("failed to create directory %s: %w", dir, err)
 		}
 	}
 
-	filedatabase, err := os.OpenFile(dbPath, os.O_CREATE|os.O_RDWR, 0644)
+	filedatabase, err := os.OpenFile(opts.DbPath, os.O_CREATE|os.O_RDWR, opts.FileMode)
 
 	if err != nil {
 		return nil, fmt.Errorf("Failed to create a database file: %w", err)
@@ -234,7 +306,7 @@ func OpenDB(dbPath, walPath string, walSizeLimit int64) (*Database, error) {
 		}
 	}()
 
-	fileWal, err := os.OpenFile(walPath, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+	fileWal, err := os.OpenFile(opts.WalPath, os.O_CREATE|os.O_RDWR|os.O_APPEND, opts.FileMode)
 
 	if err != nil {
 		filedatabase.Close()
@@ -242,16 +314,20 @@ func OpenDB(dbPath, walPath string, walSizeLimit int64) (*Database, error) {
 	}
 
 	db := Database{
-		dbFile:       filedatabase,
-		walFile:      fileWal,
-		mu:           sync.RWMutex{},
-		mem:          make(map[string][]byte),
-		databasePath: dbPath,
-		walPath:      walPath,
-		walSizeLimit: walSizeLimit,
+		dbFile:        filedatabase,
+		walFile:       fileWal,
+		mu:            sync.RWMutex{},
+		mem:           newMemTable(),
+		databasePath:  opts.DbPath,
+		walPath:       opts.WalPath,
+		walSizeLimit:  opts.WalSizeLimit,
+		snapshotEvery: opts.SnapshotEvery,
+		syncMode:      opts.SyncMode,
+		fileMode:      opts.FileMode,
+		logger:        opts.logger(),
 	}
 	// potential recovery
-	err = loadSnapshot(dbPath, db.mem)
+	err = loadSnapshot(opts.DbPath, db.mem)
 
 	if err != nil {
 		fileWal.Close()
@@ -259,7 +335,7 @@ func OpenDB(dbPath, walPath string, walSizeLimit int64) (*Database, error) {
 		return nil, err
 	}
 
-	err = replayWal(walPath, db.mem)
+	err = replayWal(opts.WalPath, db.mem)
 
 	if err != nil {
 		fileWal.Close()
@@ -267,14 +343,41 @@ func OpenDB(dbPath, walPath string, walSizeLimit int64) (*Database, error) {
 		return nil, err
 	}
 
+	if db.syncMode == SyncInterval {
+		db.stopSync = make(chan struct{})
+		db.syncDone = make(chan struct{})
+		go db.syncLoop(opts.SyncInterval)
+	}
+
 	return &db, nil
 }
 
+// background fsync for SyncInterval mode
+func (db *Database) syncLoop(interval time.Duration) {
+	defer close(db.syncDone)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-db.stopSync:
+			return
+		case <-ticker.C:
+			db.mu.Lock()
+			if err := db.walFile.Sync(); err != nil {
+				db.logger.Printf("wal background sync failed: %v", err)
+			}
+			db.mu.Unlock()
+		}
+	}
+}
+
 func (db *Database) Get(key string) ([]byte, bool) {
 	db.mu.RLock()
 	defer db.mu.RUnlock()
 
-	val, ok := db.mem[key]
+	val, ok := db.mem.data[key]
 
 	if !ok {
 		return nil, false
@@ -315,7 +418,72 @@ func (db *Database) Delete(key string) error {
 	return nil
 }
 
+// Increment atomically adds delta to the integer stored under key and returns the new value.
+// A missing key counts as zero. The value is kept as a JSON integer, the same encoding the
+// query layer uses for its metadata keys.
+func (db *Database) Increment(key string, delta int64) (int64, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	var current int64
+
+	if raw, ok := db.mem.data[key]; ok {
+		if err := json.Unmarshal(raw, &current); err != nil {
+			return 0, fmt.Errorf("key %s does not hold an integer: %w", key, err)
+		}
+	}
+
+	next := current + delta
+	if (delta > 0 && next < current) || (delta < 0 && next > current) {
+		return 0, fmt.Errorf("increment of key %s overflows int64", key)
+	}
+
+	value, err := json.Marshal(next)
+	if err != nil {
+		return 0, err
+	}
+
+	rec := &Record{
+		Op:    'S',
+		Key:   []byte(key),
+		Value: value,
+	}
+
+	if err := applyHelper(db, rec); err != nil {
+		return 0, err
+	}
+	return next, nil
+}
+
+// SetIfAbsent stores val under key only if the key does not exist yet.
+// It reports whether the value was written.
+func (db *Database) SetIfAbsent(key string, val []byte) (bool, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if _, ok := db.mem.data[key]; ok {
+		return false, nil
+	}
+
+	rec := &Record{
+		Op:    'S',
+		Key:   []byte(key),
+		Value: val,
+	}
+
+	if err := applyHelper(db, rec); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
 func (db *Database) Close() error {
+	if db.stopSync != nil {
+		close(db.stopSync)
+		<-db.syncDone
+		db.stopSync = nil
+	}
+
 	db.mu.Lock()
 	defer db.mu.Unlock()
 
@@ -340,14 +508,14 @@ func (db *Database) Close() error {
 
 func (db *Database) snapshot() error {
 	tmp := db.databasePath + ".tmp"
-	tempFile, err := os.OpenFile(tmp, os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0644)
+	tempFile, err := os.OpenFile(tmp, os.O_CREATE|os.O_RDWR|os.O_TRUNC, db.fileMode)
 	if err != nil {
 		return err
 	}
 
 	defer tempFile.Close()
 
-	for k, v := range db.mem {
+	for k, v := range db.mem.data {
 		if err := writeSnapshotRecord(tempFile, []byte(k), v); err != nil {
 			tempFile.Close()
 			return err
@@ -367,7 +535,7 @@ func (db *Database) snapshot() error {
 		return err
 	}
 
-	db.dbFile, err = os.OpenFile(db.databasePath, os.O_RDWR, 0644)
+	db.dbFile, err = os.OpenFile(db.databasePath, os.O_RDWR, db.fileMode)
 	if err != nil {
 		return err
 	}
@@ -376,12 +544,15 @@ func (db *Database) snapshot() error {
 		return err
 	}
 
-	db.walFile, err = os.OpenFile(db.walPath, os.O_CREATE|os.O_RDWR|os.O_TRUNC|os.O_APPEND, 0644)
+	db.walFile, err = os.OpenFile(db.walPath, os.O_CREATE|os.O_RDWR|os.O_TRUNC|os.O_APPEND, db.fileMode)
 
 	if err != nil {
 		return err
 	}
 
+	db.walRecords = 0
+	db.logger.Printf("snapshot written: %d keys", len(db.mem.data))
+
 	return nil
 }
 
@@ -406,18 +577,134 @@ func writeSnapshotRecord(w io.Writer, key, value []byte) error {
 }
 
 func (db *Database) ScanPrefix(prefix string) map[string][]byte {
+	res, _ := db.ScanPrefixContext(context.Background(), prefix)
+	return res
+}
+
+// how many keys are visited between two checks of the context
+const scanCheckEvery = 1024
+
+// ScanPrefixContext is ScanPrefix that gives up with ctx.Err() once the context is done.
+func (db *Database) ScanPrefixContext(ctx context.Context, prefix string) (map[string][]byte, error) {
 	db.mu.RLock()
 	defer db.mu.RUnlock()
 
 	res := make(map[string][]byte)
-	for key, val := range db.mem {
-		if strings.HasPrefix(key, prefix) {
-			v := make([]byte, len(val))
-			copy(v, val)
-			res[key] = v
+	visited := 0
+
+	var err error
+	db.mem.scanPrefix(prefix, func(key string, val []byte) bool {
+		visited++
+		if visited%scanCheckEvery == 0 {
+			if err = ctx.Err(); err != nil {
+				return false
+			}
 		}
+
+		v := make([]byte, len(val))
+		copy(v, val)
+		res[key] = v
+		return true
+	})
+
+	if err != nil {
+		return nil, err
 	}
-	return res
+	return res, ctx.Err()
+}
+
+// ScanKeys returns, in ascending order, the keys in [start, end). An empty end means no upper bound.
+func (db *Database) ScanKeys(ctx context.Context, start, end string) ([]string, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	var (
+		keys []string
+		err  error
+	)
+	db.mem.keys.ascend(start, end, func(key string) bool {
+		if len(keys)%scanCheckEvery == scanCheckEvery-1 {
+			if err = ctx.Err(); err != nil {
+				return false
+			}
+		}
+		keys = append(keys, key)
+		return true
+	})
+
+	if err != nil {
+		return nil, err
+	}
+	return keys, ctx.Err()
+}
+
+// ScanRange calls fn with every key in [start, end) and its value, in ascending order.
+// The keys are read a page at a time, so fn runs without the lock and may use the
+// database; a key written during the scan may or may not be visited. An error from fn
+// stops the scan and is returned.
+func (db *Database) ScanRange(ctx context.Context, start, end string, fn func(key string, val []byte) error) error {
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		keys, vals := db.scanPage(start, end, scanCheckEvery)
+		for i, key := range keys {
+			if err := fn(key, vals[i]); err != nil {
+				return err
+			}
+		}
+
+		if len(keys) < scanCheckEvery {
+			return ctx.Err()
+		}
+		// the smallest key after the last one read
+		start = keys[len(keys)-1] + "\x00"
+	}
+}
+
+// scanPage copies up to n keys of [start, end) and their values
+func (db *Database) scanPage(start, end string, n int) ([]string, [][]byte) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	keys := make([]string, 0, n)
+	vals := make([][]byte, 0, n)
+	db.mem.keys.ascend(start, end, func(key string) bool {
+		val := db.mem.data[key]
+		v := make([]byte, len(val))
+		copy(v, val)
+
+		keys = append(keys, key)
+		vals = append(vals, v)
+		return len(keys) < n
+	})
+	return keys, vals
+}
+
+// CountKeys counts the keys in [start, end) without copying them, stopping at limit when limit > 0.
+func (db *Database) CountKeys(ctx context.Context, start, end string, limit int) (int, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	var (
+		n   int
+		err error
+	)
+	db.mem.keys.ascend(start, end, func(key string) bool {
+		if n%scanCheckEvery == scanCheckEvery-1 {
+			if err = ctx.Err(); err != nil {
+				return false
+			}
+		}
+		n++
+		return limit <= 0 || n < limit
+	})
+
+	if err != nil {
+		return 0, err
+	}
+	return n, ctx.Err()
 }
 
 func applyHelper(db *Database, rec *Record) error {
@@ -425,11 +712,16 @@ func applyHelper(db *Database, rec *Record) error {
 		return err
 	}
 
-	if err := db.walFile.Sync(); err != nil {
-		return err
+	if db.syncMode == SyncAlways {
+		if err := db.walFile.Sync(); err != nil {
+			return err
+		}
 	}
 
-	applyRecord(db.mem, rec)
+	if err := applyRecord(db.mem, rec); err != nil {
+		return err
+	}
+	db.walRecords++
 
 	fstat, err := db.walFile.Stat()
 
@@ -437,7 +729,7 @@ func applyHelper(db *Database, rec *Record) error {
 		return err
 	}
 
-	if fstat.Size() > db.walSizeLimit {
+	if fstat.Size() > db.walSizeLimit || (db.snapshotEvery > 0 && db.walRecords >= db.snapshotEvery) {
 		if err := db.snapshot(); err != nil {
 			return err
 		}
@@ -0,0 +1,284 @@
+package database
+
+import (
+	"encoding/json"
+	"fmt"
+	"golangdb/errors_consts"
+	"maps"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/*
+   Foreign keys
+
+   A schema column can reference the "id" of another table (or of its own):
+     Column{Name: "customer_id", Type: TypeInt, References: &ForeignKey{Table: "customers", OnDelete: Cascade}}
+   Every write through writeRow checks that a non-null value names an existing row. When a
+   referenced row is deleted, the rows pointing at it are handled by OnDelete:
+     Restrict (default)  the delete fails while such rows exist
+     Cascade             they are deleted too, with their own references handled in turn
+     SetNull             the column is set to null (the column must be Nullable)
+   all inside the transaction of the delete, so either everything happens or nothing. The
+   rows deleted or set null run their delete or update hooks.
+
+   Deletes find the tables pointing at a table through one key per reference:
+     __fkref__:<table>\x00<referencing table>\x00<column> -> JSON ForeignKey
+   kept in step with the schemas by CreateTable, AlterTable, RenameTable and DropTable.
+   Referencing rows are found through an index on the column when there is one, by a scan
+   of the referencing table otherwise.
+*/
+
+const foreignKeyPrefix = "__fkref__:"
+
+type OnDelete string
+
+const (
+	Restrict OnDelete = "restrict"
+	Cascade  OnDelete = "cascade"
+	SetNull  OnDelete = "set null"
+)
+
+type ForeignKey struct {
+	Table    string   `json:"table"`
+	OnDelete OnDelete `json:"on_delete,omitempty"`
+}
+
+// reference is a foreign key as seen from the referenced table
+type reference struct {
+	Table  string
+	Column string
+	ForeignKey
+}
+
+func foreignKeyRefPrefix(table string) string {
+	return foreignKeyPrefix + table + "\x00"
+}
+
+func foreignKeyRefKey(table, child, column string) string {
+	return foreignKeyRefPrefix(table) + child + "\x00" + column
+}
+
+func validateForeignKey(col Column) error {
+	fk := col.References
+
+	if fk.Table == "" {
+		return fmt.Errorf("%w: column %s references no table", errors_consts.ErrInvalidSchema, col.Name)
+	}
+	if col.Type != TypeInt {
+		return fmt.Errorf("%w: column %s references %s and must be an int", errors_consts.ErrInvalidSchema, col.Name, fk.Table)
+	}
+
+	switch fk.OnDelete {
+	case "", Restrict, Cascade:
+	case SetNull:
+		if !col.Nullable {
+			return fmt.Errorf("%w: column %s is set null on delete and must be nullable", errors_consts.ErrInvalidSchema, col.Name)
+		}
+	default:
+		return fmt.Errorf("%w: column %s has unknown on delete action %q", errors_consts.ErrInvalidSchema, col.Name, fk.OnDelete)
+	}
+	return nil
+}
+
+// setReferences writes (or, with on unset, deletes) the reference keys of the foreign
+// keys declared by schema.
+func setReferences(tx *Tx, table string, schema *Schema, on bool) {
+	if schema == nil {
+		return
+	}
+
+	for _, col := range schema.Columns {
+		if col.References == nil {
+			continue
+		}
+
+		key := foreignKeyRefKey(col.References.Table, table, col.Name)
+		if on {
+			tx.Set(key, mustJson(col.References))
+		} else {
+			tx.Delete(key)
+		}
+	}
+}
+
+// referencesTo returns the foreign keys pointing at table, by referencing table and column
+func referencesTo(tx *Tx, table string) ([]reference, error) {
+	prefix := foreignKeyRefPrefix(table)
+
+	var refs []reference
+	for key, data := range tx.ScanPrefix(prefix) {
+		child, column, _ := strings.Cut(strings.TrimPrefix(key, prefix), "\x00")
+
+		ref := reference{Table: child, Column: column}
+		if err := json.Unmarshal(data, &ref.ForeignKey); err != nil {
+			return nil, err
+		}
+		refs = append(refs, ref)
+	}
+
+	// deletes apply the actions, and run their hooks, in a stable order
+	sort.Slice(refs, func(i, j int) bool {
+		if refs[i].Table != refs[j].Table {
+			return refs[i].Table < refs[j].Table
+		}
+		return refs[i].Column < refs[j].Column
+	})
+	return refs, nil
+}
+
+// referencedByOthers returns an error when a table other than table itself references it
+func referencedByOthers(tx *Tx, table string) error {
+	refs, err := referencesTo(tx, table)
+	if err != nil {
+		return err
+	}
+
+	for _, ref := range refs {
+		if ref.Table != table {
+			return fmt.Errorf("%w: %s.%s references %s", errors_consts.ErrForeignKeyViolation, ref.Table, ref.Column, table)
+		}
+	}
+	return nil
+}
+
+// checkReferences fails when a foreign key of row names a row that does not exist
+func checkReferences(tx *Tx, table string, schema *Schema, row map[string]any) error {
+	for _, col := range schema.Columns {
+		if col.References == nil || row[col.Name] == nil {
+			continue
+		}
+
+		id, err := parseID(row[col.Name])
+		if err != nil {
+			return err
+		}
+
+		if _, ok := tx.Get(rowKey(col.References.Table, fmt.Sprint(id))); !ok {
+			return fmt.Errorf("%w: %s.%s = %d has no row in %s", errors_consts.ErrForeignKeyViolation,
+				table, col.Name, id, col.References.Table)
+		}
+	}
+	return nil
+}
+
+// onDelete applies the OnDelete actions of the rows referencing the removed row table:id.
+func onDelete(tx *Tx, hooks *hookRun, table, id string) error {
+	refs, err := referencesTo(tx, table)
+	if err != nil {
+		return err
+	}
+
+	parent, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		// not a row id, nothing can reference it
+		return nil
+	}
+
+	for _, ref := range refs {
+		children, err := referencingRows(tx, ref, parent)
+		if err != nil {
+			return err
+		}
+
+		for _, child := range children {
+			switch ref.OnDelete {
+			case Cascade:
+				if hooks.watches(ref.Table, BeforeDelete) {
+					row, n, err := childRow(tx, ref.Table, child)
+					if err != nil {
+						return err
+					}
+					if row == nil {
+						continue
+					}
+					if err := hooks.before(tx, ref.Table, BeforeDelete, n, row, nil); err != nil {
+						return err
+					}
+				}
+				if err := removeRow(tx, hooks, ref.Table, child); err != nil {
+					return err
+				}
+
+			case SetNull:
+				row, n, err := childRow(tx, ref.Table, child)
+				if err != nil {
+					return err
+				}
+				if row == nil {
+					continue
+				}
+
+				var old map[string]any
+				if hooks.watches(ref.Table, BeforeUpdate) {
+					old = maps.Clone(row)
+				}
+				row[ref.Column] = nil
+				if err := hooks.before(tx, ref.Table, BeforeUpdate, n, row, old); err != nil {
+					return err
+				}
+				if err := writeRow(tx, ref.Table, child, row); err != nil {
+					return err
+				}
+
+			default:
+				return fmt.Errorf("%w: %s:%s is referenced by %s.%s of row %s", errors_consts.ErrForeignKeyViolation,
+					table, id, ref.Table, ref.Column, child)
+			}
+		}
+	}
+	return nil
+}
+
+// referencingRows returns the ids of the rows of ref.Table whose column holds parent
+func referencingRows(tx *Tx, ref reference, parent int64) ([]string, error) {
+	defs, err := loadIndexes(tx.Get, ref.Table)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, def := range defs {
+		if !def.sameFields([]string{ref.Column}) {
+			continue
+		}
+
+		enc, _ := encodeIndexValue(parent)
+		start := indexEntryKey(ref.Table, ref.Column, enc, "")
+
+		var ids []string
+		for _, key := range tx.ScanKeys(start, prefixEnd(start)) {
+			ids = append(ids, strings.TrimPrefix(key, start))
+		}
+		return ids, nil
+	}
+
+	var ids []string
+	for _, key := range rowKeys(tx, ref.Table) {
+		data, _ := tx.Get(key)
+		row, err := decodeRow(data)
+		if err != nil {
+			return nil, err
+		}
+
+		if v := row[ref.Column]; v != nil && compare("=", v, parent) {
+			id, _ := rowID(ref.Table, key)
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+// childRow reads the row table:id of a foreign key action; nil if it is gone
+func childRow(tx *Tx, table, id string) (map[string]any, int64, error) {
+	data, ok := tx.Get(rowKey(table, id))
+	if !ok {
+		return nil, 0, nil
+	}
+	row, err := decodeRow(data)
+	if err != nil {
+		return nil, 0, err
+	}
+	n, _ := strconv.ParseInt(id, 10, 64)
+	return row, n, nil
+}
@@ -0,0 +1,615 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"golangdb/errors_consts"
+	"math"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+/*
+   Full-text indexes
+
+   CreateTextIndex(table, field) indexes the words of a string field:
+     - text is split into words at everything that is not a letter or a digit,
+       lowercased, stop words ("the", "and", ...) are dropped and the rest is stemmed
+       ("running", "runs" -> "run") so that forms of a word find each other
+     - the inverted index lives in the core next to the rows:
+         __fts__:<table>\x00<field>\x00<term>\x00<id>  -> JSON positions of term in the field
+         __ftsdoc__:<table>\x00<field>\x00<id>         -> number of terms of the field
+         __ftsstat__:<table>\x00<field>                -> JSON {docs, terms} for BM25
+         __ftsmeta__:<table>                           -> JSON list of indexed fields
+       and is maintained by writeRow/removeRow in the write transaction, like other indexes.
+
+   Queries are words and "quoted phrases"; a row matches when its field holds every word
+   and every phrase (words of a phrase in order and next to each other, stop words in
+   between counted). Match(field, query) is a where condition; with a text index on field
+   the planner reads the postings of the rarest term instead of the table. Search ranks the
+   matching rows by BM25 (k1 = 1.2, b = 0.75).
+*/
+
+const (
+	textPrefix     = "__fts__:"
+	textDocPrefix  = "__ftsdoc__:"
+	textStatPrefix = "__ftsstat__:"
+	textMetaPrefix = "__ftsmeta__:"
+
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+var stopWords = map[string]bool{}
+
+func init() {
+	for _, w := range strings.Fields(`a an and are as at be but by for if in into is it its no not of on
+		or such that the their then there these they this to was were will with`) {
+		stopWords[w] = true
+	}
+}
+
+type textToken struct {
+	term string
+	pos  int
+}
+
+// tokenize returns the terms of s with their word positions
+func tokenize(s string) []textToken {
+	words := strings.FieldsFunc(s, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	var out []textToken
+	for pos, word := range words {
+		word = strings.ToLower(word)
+		if !stopWords[word] {
+			out = append(out, textToken{term: stem(word), pos: pos})
+		}
+	}
+	return out
+}
+
+// stem strips common English suffixes. It only has to map the forms of a word to the
+// same term, since documents and queries go through it alike.
+func stem(w string) string {
+	cut := func(suffix, repl string, keep int) (string, bool) {
+		base, ok := strings.CutSuffix(w, suffix)
+		if !ok || len([]rune(base)) < keep {
+			return w, false
+		}
+		return base + repl, true
+	}
+
+	for _, rule := range []struct {
+		suffix, repl string
+		keep         int
+	}{
+		{"sses", "ss", 2}, {"ies", "y", 2}, {"xes", "x", 1}, {"ches", "ch", 1}, {"shes", "sh", 1}, {"ingly", "", 3}, {"edly", "", 3},
+		{"ing", "", 3}, {"ed", "", 3}, {"ly", "", 3},
+	} {
+		if s, ok := cut(rule.suffix, rule.repl, rule.keep); ok {
+			return undouble(s)
+		}
+	}
+
+	if strings.HasSuffix(w, "s") && !strings.HasSuffix(w, "ss") && !strings.HasSuffix(w, "us") && !strings.HasSuffix(w, "is") && len(w) > 3 {
+		return w[:len(w)-1]
+	}
+	return w
+}
+
+// undouble turns "runn" (from "running") into "run"
+func undouble(s string) string {
+	n := len(s)
+	if n >= 3 && s[n-1] == s[n-2] && !strings.ContainsRune("aeioulsz", rune(s[n-1])) {
+		return s[:n-1]
+	}
+	return s
+}
+
+// analyze returns the positions of every term of s and the number of terms
+func analyze(s string) (map[string][]int, int) {
+	tokens := tokenize(s)
+
+	positions := make(map[string][]int)
+	for _, t := range tokens {
+		positions[t.term] = append(positions[t.term], t.pos)
+	}
+	return positions, len(tokens)
+}
+
+/*
+   Queries
+*/
+
+type textQuery struct {
+	// distinct terms of words and phrases
+	terms []string
+	// phrases of more than one term, positions relative to the phrase
+	phrases [][]textToken
+}
+
+func parseTextQuery(q string) *textQuery {
+	tq := &textQuery{}
+
+	for i, part := range strings.Split(q, `"`) {
+		tokens := tokenize(part)
+		for _, t := range tokens {
+			if !slices.Contains(tq.terms, t.term) {
+				tq.terms = append(tq.terms, t.term)
+			}
+		}
+
+		// odd parts are inside quotes
+		if i%2 == 1 && len(tokens) > 1 {
+			tq.phrases = append(tq.phrases, tokens)
+		}
+	}
+	return tq
+}
+
+// matches reports whether a field with these term positions holds every term and phrase.
+// A query without terms (only stop words) matches nothing.
+func (tq *textQuery) matches(positions map[string][]int) bool {
+	if len(tq.terms) == 0 {
+		return false
+	}
+
+	for _, term := range tq.terms {
+		if len(positions[term]) == 0 {
+			return false
+		}
+	}
+
+	for _, phrase := range tq.phrases {
+		if !phraseAt(phrase, positions) {
+			return false
+		}
+	}
+	return true
+}
+
+func phraseAt(phrase []textToken, positions map[string][]int) bool {
+	first := phrase[0]
+
+	for _, start := range positions[first.term] {
+		found := true
+		for _, t := range phrase[1:] {
+			if !slices.Contains(positions[t.term], start+t.pos-first.pos) {
+				found = false
+				break
+			}
+		}
+		if found {
+			return true
+		}
+	}
+	return false
+}
+
+// matchText evaluates a "match" condition against a field value
+func matchText(value any, tq *textQuery) bool {
+	s, ok := value.(string)
+	if !ok {
+		return false
+	}
+
+	positions, _ := analyze(s)
+	return tq.matches(positions)
+}
+
+// Match is the condition "field matches the words and phrases of query".
+func Match(field, query string) *Condition {
+	return Cond(field, "match", query)
+}
+
+/*
+   Index maintenance
+*/
+
+type textStats struct {
+	Docs  int64 `json:"docs"`
+	Terms int64 `json:"terms"`
+}
+
+func textMetaKey(table string) string {
+	return textMetaPrefix + table
+}
+
+func textFieldPrefix(table, field string) string {
+	return textPrefix + table + "\x00" + field + "\x00"
+}
+
+func textTermPrefix(table, field, term string) string {
+	return textFieldPrefix(table, field) + term + "\x00"
+}
+
+func textDocKey(table, field, id string) string {
+	return textDocPrefix + table + "\x00" + field + "\x00" + id
+}
+
+func textStatKey(table, field string) string {
+	return textStatPrefix + table + "\x00" + field
+}
+
+func loadTextFields(get func(string) ([]byte, bool), table string) ([]string, error) {
+	raw, ok := get(textMetaKey(table))
+	if !ok {
+		return nil, nil
+	}
+
+	var fields []string
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return nil, err
+	}
+	return fields, nil
+}
+
+func loadTextStats(get func(string) ([]byte, bool), table, field string) (textStats, error) {
+	var stats textStats
+
+	raw, ok := get(textStatKey(table, field))
+	if !ok {
+		return stats, nil
+	}
+	err := json.Unmarshal(raw, &stats)
+	return stats, err
+}
+
+// indexTextValue adds (sign 1) or removes (sign -1) the terms of value for row id
+func indexTextValue(tx *Tx, table, field, id string, value any, sign int64) error {
+	s, ok := value.(string)
+	if !ok {
+		return nil
+	}
+
+	positions, length := analyze(s)
+
+	for term, pos := range positions {
+		key := textTermPrefix(table, field, term) + id
+		if sign > 0 {
+			tx.Set(key, mustJson(pos))
+		} else {
+			tx.Delete(key)
+		}
+	}
+
+	if sign > 0 {
+		tx.Set(textDocKey(table, field, id), []byte(strconv.Itoa(length)))
+	} else {
+		tx.Delete(textDocKey(table, field, id))
+	}
+
+	stats, err := loadTextStats(tx.Get, table, field)
+	if err != nil {
+		return err
+	}
+	stats.Docs += sign
+	stats.Terms += sign * int64(length)
+	tx.Set(textStatKey(table, field), mustJson(stats))
+	return nil
+}
+
+// updateText moves the text index entries of table:id from the stored row to row; a nil
+// row removes them. Call it before the row itself is written.
+func updateText(tx *Tx, table, id string, row map[string]any) error {
+	fields, err := loadTextFields(tx.Get, table)
+	if err != nil || len(fields) == 0 {
+		return err
+	}
+
+	var old map[string]any
+	if raw, ok := tx.Get(rowKey(table, id)); ok {
+		if old, err = decodeRow(raw); err != nil {
+			return err
+		}
+	}
+
+	for _, field := range fields {
+		before, after := fieldValue(old, field), fieldValue(row, field)
+		if s, ok := before.(string); ok && s == after {
+			continue
+		}
+
+		if err := indexTextValue(tx, table, field, id, before, -1); err != nil {
+			return err
+		}
+		if err := indexTextValue(tx, table, field, id, after, 1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// clearText deletes the text index data of field
+func clearText(tx *Tx, table, field string) {
+	for _, prefix := range []string{textFieldPrefix(table, field), textDocPrefix + table + "\x00" + field + "\x00"} {
+		for _, key := range tx.ScanKeys(prefix, prefixEnd(prefix)) {
+			tx.Delete(key)
+		}
+	}
+	tx.Delete(textStatKey(table, field))
+}
+
+// backfillText indexes field for every row of table
+func backfillText(tx *Tx, table, field string) error {
+	for _, key := range rowKeys(tx, table) {
+		id, _ := rowID(table, key)
+		data, _ := tx.Get(key)
+
+		row, err := decodeRow(data)
+		if err != nil {
+			return err
+		}
+		if err := indexTextValue(tx, table, field, id, fieldValue(row, field), 1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CreateTextIndex builds a full-text index on field (a top-level field or a path) of table.
+func (db *DB) CreateTextIndex(table, field string) error {
+	if table == "" {
+		return errors_consts.ErrEmptyName
+	}
+	if err := validateIndexFields([]string{field}); err != nil {
+		return err
+	}
+
+	return db.Database.Atomic(func(tx *Tx) error {
+		fields, err := loadTextFields(tx.Get, table)
+		if err != nil {
+			return err
+		}
+		if slices.Contains(fields, field) {
+			return fmt.Errorf("%w: text %s(%s)", errors_consts.ErrIndexExists, table, field)
+		}
+
+		if err := backfillText(tx, table, field); err != nil {
+			return err
+		}
+		tx.Set(textMetaKey(table), mustJson(append(fields, field)))
+		return nil
+	})
+}
+
+func (db *DB) DropTextIndex(table, field string) error {
+	return db.Database.Atomic(func(tx *Tx) error {
+		fields, err := loadTextFields(tx.Get, table)
+		if err != nil {
+			return err
+		}
+		if !slices.Contains(fields, field) {
+			return fmt.Errorf("%w: text %s(%s)", errors_consts.ErrIndexNotFound, table, field)
+		}
+
+		clearText(tx, table, field)
+
+		fields = slices.DeleteFunc(fields, func(f string) bool { return f == field })
+		if len(fields) == 0 {
+			tx.Delete(textMetaKey(table))
+		} else {
+			tx.Set(textMetaKey(table), mustJson(fields))
+		}
+		return nil
+	})
+}
+
+// ListTextIndexes returns the fields of table with a full-text index.
+func (db *DB) ListTextIndexes(table string) ([]string, error) {
+	return loadTextFields(db.Database.Get, table)
+}
+
+/*
+   Reads
+*/
+
+// textRange returns the postings of the rarest term of tq, for the planner
+func (db *DB) textRange(ctx context.Context, table string, w *WhereClause, limit int) (keyRange, int, error) {
+	best, bestCount := keyRange{}, -1
+
+	for _, term := range w.text.terms {
+		start := textTermPrefix(table, w.field, term)
+		r := keyRange{start: start, end: prefixEnd(start)}
+
+		n, err := db.Database.CountKeys(ctx, r.start, r.end, limit)
+		if err != nil {
+			return keyRange{}, 0, err
+		}
+		if bestCount < 0 || n < bestCount {
+			best, bestCount = r, n
+		}
+	}
+	return best, max(bestCount, 0), nil
+}
+
+// SearchHit is a row found by Search with its BM25 score.
+type SearchHit struct {
+	ID    int64          `json:"id"`
+	Score float64        `json:"score"`
+	Row   map[string]any `json:"row"`
+}
+
+type SearchQuery struct {
+	db     *DB
+	table  string
+	field  string
+	query  string
+	limit  int
+	offset int
+	filter
+}
+
+// Search ranks the rows of a table matching a full-text query:
+//
+//	db.Search().Table("notes").Match("body", `"quick fox" jumps`).Limit(10).All()
+//
+// The field needs a text index. Where conditions further filter the matching rows.
+func (db *DB) Search() *SearchQuery {
+	return &SearchQuery{db: db}
+}
+
+func (q *SearchQuery) Table(name string) *SearchQuery {
+	q.table = name
+	return q
+}
+
+func (q *SearchQuery) Match(field, query string) *SearchQuery {
+	q.field, q.query = field, query
+	return q
+}
+
+func (q *SearchQuery) Where(field, op string, value any) *SearchQuery {
+	q.add(Cond(field, op, value))
+	return q
+}
+
+func (q *SearchQuery) WhereCond(cond *Condition) *SearchQuery {
+	q.add(cond)
+	return q
+}
+
+func (q *SearchQuery) Limit(n int) *SearchQuery {
+	if n < 0 {
+		q.err = fmt.Errorf("%w: negative limit %d", errors_consts.ErrInvalidQuery, n)
+		return q
+	}
+	q.limit = n
+	return q
+}
+
+func (q *SearchQuery) Offset(n int) *SearchQuery {
+	if n < 0 {
+		q.err = fmt.Errorf("%w: negative offset %d", errors_consts.ErrInvalidQuery, n)
+		return q
+	}
+	q.offset = n
+	return q
+}
+
+func (q *SearchQuery) All() ([]SearchHit, error) {
+	return q.AllContext(context.Background())
+}
+
+// AllContext returns the hits by descending score, ties by ascending id.
+func (q *SearchQuery) AllContext(ctx context.Context) ([]SearchHit, error) {
+	if q.err != nil {
+		return nil, q.err
+	}
+	if q.table == "" {
+		return nil, errors_consts.ErrEmptyName
+	}
+	if q.field == "" {
+		return nil, fmt.Errorf("%w: search needs a field to match", errors_consts.ErrInvalidQuery)
+	}
+
+	fields, err := loadTextFields(q.db.Database.Get, q.table)
+	if err != nil {
+		return nil, err
+	}
+	if !slices.Contains(fields, q.field) {
+		return nil, fmt.Errorf("%w: no text index on %s(%s)", errors_consts.ErrIndexNotFound, q.table, q.field)
+	}
+
+	tq := parseTextQuery(q.query)
+	if len(tq.terms) == 0 {
+		return []SearchHit{}, nil
+	}
+
+	stats, err := loadTextStats(q.db.Database.Get, q.table, q.field)
+	if err != nil {
+		return nil, err
+	}
+	avgLen := 1.0
+	if stats.Docs > 0 && stats.Terms > 0 {
+		avgLen = float64(stats.Terms) / float64(stats.Docs)
+	}
+
+	// postings per term: id -> positions
+	postings := make(map[string]map[string][]int, len(tq.terms))
+	for _, term := range tq.terms {
+		prefix := textTermPrefix(q.table, q.field, term)
+
+		raw, err := q.db.Database.ScanPrefixContext(ctx, prefix)
+		if err != nil {
+			return nil, err
+		}
+
+		ids := make(map[string][]int, len(raw))
+		for key, data := range raw {
+			var pos []int
+			if err := json.Unmarshal(data, &pos); err != nil {
+				return nil, err
+			}
+			ids[strings.TrimPrefix(key, prefix)] = pos
+		}
+		postings[term] = ids
+	}
+
+	var hits []SearchHit
+	for id := range postings[tq.terms[0]] {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		positions := make(map[string][]int, len(tq.terms))
+		for _, term := range tq.terms {
+			positions[term] = postings[term][id]
+		}
+		if !tq.matches(positions) {
+			continue
+		}
+
+		data, ok := q.db.Database.Get(rowKey(q.table, id))
+		if !ok {
+			continue
+		}
+		row, err := decodeRow(data)
+		if err != nil {
+			return nil, err
+		}
+		if !q.matches(row) {
+			continue
+		}
+
+		length := avgLen
+		if raw, ok := q.db.Database.Get(textDocKey(q.table, q.field, id)); ok {
+			if n, err := strconv.Atoi(string(raw)); err == nil {
+				length = float64(n)
+			}
+		}
+
+		score := 0.0
+		for _, term := range tq.terms {
+			n := float64(len(postings[term]))
+			idf := math.Log(1 + (float64(stats.Docs)-n+0.5)/(n+0.5))
+			tf := float64(len(positions[term]))
+			score += idf * tf * (bm25K1 + 1) / (tf + bm25K1*(1-bm25B+bm25B*length/avgLen))
+		}
+
+		n, _ := strconv.ParseInt(id, 10, 64)
+		hits = append(hits, SearchHit{ID: n, Score: score, Row: row})
+	}
+
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+		return hits[i].ID < hits[j].ID
+	})
+
+	if q.offset >= len(hits) {
+		return []SearchHit{}, nil
+	}
+	hits = hits[q.offset:]
+	if q.limit > 0 && q.limit < len(hits) {
+		hits = hits[:q.limit]
+	}
+	return hits, nil
+}
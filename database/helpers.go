@@ -2,89 +2,339 @@ package database
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
 )
 
 /*
    ===== Validation =====
 */
 
+// isAllowedValue accepts scalars and nested arrays/objects of them (null allowed inside)
 func isAllowedValue(value any) bool {
-	switch value.(type) {
-	case string, int, int64, float64, bool:
+	if isScalarValue(value) {
 		return true
-	default:
+	}
+
+	rv := reflect.ValueOf(value)
+	if !rv.IsValid() {
 		return false
 	}
+
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < rv.Len(); i++ {
+			if !isAllowedNested(rv.Index(i).Interface()) {
+				return false
+			}
+		}
+		return true
+
+	case reflect.Map:
+		if rv.Type().Key().Kind() != reflect.String {
+			return false
+		}
+		iter := rv.MapRange()
+		for iter.Next() {
+			if !isAllowedNested(iter.Value().Interface()) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+func isAllowedNested(value any) bool {
+	return value == nil || isAllowedValue(value)
+}
+
+// isScalarValue accepts the values conditions compare against
+func isScalarValue(value any) bool {
+	switch value.(type) {
+	case string, bool, time.Time, []byte, UUID:
+		return true
+	}
+	return isNumber(value)
 }
 
 /*
    ===== Where logic =====
 */
 
+// operators and the kind of value they expect
+const (
+	opValueScalar  = iota // a single allowed value
+	opValueList           // IN / NOT IN: a list of allowed values
+	opValueRange          // BETWEEN: [low, high], both inclusive
+	opValuePattern        // LIKE-family and prefix/substring matches: a string
+	opValueNone           // EXISTS / IS NULL: the value is ignored
+)
+
+var whereOperators = map[string]int{
+	"=":              opValueScalar,
+	"!=":             opValueScalar,
+	"<":              opValueScalar,
+	">":              opValueScalar,
+	"<=":             opValueScalar,
+	">=":             opValueScalar,
+	"in":             opValueList,
+	"not in":         opValueList,
+	"between":        opValueRange,
+	"like":           opValuePattern,
+	"ilike":          opValuePattern,
+	"starts_with":    opValuePattern,
+	"contains":       opValuePattern,
+	"match":          opValuePattern,
+	"array_contains": opValueScalar,
+	"any":            opValueList,
+	"exists":         opValueNone,
+	"not exists":     opValueNone,
+	"is null":        opValueNone,
+	"is not null":    opValueNone,
+}
+
+func newWhereClause(field, op string, value any) (*WhereClause, error) {
+	if field == "" {
+		return nil, errors.New("field is empty")
+	}
+
+	op = strings.ToLower(strings.Join(strings.Fields(op), " "))
+
+	kind, ok := whereOperators[op]
+	if !ok {
+		return nil, fmt.Errorf("unsupported operator %s", op)
+	}
+
+	w := &WhereClause{
+		field:    field,
+		operator: op,
+	}
+
+	if isPath(field) {
+		path, err := parseFieldPath(field)
+		if err != nil {
+			return nil, err
+		}
+		w.path = path
+	}
+
+	value, err := untagValue(value)
+	if err != nil {
+		return nil, err
+	}
+
+	switch kind {
+	case opValueScalar:
+		if !isScalarValue(value) {
+			return nil, fmt.Errorf("unsupported value type %T", value)
+		}
+		w.value = value
+
+	case opValueList:
+		list, ok := toList(value)
+		if !ok {
+			return nil, fmt.Errorf("operator %s needs a list of values, got %T", op, value)
+		}
+		w.value = list
+
+	case opValueRange:
+		list, ok := toList(value)
+		if !ok || len(list) != 2 {
+			return nil, fmt.Errorf("operator between needs [low, high], got %v", value)
+		}
+		w.value = list
+
+	case opValuePattern:
+		str, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("operator %s needs a string, got %T", op, value)
+		}
+		w.value = str
+		if op == "like" || op == "ilike" {
+			w.pattern = likePattern(str, op == "ilike")
+		}
+		if op == "match" {
+			w.text = parseTextQuery(str)
+		}
+	}
+
+	return w, nil
+}
+
+// toList accepts []any and typed slices of scalar values
+func toList(value any) ([]any, bool) {
+	rv := reflect.ValueOf(value)
+	if !rv.IsValid() || rv.Kind() != reflect.Slice {
+		return nil, false
+	}
+
+	out := make([]any, rv.Len())
+	for i := range out {
+		item := rv.Index(i).Interface()
+		if !isScalarValue(item) {
+			return nil, false
+		}
+		out[i] = item
+	}
+	return out, true
+}
+
+// likePattern translates SQL LIKE (% = any run, _ = one character, \ escapes) to an anchored regexp
+func likePattern(pattern string, insensitive bool) *regexp.Regexp {
+	var b strings.Builder
+
+	if insensitive {
+		b.WriteString("(?is)")
+	} else {
+		b.WriteString("(?s)")
+	}
+	b.WriteByte('^')
+
+	escaped := false
+	for _, r := range pattern {
+		switch {
+		case escaped:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+			escaped = false
+		case r == '\\':
+			escaped = true
+		case r == '%':
+			b.WriteString(".*")
+		case r == '_':
+			b.WriteByte('.')
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	if escaped {
+		b.WriteString(regexp.QuoteMeta("\\"))
+	}
+
+	b.WriteByte('$')
+	return regexp.MustCompile(b.String())
+}
+
 func (w *WhereClause) match(row map[string]any) bool {
 	value, ok := row[w.field]
+	if !ok && w.path != nil {
+		value, ok = w.path.lookup(row)
+	}
+
+	switch w.operator {
+	case "exists":
+		return ok
+	case "not exists":
+		return !ok
+	case "is null":
+		return !ok || value == nil
+	case "is not null":
+		return ok && value != nil
+	}
+
 	if !ok {
 		return false
 	}
+
+	switch w.operator {
+	case "in", "not in":
+		found := false
+		for _, item := range w.value.([]any) {
+			if compare("=", value, item) {
+				found = true
+				break
+			}
+		}
+		return found == (w.operator == "in")
+
+	case "between":
+		bounds := w.value.([]any)
+		return compare(">=", value, bounds[0]) && compare("<=", value, bounds[1])
+
+	case "like", "ilike":
+		str, ok := value.(string)
+		return ok && w.pattern.MatchString(str)
+
+	case "starts_with":
+		str, ok := value.(string)
+		return ok && strings.HasPrefix(str, w.value.(string))
+
+	case "contains":
+		str, ok := value.(string)
+		return ok && strings.Contains(str, w.value.(string))
+
+	case "match":
+		return matchText(value, w.text)
+
+	case "array_contains", "any":
+		items, ok := elements(value)
+		if !ok {
+			return false
+		}
+		wanted := []any{w.value}
+		if w.operator == "any" {
+			wanted = w.value.([]any)
+		}
+		for _, item := range items {
+			for _, v := range wanted {
+				if compare("=", item, v) {
+					return true
+				}
+			}
+		}
+		return false
+	}
+
 	return compare(w.operator, value, w.value)
 }
 
 func compare(op string, left, right any) bool {
-	l := normalizeNumber(left)
-	r := normalizeNumber(right)
+	if l, ok := toNumber(left); ok {
+		r, ok := toNumber(right)
+		return ok && compareOrdered(op, compareNumbers(l, r))
+	}
 
-	switch lv := l.(type) {
+	switch lv := left.(type) {
 	case string:
-		rv, ok := r.(string)
+		rv, ok := right.(string)
 		if !ok {
 			return false
 		}
 		return compareStrings(op, lv, rv)
 
-	case float64:
-		rv, ok := r.(float64)
-		if !ok {
-			return false
-		}
-		return compareNumbers(op, lv, rv)
-
 	case bool:
-		rv, ok := r.(bool)
+		rv, ok := right.(bool)
 		if !ok {
 			return false
 		}
 		return compareBools(op, lv, rv)
 	}
 
-	return false
-}
-
-func normalizeNumber(v any) any {
-	switch n := v.(type) {
-	case json.Number:
-		f, _ := n.Float64()
-		return f
-	case int:
-		return float64(n)
-	case int64:
-		return float64(n)
-	case float64:
-		return n
-	default:
-		return v
+	if c, ok := compareTyped(left, right); ok {
+		return compareOrdered(op, c)
 	}
+	return false
 }
 
-func compareNumbers(op string, a, b float64) bool {
+// compareOrdered applies op to the result c of a three-way comparison
+func compareOrdered(op string, c int) bool {
 	switch op {
 	case "=":
-		return a == b
+		return c == 0
 	case "!=":
-		return a != b
+		return c != 0
 	case "<":
-		return a < b
+		return c < 0
 	case ">":
-		return a > b
+		return c > 0
+	case "<=":
+		return c <= 0
+	case ">=":
+		return c >= 0
 	}
 	return false
 }
@@ -99,6 +349,10 @@ func compareStrings(op, a, b string) bool {
 		return a < b
 	case ">":
 		return a > b
+	case "<=":
+		return a <= b
+	case ">=":
+		return a >= b
 	}
 	return false
 }
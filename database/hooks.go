@@ -0,0 +1,172 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"golangdb/errors_consts"
+	"maps"
+)
+
+/*
+   Hooks
+
+   db.On("orders", database.BeforeInsert, func(ctx context.Context, ev *database.HookEvent) error {
+       ev.Row["status"] = "new"
+       return nil
+   })
+
+   Hooks are Go callbacks registered per table and per point of a write. They run for the
+   rows written by Insert, InsertMany, Upsert, Update and Delete (and the SQL statements
+   built on them), in the order they were registered:
+
+     Before* run inside the write transaction, before the row is checked against the schema
+       and stored. They may change ev.Row, and an error vetoes the write: the statement
+       fails and nothing of its transaction is stored. ev.Tx joins the same transaction,
+       so keys written through it (counters, denormalized copies) are stored atomically
+       with the row. The database lock is held: they must not call methods of DB.
+     After* run once the transaction committed and see the stored row. They may use the
+       DB freely; an error is returned to the caller, but the write stays committed.
+
+   Rows deleted or set null by a foreign key action run the delete or update hooks of their
+   table, inside the transaction of the delete that caused them.
+*/
+
+type HookPoint uint8
+
+const (
+	BeforeInsert HookPoint = iota + 1
+	AfterInsert
+	BeforeUpdate
+	AfterUpdate
+	BeforeDelete
+	AfterDelete
+)
+
+func (p HookPoint) String() string {
+	switch p {
+	case BeforeInsert:
+		return "before insert"
+	case AfterInsert:
+		return "after insert"
+	case BeforeUpdate:
+		return "before update"
+	case AfterUpdate:
+		return "after update"
+	case BeforeDelete:
+		return "before delete"
+	case AfterDelete:
+		return "after delete"
+	}
+	return fmt.Sprintf("HookPoint(%d)", uint8(p))
+}
+
+// after returns the After* point matching a Before* point
+func (p HookPoint) after() HookPoint {
+	return p + 1
+}
+
+type HookEvent struct {
+	Table string
+	Point HookPoint
+	ID    int64
+	// the row to store (inserts, updates) or the deleted row (deletes)
+	Row map[string]any
+	// the row before an update
+	Old map[string]any
+	// the write transaction; nil in After* hooks
+	Tx *Tx
+}
+
+type Hook func(ctx context.Context, ev *HookEvent) error
+
+type hookKey struct {
+	table string
+	point HookPoint
+}
+
+// On registers hook to run at point for writes to table.
+func (db *DB) On(table string, point HookPoint, hook Hook) {
+	db.hookMu.Lock()
+	defer db.hookMu.Unlock()
+
+	if db.hooks == nil {
+		db.hooks = make(map[hookKey][]Hook)
+	}
+	key := hookKey{table, point}
+	db.hooks[key] = append(db.hooks[key], hook)
+}
+
+func (db *DB) hooksFor(table string, point HookPoint) []Hook {
+	db.hookMu.RLock()
+	defer db.hookMu.RUnlock()
+
+	return db.hooks[hookKey{table, point}]
+}
+
+// hookRun carries the hooks of one statement: before-hooks run as rows are written, the
+// matching after-events wait until their transaction committed.
+type hookRun struct {
+	db      *DB
+	ctx     context.Context
+	pending []*HookEvent
+}
+
+func (db *DB) newHookRun(ctx context.Context) *hookRun {
+	return &hookRun{db: db, ctx: ctx}
+}
+
+// watches reports whether any hook is registered for the point or its after-point
+func (h *hookRun) watches(table string, point HookPoint) bool {
+	return h != nil && (len(h.db.hooksFor(table, point)) > 0 || len(h.db.hooksFor(table, point.after())) > 0)
+}
+
+// before runs the Before* hooks of the row and queues its After* event. row is the map
+// that will be stored, so changes of the hooks are written.
+func (h *hookRun) before(tx *Tx, table string, point HookPoint, id int64, row, old map[string]any) error {
+	if !h.watches(table, point) {
+		return nil
+	}
+
+	ev := &HookEvent{Table: table, Point: point, ID: id, Row: row, Old: old, Tx: tx}
+	for _, hook := range h.db.hooksFor(table, point) {
+		if err := hook(h.ctx, ev); err != nil {
+			return fmt.Errorf("%s hook of %s: %w", point, table, err)
+		}
+	}
+
+	if point != BeforeDelete {
+		given, ok := row["id"]
+		if !ok {
+			row["id"] = id
+		} else if n, err := parseID(given); err != nil || n != id {
+			return errors_consts.ErrUpdateID
+		}
+	}
+
+	if len(h.db.hooksFor(table, point.after())) > 0 {
+		h.pending = append(h.pending, &HookEvent{Table: table, Point: point.after(), ID: id, Row: row, Old: old})
+	}
+	return nil
+}
+
+// after runs the queued After* hooks; call it once the transaction committed.
+func (h *hookRun) after() error {
+	if h == nil {
+		return nil
+	}
+
+	events := h.pending
+	h.pending = nil
+
+	for _, ev := range events {
+		// the stored row, not the map the caller may still change
+		ev.Row = maps.Clone(ev.Row)
+
+		for _, hook := range h.db.hooksFor(ev.Table, ev.Point) {
+			if err := hook(h.ctx, ev); err != nil {
+				return fmt.Errorf("%s hook of %s: %w", ev.Point, ev.Table, err)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,655 @@
+package database
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"golangdb/errors_consts"
+	"slices"
+	"sort"
+	"strings"
+)
+
+/*
+   Secondary indexes and unique constraints
+
+   Index definitions of a table live in one catalog key:
+     __idxmeta__:<table>                            -> JSON list of IndexInfo
+   and every indexed row has one entry per single-field index:
+     __idx__:<table>:<field>:<encoded value>\x00<id> -> empty value
+
+   Encoded values start with a type tag (bool < number < string < timestamp < bytes <
+   uuid, the same order compareValues uses) and sort in value order (numbers exactly,
+   see numbers.go), so "=" and range predicates become ordered key scans over the
+   core's sorted key index. Only scalar values are indexed; rows where the field is
+   missing or null have no entry.
+
+   A unique index (single or compound) also owns one key per distinct value tuple:
+     __uniq__:<table>:<f1,f2,...>:\x01<len>:<encoded value>... -> id
+   Rows with a missing or null field in the tuple are not constrained, like NULLs in SQL.
+
+   Row writes that go through writeRow/removeRow update the row, its index entries and its
+   unique keys in one Database.Atomic transaction, so the uniqueness check and the write
+   cannot interleave with another writer.
+*/
+
+const (
+	indexPrefix     = "__idx__:"
+	indexMetaPrefix = "__idxmeta__:"
+	uniquePrefix    = "__uniq__:"
+
+	// version of the key encoding, see UpgradeIndexes
+	indexFormatKey = "__Meta__:index_format"
+	indexFormat    = 2
+)
+
+// type tags of encoded index values
+const (
+	indexTagBool   = '\x02'
+	indexTagNumber = '\x03'
+	indexTagString = '\x04'
+	indexTagTime   = '\x05'
+	indexTagBytes  = '\x06'
+	indexTagUUID   = '\x07'
+
+	uniqueTupleTag = '\x01'
+)
+
+type IndexInfo struct {
+	Table  string   `json:"table"`
+	Fields []string `json:"fields"`
+	Unique bool     `json:"unique,omitempty"`
+}
+
+// Name is the comma separated field list, the way the index is addressed in keys.
+func (i IndexInfo) Name() string {
+	return strings.Join(i.Fields, ",")
+}
+
+func (i IndexInfo) sameFields(fields []string) bool {
+	return slices.Equal(i.Fields, fields)
+}
+
+// UniqueViolationError is returned when a write would give two rows the same values
+// under a unique index. It matches errors_consts.ErrUniqueViolation with errors.Is.
+type UniqueViolationError struct {
+	Table  string
+	Fields []string
+	Values []any
+	// ID of the row that already holds the values
+	ID string
+}
+
+func (e *UniqueViolationError) Error() string {
+	values := make([]string, len(e.Values))
+	for i, v := range e.Values {
+		values[i] = fmt.Sprintf("%#v", v)
+		if isNumber(v) {
+			values[i] = fmt.Sprint(v)
+		}
+	}
+	return fmt.Sprintf("%v: %s(%s) = (%s)", errors_consts.ErrUniqueViolation, e.Table,
+		strings.Join(e.Fields, ", "), strings.Join(values, ", "))
+}
+
+func (e *UniqueViolationError) Unwrap() error {
+	return errors_consts.ErrUniqueViolation
+}
+
+func encodeIndexValue(v any) (string, bool) {
+	if n, ok := toNumber(v); ok {
+		return string(indexTagNumber) + n.parts().key(), true
+	}
+
+	switch n := v.(type) {
+	case bool:
+		if n {
+			return string(indexTagBool) + "1", true
+		}
+		return string(indexTagBool) + "0", true
+	case string:
+		return string(indexTagString) + n, true
+	}
+	return typedKey(v)
+}
+
+func indexEntryPrefix(table, field string) string {
+	return indexPrefix + table + ":" + field + ":"
+}
+
+func indexEntryKey(table, field, encoded, id string) string {
+	return indexEntryPrefix(table, field) + encoded + "\x00" + id
+}
+
+func indexMetaKey(table string) string {
+	return indexMetaPrefix + table
+}
+
+func uniqueKeyPrefix(table string, def IndexInfo) string {
+	return uniquePrefix + table + ":" + def.Name() + ":"
+}
+
+// uniqueKey returns the key owning row's value tuple under def, or false when one of the
+// fields is missing, null or not a scalar.
+func uniqueKey(table string, def IndexInfo, row map[string]any) (string, bool) {
+	var b strings.Builder
+
+	b.WriteString(uniqueKeyPrefix(table, def))
+	b.WriteByte(uniqueTupleTag)
+
+	for _, field := range def.Fields {
+		enc, ok := encodeIndexValue(fieldValue(row, field))
+		if !ok {
+			return "", false
+		}
+		fmt.Fprintf(&b, "%d:%s", len(enc), enc)
+	}
+	return b.String(), true
+}
+
+// ownedKeys returns the keys in prefix that belong to the index itself. Table names may
+// contain ':', so the prefix of table "t" can also cover keys of a table "t:x"; those are
+// told apart by the tag byte that follows the prefix.
+func ownedKeys(tx *Tx, prefix string, first, last byte) []string {
+	var out []string
+	for _, key := range tx.ScanKeys(prefix, prefixEnd(prefix)) {
+		if len(key) > len(prefix) && key[len(prefix)] >= first && key[len(prefix)] <= last {
+			out = append(out, key)
+		}
+	}
+	return out
+}
+
+func validateIndexFields(fields []string) error {
+	if len(fields) == 0 {
+		return fmt.Errorf("%w: index has no fields", errors_consts.ErrInvalidQuery)
+	}
+
+	seen := make(map[string]bool, len(fields))
+	for _, field := range fields {
+		if field == "" {
+			return fmt.Errorf("%w: index field is empty", errors_consts.ErrInvalidQuery)
+		}
+		for _, r := range field {
+			if r == ':' || r == ',' || r < ' ' {
+				return fmt.Errorf("%w: index field %q contains ':', ',' or control characters", errors_consts.ErrInvalidQuery, field)
+			}
+		}
+		if _, err := parseFieldPath(field); err != nil {
+			return fmt.Errorf("%w: %v", errors_consts.ErrInvalidQuery, err)
+		}
+		if seen[field] {
+			return fmt.Errorf("%w: index field %q is listed twice", errors_consts.ErrInvalidQuery, field)
+		}
+		seen[field] = true
+	}
+	return nil
+}
+
+func loadIndexes(get func(string) ([]byte, bool), table string) ([]IndexInfo, error) {
+	raw, ok := get(indexMetaKey(table))
+	if !ok {
+		return nil, nil
+	}
+
+	var stored []struct {
+		IndexInfo
+		// single field of indexes written before compound indexes
+		Field string `json:"field"`
+	}
+	if err := json.Unmarshal(raw, &stored); err != nil {
+		return nil, err
+	}
+
+	defs := make([]IndexInfo, len(stored))
+	for i, def := range stored {
+		defs[i] = def.IndexInfo
+		if len(def.Fields) == 0 && def.Field != "" {
+			defs[i].Fields = []string{def.Field}
+		}
+	}
+	return defs, nil
+}
+
+func (db *DB) CreateIndex(table, field string) error {
+	return db.createIndex(IndexInfo{Table: table, Fields: []string{field}})
+}
+
+// CreateUniqueIndex makes the value tuple of fields unique across the rows of table.
+// It fails with a *UniqueViolationError when existing rows already repeat a tuple.
+// A plain index on the same single field is turned into a unique one.
+func (db *DB) CreateUniqueIndex(table string, fields ...string) error {
+	return db.createIndex(IndexInfo{Table: table, Fields: fields, Unique: true})
+}
+
+func (db *DB) createIndex(def IndexInfo) error {
+	if def.Table == "" {
+		return errors_consts.ErrEmptyName
+	}
+	if err := validateIndexFields(def.Fields); err != nil {
+		return err
+	}
+
+	table := def.Table
+
+	return db.Database.Atomic(func(tx *Tx) error {
+		defs, err := loadIndexes(tx.Get, table)
+		if err != nil {
+			return err
+		}
+
+		// a plain single-field index already has its entries, only the unique keys are missing
+		upgrade := -1
+		for i, existing := range defs {
+			if !existing.sameFields(def.Fields) {
+				continue
+			}
+			if existing.Unique || !def.Unique {
+				return fmt.Errorf("%w: %s(%s)", errors_consts.ErrIndexExists, table, def.Name())
+			}
+			upgrade = i
+		}
+
+		if err := backfillIndex(tx, def, upgrade < 0); err != nil {
+			return err
+		}
+
+		if upgrade >= 0 {
+			defs[upgrade].Unique = true
+		} else {
+			defs = append(defs, def)
+		}
+		tx.Set(indexMetaKey(table), mustJson(defs))
+		return nil
+	})
+}
+
+// DropIndex removes the index on fields, unique or not.
+func (db *DB) DropIndex(table string, fields ...string) error {
+	return db.Database.Atomic(func(tx *Tx) error {
+		defs, err := loadIndexes(tx.Get, table)
+		if err != nil {
+			return err
+		}
+
+		kept := defs[:0]
+		var dropped *IndexInfo
+		for _, def := range defs {
+			if def.sameFields(fields) {
+				dropped = &def
+				continue
+			}
+			kept = append(kept, def)
+		}
+		if dropped == nil {
+			return fmt.Errorf("%w: %s(%s)", errors_consts.ErrIndexNotFound, table, strings.Join(fields, ","))
+		}
+
+		clearIndex(tx, *dropped)
+
+		if len(kept) == 0 {
+			tx.Delete(indexMetaKey(table))
+		} else {
+			tx.Set(indexMetaKey(table), mustJson(kept))
+		}
+		return nil
+	})
+}
+
+// backfillIndex writes the unique keys of def and, when entries is set, its index
+// entries for every row of the table.
+func backfillIndex(tx *Tx, def IndexInfo, entries bool) error {
+	for key, data := range tx.ScanPrefix(def.Table + ":") {
+		// rows of a table named "<table>:x" share the prefix
+		id, ok := rowID(def.Table, key)
+		if !ok {
+			continue
+		}
+
+		row, err := decodeRow(data)
+		if err != nil {
+			return err
+		}
+
+		if len(def.Fields) == 1 && entries {
+			if enc, ok := encodeIndexValue(fieldValue(row, def.Fields[0])); ok {
+				tx.Set(indexEntryKey(def.Table, def.Fields[0], enc, id), nil)
+			}
+		}
+		if def.Unique {
+			if err := claimUnique(tx, def, row, id); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// clearIndex deletes the index entries and unique keys of def
+func clearIndex(tx *Tx, def IndexInfo) {
+	if len(def.Fields) == 1 {
+		for _, key := range ownedKeys(tx, indexEntryPrefix(def.Table, def.Fields[0]), indexTagBool, indexTagUUID) {
+			tx.Delete(key)
+		}
+	}
+	if def.Unique {
+		for _, key := range ownedKeys(tx, uniqueKeyPrefix(def.Table, def), uniqueTupleTag, uniqueTupleTag) {
+			tx.Delete(key)
+		}
+	}
+}
+
+// UpgradeIndexes rebuilds every index in one atomic batch when its keys were written in an
+// older format (before format 2, numbers were keyed by their float64 value, so large
+// integers could share a key). It reports whether anything was rebuilt; call it on start
+// before the indexes are used.
+func (db *DB) UpgradeIndexes() (bool, error) {
+	rebuilt := false
+
+	err := db.Database.Atomic(func(tx *Tx) error {
+		if raw, ok := tx.Get(indexFormatKey); ok && bytes.Equal(raw, mustJson(indexFormat)) {
+			return nil
+		}
+
+		for _, data := range tx.ScanPrefix(indexMetaPrefix) {
+			var defs []IndexInfo
+			if err := json.Unmarshal(data, &defs); err != nil {
+				return err
+			}
+
+			for _, def := range defs {
+				clearIndex(tx, def)
+				if err := backfillIndex(tx, def, true); err != nil {
+					return err
+				}
+				rebuilt = true
+			}
+		}
+
+		tx.Set(indexFormatKey, mustJson(indexFormat))
+		return nil
+	})
+	return rebuilt, err
+}
+
+// ListIndexes returns the indexes of table, or of every table when table is empty.
+func (db *DB) ListIndexes(table string) ([]IndexInfo, error) {
+	var out []IndexInfo
+
+	if table != "" {
+		defs, err := loadIndexes(db.Database.Get, table)
+		if err != nil {
+			return nil, err
+		}
+		return append(out, defs...), nil
+	}
+
+	for _, data := range db.Database.ScanPrefix(indexMetaPrefix) {
+		var defs []IndexInfo
+		if err := json.Unmarshal(data, &defs); err != nil {
+			return nil, err
+		}
+		out = append(out, defs...)
+	}
+
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Table != out[j].Table {
+			return out[i].Table < out[j].Table
+		}
+		return out[i].Name() < out[j].Name()
+	})
+	return out, nil
+}
+
+/*
+   Row writes with index maintenance
+*/
+
+func rowKey(table, id string) string {
+	return table + ":" + id
+}
+
+func decodeRow(data []byte) (map[string]any, error) {
+	var row map[string]any
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	if err := dec.Decode(&row); err != nil {
+		return nil, err
+	}
+
+	if bytes.Contains(data, []byte(`{"$`)) {
+		if err := untagRow(row); err != nil {
+			return nil, err
+		}
+	}
+	return row, nil
+}
+
+// claimUnique takes the unique key of row under def for id, failing when another row has it.
+func claimUnique(tx *Tx, def IndexInfo, row map[string]any, id string) error {
+	key, ok := uniqueKey(def.Table, def, row)
+	if !ok {
+		return nil
+	}
+
+	if owner, ok := tx.Get(key); ok && string(owner) != id {
+		values := make([]any, len(def.Fields))
+		for i, field := range def.Fields {
+			values[i] = fieldValue(row, field)
+		}
+		return &UniqueViolationError{Table: def.Table, Fields: def.Fields, Values: values, ID: string(owner)}
+	}
+
+	tx.Set(key, []byte(id))
+	return nil
+}
+
+// dropIndexEntries removes the index entries and unique keys old holds under defs.
+func dropIndexEntries(tx *Tx, table, id string, defs []IndexInfo, old map[string]any) {
+	for _, def := range defs {
+		if len(def.Fields) == 1 {
+			if enc, ok := encodeIndexValue(fieldValue(old, def.Fields[0])); ok {
+				tx.Delete(indexEntryKey(table, def.Fields[0], enc, id))
+			}
+		}
+		if def.Unique {
+			if key, ok := uniqueKey(table, def, old); ok {
+				if owner, ok := tx.Get(key); ok && string(owner) == id {
+					tx.Delete(key)
+				}
+			}
+		}
+	}
+}
+
+// writeRow stores row under table:id and moves its index entries from the previous version.
+// The row is checked against the table schema first (see conformRow). It fails with a
+// *UniqueViolationError when row collides with another row under a unique index; the
+// caller's transaction is then discarded.
+func writeRow(tx *Tx, table, id string, row map[string]any) error {
+	if err := untagRow(row); err != nil {
+		return err
+	}
+
+	if err := conformRow(tx, table, row); err != nil {
+		return err
+	}
+
+	defs, err := loadIndexes(tx.Get, table)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(tagValue(row))
+	if err != nil {
+		return err
+	}
+
+	key := rowKey(table, id)
+
+	if len(defs) > 0 {
+		if raw, ok := tx.Get(key); ok {
+			old, err := decodeRow(raw)
+			if err != nil {
+				return err
+			}
+			dropIndexEntries(tx, table, id, defs, old)
+		}
+
+		for _, def := range defs {
+			if def.Unique {
+				if err := claimUnique(tx, def, row, id); err != nil {
+					return err
+				}
+			}
+			if len(def.Fields) == 1 {
+				if enc, ok := encodeIndexValue(fieldValue(row, def.Fields[0])); ok {
+					tx.Set(indexEntryKey(table, def.Fields[0], enc, id), nil)
+				}
+			}
+		}
+	}
+
+	if err := updateText(tx, table, id, row); err != nil {
+		return err
+	}
+
+	tx.Set(key, data)
+	return nil
+}
+
+// removeRow deletes table:id together with its index entries and applies the foreign
+// keys pointing at it. The rows the foreign key actions delete or set null run the hooks
+// of the statement (hooks may be nil).
+func removeRow(tx *Tx, hooks *hookRun, table, id string) error {
+	key := rowKey(table, id)
+
+	raw, ok := tx.Get(key)
+	if !ok {
+		return nil
+	}
+
+	defs, err := loadIndexes(tx.Get, table)
+	if err != nil {
+		return err
+	}
+
+	if len(defs) > 0 {
+		old, err := decodeRow(raw)
+		if err != nil {
+			return err
+		}
+		dropIndexEntries(tx, table, id, defs, old)
+	}
+
+	if err := updateText(tx, table, id, nil); err != nil {
+		return err
+	}
+
+	// deleted first, so a cascade that comes back to this row stops here
+	tx.Delete(key)
+	return onDelete(tx, hooks, table, id)
+}
+
+/*
+   Using indexes for reads
+*/
+
+type keyRange struct {
+	start string
+	end   string
+}
+
+// indexRanges returns the key ranges of the index on clause.field that hold every row the
+// clause can match, or false when the index cannot serve the operator.
+func indexRanges(table string, w *WhereClause) ([]keyRange, bool) {
+	prefix := indexEntryPrefix(table, w.field)
+
+	exact := func(v any) (keyRange, bool) {
+		enc, ok := encodeIndexValue(v)
+		if !ok {
+			return keyRange{}, false
+		}
+		return keyRange{start: prefix + enc + "\x00", end: prefix + enc + "\x01"}, true
+	}
+
+	// the entry prefix of v plus the bounds of all values sharing v's type
+	typed := func(v any) (value, first, last string, ok bool) {
+		enc, ok := encodeIndexValue(v)
+		if !ok || enc[0] == indexTagBool {
+			return "", "", "", false
+		}
+		return prefix + enc, prefix + enc[:1], prefix + string(enc[0]+1), true
+	}
+
+	switch w.operator {
+	case "=":
+		r, ok := exact(w.value)
+		return []keyRange{r}, ok
+
+	case "in":
+		var ranges []keyRange
+		for _, v := range w.value.([]any) {
+			r, ok := exact(v)
+			if !ok {
+				return nil, false
+			}
+			ranges = append(ranges, r)
+		}
+		return ranges, true
+
+	case ">", ">=":
+		value, _, last, ok := typed(w.value)
+		if !ok {
+			return nil, false
+		}
+		// a string may extend value with "\x00...": its entries sort among value's own, so
+		// ">" starts at them as well and the rows equal to value are filtered out afterwards
+		if w.operator == ">" && value[len(prefix)] != indexTagString {
+			return []keyRange{{start: value + "\x01", end: last}}, true
+		}
+		return []keyRange{{start: value + "\x00", end: last}}, true
+
+	case "<", "<=":
+		value, first, _, ok := typed(w.value)
+		if !ok {
+			return nil, false
+		}
+		if w.operator == "<" {
+			return []keyRange{{start: first, end: value + "\x00"}}, true
+		}
+		return []keyRange{{start: first, end: value + "\x01"}}, true
+
+	case "between":
+		bounds := w.value.([]any)
+		low, lowType, _, ok1 := typed(bounds[0])
+		high, highType, _, ok2 := typed(bounds[1])
+		if !ok1 || !ok2 || lowType != highType {
+			return nil, false
+		}
+		return []keyRange{{start: low + "\x00", end: high + "\x01"}}, true
+
+	case "starts_with":
+		start := prefix + string(indexTagString) + w.value.(string)
+		return []keyRange{{start: start, end: prefixEnd(start)}}, true
+	}
+
+	return nil, false
+}
+
+// leafClauses returns the comparisons every matching row must satisfy:
+// top-level conditions and the leaves of (nested) AND groups.
+func leafClauses(conds []*Condition) []*WhereClause {
+	var out []*WhereClause
+	for _, c := range conds {
+		switch c.kind {
+		case condLeaf:
+			out = append(out, c.clause)
+		case condAnd:
+			out = append(out, leafClauses(c.children)...)
+		}
+	}
+	return out
+}
@@ -0,0 +1,167 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"golangdb/errors_consts"
+	"strings"
+)
+
+/*
+   Joins for SelectQuery
+
+   Select().Table("orders").Join("customers", "orders.customer_id", "customers.id")
+
+   A query with joins returns one row per combination, with the fields of every table
+   nested under its name:
+     {"orders": {...}, "customers": {...}}
+   so Where, OrderBy, GroupBy and Columns address them as paths ("customers.name").
+   The name of a table is the part after its last ':', which drops the "user:<id>:" scope
+   the server adds. LeftJoin keeps rows without a partner and sets the table to null.
+
+   Each joined table is read once into a hash table keyed by its join field; the rows
+   built so far then probe it, so a join costs one scan per table instead of a scan per
+   row. Keys compare like indexed values (1 and 1.0 match, 1 and "1" do not); null,
+   missing and non-scalar values never match.
+*/
+
+type join struct {
+	table string
+	left  bool
+	on    [2]string
+
+	// resolved by planJoins
+	name  string
+	probe string // path into the rows joined so far
+	build string // field of the joined table
+}
+
+func tableName(table string) string {
+	return table[strings.LastIndexByte(table, ':')+1:]
+}
+
+// Join adds an inner join with table on leftField = rightField. One of the two fields
+// names the joined table ("customers.id"), the other a table already in the query.
+func (s *SelectQuery) Join(table, leftField, rightField string) *SelectQuery {
+	s.joins = append(s.joins, &join{table: table, on: [2]string{leftField, rightField}})
+	return s
+}
+
+// LeftJoin is like Join but keeps rows that have no partner in table.
+func (s *SelectQuery) LeftJoin(table, leftField, rightField string) *SelectQuery {
+	s.joins = append(s.joins, &join{table: table, left: true, on: [2]string{leftField, rightField}})
+	return s
+}
+
+func (s *SelectQuery) planJoins() error {
+	seen := map[string]bool{tableName(s.table): true}
+
+	for _, j := range s.joins {
+		if j.table == "" {
+			return errors_consts.ErrEmptyName
+		}
+
+		j.name = tableName(j.table)
+		if seen[j.name] {
+			return fmt.Errorf("%w: table %s is already part of the query", errors_consts.ErrInvalidQuery, j.name)
+		}
+
+		for i, field := range j.on {
+			other := j.on[1-i]
+
+			name, rest, ok := strings.Cut(field, ".")
+			if !ok || name != j.name || rest == "" {
+				continue
+			}
+
+			otherName, otherRest, ok := strings.Cut(other, ".")
+			if !ok || !seen[otherName] || otherRest == "" {
+				return fmt.Errorf("%w: join field %q must reference a table joined earlier", errors_consts.ErrInvalidQuery, other)
+			}
+			j.build, j.probe = rest, other
+		}
+		if j.build == "" {
+			return fmt.Errorf("%w: join of %s needs a field of the form %s.<field>", errors_consts.ErrInvalidQuery, j.name, j.name)
+		}
+
+		seen[j.name] = true
+	}
+	return nil
+}
+
+type joinedTable struct {
+	*join
+	rows map[string][]resultRow
+}
+
+type joiner struct {
+	base   string
+	tables []joinedTable
+	plans  []*Plan
+}
+
+// newJoiner reads every joined table into its hash table.
+func (s *SelectQuery) newJoiner(ctx context.Context) (*joiner, error) {
+	jn := &joiner{base: tableName(s.table)}
+
+	for _, j := range s.joins {
+		raw, plan, err := s.db.fetchRows(ctx, j.table, &filter{})
+		if err != nil {
+			return nil, err
+		}
+		jn.plans = append(jn.plans, plan)
+
+		jt := joinedTable{join: j, rows: make(map[string][]resultRow)}
+		for key, data := range raw {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+
+			row, err := decodeRow(data)
+			if err != nil {
+				return nil, err
+			}
+			if hash, ok := encodeIndexValue(fieldValue(row, j.build)); ok {
+				jt.rows[hash] = append(jt.rows[hash], resultRow{key: key, row: row})
+			}
+		}
+		jn.tables = append(jn.tables, jt)
+	}
+	return jn, nil
+}
+
+// expand returns the joined rows of one row of the base table.
+func (jn *joiner) expand(key string, row map[string]any) []resultRow {
+	rows := []resultRow{{key: key, row: map[string]any{jn.base: row}}}
+
+	for _, jt := range jn.tables {
+		var next []resultRow
+
+		for _, r := range rows {
+			var partners []resultRow
+			if hash, ok := encodeIndexValue(fieldValue(r.row, jt.probe)); ok {
+				partners = jt.rows[hash]
+			}
+
+			if len(partners) == 0 && jt.left {
+				next = append(next, resultRow{key: r.key + "\x00", row: with(r.row, jt.name, nil)})
+			}
+			for _, p := range partners {
+				next = append(next, resultRow{key: r.key + "\x00" + p.key, row: with(r.row, jt.name, p.row)})
+			}
+		}
+
+		rows = next
+	}
+	return rows
+}
+
+// with returns a copy of row with field set to v
+func with(row map[string]any, field string, v any) map[string]any {
+	out := make(map[string]any, len(row)+1)
+	for k, val := range row {
+		out[k] = val
+	}
+	out[field] = v
+	return out
+}
@@ -0,0 +1,160 @@
+package database
+
+import (
+	"math/rand/v2"
+	"strings"
+)
+
+/*
+   Ordered key index
+
+   The in-memory map answers point lookups; this skiplist keeps the same keys sorted so
+   prefix and range scans only visit the keys they return instead of the whole map.
+*/
+
+const skipListMaxLevel = 24
+
+type skipNode struct {
+	key  string
+	next []*skipNode
+}
+
+type skipList struct {
+	head  *skipNode
+	level int
+	size  int
+}
+
+func newSkipList() *skipList {
+	return &skipList{
+		head:  &skipNode{next: make([]*skipNode, skipListMaxLevel)},
+		level: 1,
+	}
+}
+
+func randomLevel() int {
+	level := 1
+	for level < skipListMaxLevel && rand.IntN(4) == 0 {
+		level++
+	}
+	return level
+}
+
+// findPrev fills update with the last node before key on every level
+func (l *skipList) findPrev(key string, update []*skipNode) *skipNode {
+	node := l.head
+	for i := l.level - 1; i >= 0; i-- {
+		for node.next[i] != nil && node.next[i].key < key {
+			node = node.next[i]
+		}
+		if update != nil {
+			update[i] = node
+		}
+	}
+	return node.next[0]
+}
+
+func (l *skipList) insert(key string) {
+	update := make([]*skipNode, skipListMaxLevel)
+
+	if found := l.findPrev(key, update); found != nil && found.key == key {
+		return
+	}
+
+	level := randomLevel()
+	if level > l.level {
+		for i := l.level; i < level; i++ {
+			update[i] = l.head
+		}
+		l.level = level
+	}
+
+	node := &skipNode{key: key, next: make([]*skipNode, level)}
+	for i := 0; i < level; i++ {
+		node.next[i] = update[i].next[i]
+		update[i].next[i] = node
+	}
+	l.size++
+}
+
+func (l *skipList) remove(key string) {
+	update := make([]*skipNode, skipListMaxLevel)
+
+	found := l.findPrev(key, update)
+	if found == nil || found.key != key {
+		return
+	}
+
+	for i := 0; i < len(found.next); i++ {
+		update[i].next[i] = found.next[i]
+	}
+	for l.level > 1 && l.head.next[l.level-1] == nil {
+		l.level--
+	}
+	l.size--
+}
+
+// ascend calls fn for keys in [start, end) in order until fn returns false.
+// An empty end means no upper bound.
+func (l *skipList) ascend(start, end string, fn func(key string) bool) {
+	for node := l.findPrev(start, nil); node != nil; node = node.next[0] {
+		if end != "" && node.key >= end {
+			return
+		}
+		if !fn(node.key) {
+			return
+		}
+	}
+}
+
+// prefixEnd returns the smallest key greater than every key starting with prefix,
+// or "" when there is none (the prefix is empty or all 0xff bytes).
+func prefixEnd(prefix string) string {
+	b := []byte(prefix)
+	for i := len(b) - 1; i >= 0; i-- {
+		if b[i] < 0xff {
+			b[i]++
+			return string(b[:i+1])
+		}
+	}
+	return ""
+}
+
+/*
+   memTable is the in-memory dataset: values by key plus their sorted order.
+*/
+
+type memTable struct {
+	data map[string][]byte
+	keys *skipList
+}
+
+func newMemTable() *memTable {
+	return &memTable{
+		data: make(map[string][]byte),
+		keys: newSkipList(),
+	}
+}
+
+func (m *memTable) set(key string, val []byte) {
+	if _, ok := m.data[key]; !ok {
+		m.keys.insert(key)
+	}
+	m.data[key] = val
+}
+
+func (m *memTable) delete(key string) {
+	if _, ok := m.data[key]; ok {
+		m.keys.remove(key)
+		delete(m.data, key)
+	}
+}
+
+func (m *memTable) scanPrefix(prefix string, fn func(key string, val []byte) bool) {
+	m.keys.ascend(prefix, prefixEnd(prefix), func(key string) bool {
+		if !strings.HasPrefix(key, prefix) {
+			return false
+		}
+		return fn(key, m.data[key])
+	})
+}
@@ -0,0 +1,239 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"golangdb/errors_consts"
+	"slices"
+	"sort"
+	"time"
+)
+
+/*
+   Migrations
+
+   var All database.Migrations
+
+   func init() {
+       All.Register(database.Migration{
+           Version: 1,
+           Name:    "create orders",
+           Up: func(ctx context.Context, db *database.DB) error {
+               return db.CreateTable("orders", database.Schema{...})
+           },
+           Down: func(ctx context.Context, db *database.DB) error {
+               return db.DropTable("orders")
+           },
+       })
+   }
+
+   A migration is an ordered Go function changing the stored data: creating tables and
+   indexes, backfilling fields with Update, renaming columns with AlterTable, ... Versions
+   order them and must be unique; pending migrations run in version order, also those
+   with a version below the last applied one (merged from another branch).
+
+   The ledger is the table __migrations__, one row per applied migration:
+     {"id": <version>, "name": ..., "applied_at": <timestamp>}
+   written after Up returned nil and deleted after Down returned nil. The statements of a
+   migration commit one by one, so a failing Up can leave part of its work behind while
+   the migration stays pending: write Up so that it can run again once fixed (skip
+   ErrTableExists, ErrIndexExists, backfill only rows that miss the field).
+*/
+
+const MigrationsTable = "__migrations__"
+
+type MigrationFunc func(ctx context.Context, db *DB) error
+
+type Migration struct {
+	Version int64
+	Name    string
+	Up      MigrationFunc
+	// nil for a migration that cannot be reverted
+	Down MigrationFunc
+}
+
+// Migrations is a registry of migrations, usually filled from init functions.
+type Migrations struct {
+	list []Migration
+}
+
+// Register adds m. A version below 1, a taken version or a missing Up is a programming
+// error and panics, like a duplicate route.
+func (r *Migrations) Register(m Migration) {
+	if m.Version < 1 {
+		panic(fmt.Sprintf("migration %q: version must be positive, got %d", m.Name, m.Version))
+	}
+	if m.Up == nil {
+		panic(fmt.Sprintf("migration %d %q has no up step", m.Version, m.Name))
+	}
+	for _, other := range r.list {
+		if other.Version == m.Version {
+			panic(fmt.Sprintf("migration %d registered twice: %q and %q", m.Version, other.Name, m.Name))
+		}
+	}
+	r.list = append(r.list, m)
+}
+
+// List returns the registered migrations by ascending version.
+func (r *Migrations) List() []Migration {
+	list := slices.Clone(r.list)
+	sort.Slice(list, func(i, j int) bool { return list[i].Version < list[j].Version })
+	return list
+}
+
+func (r *Migrations) find(version int64) (Migration, bool) {
+	for _, m := range r.list {
+		if m.Version == version {
+			return m, true
+		}
+	}
+	return Migration{}, false
+}
+
+// MigrationStatus is a migration of the registry or the ledger.
+type MigrationStatus struct {
+	Version   int64     `json:"version"`
+	Name      string    `json:"name"`
+	Applied   bool      `json:"applied"`
+	AppliedAt time.Time `json:"applied_at,omitzero"`
+	// in the ledger but not in the registry
+	Unknown bool `json:"unknown,omitempty"`
+}
+
+// appliedMigrations reads the ledger
+func (db *DB) appliedMigrations(ctx context.Context) (map[int64]MigrationStatus, error) {
+	rows, err := db.Select().Table(MigrationsTable).AllContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	applied := make(map[int64]MigrationStatus, len(rows))
+	for _, row := range rows {
+		version, err := parseID(row["id"])
+		if err != nil {
+			return nil, err
+		}
+
+		st := MigrationStatus{Version: version, Applied: true}
+		st.Name, _ = row["name"].(string)
+		st.AppliedAt, _ = row["applied_at"].(time.Time)
+		applied[version] = st
+	}
+	return applied, nil
+}
+
+// MigrationStatus returns every migration of the registry and the ledger by ascending
+// version, with whether and when it was applied.
+func (db *DB) MigrationStatus(ctx context.Context, migrations *Migrations) ([]MigrationStatus, error) {
+	applied, err := db.appliedMigrations(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var out []MigrationStatus
+	for _, m := range migrations.List() {
+		st, ok := applied[m.Version]
+		if !ok {
+			st = MigrationStatus{Version: m.Version}
+		}
+		st.Name = m.Name
+		out = append(out, st)
+		delete(applied, m.Version)
+	}
+
+	for _, st := range applied {
+		st.Unknown = true
+		out = append(out, st)
+	}
+
+	sort.Slice(out, func(i, j int) bool { return out[i].Version < out[j].Version })
+	return out, nil
+}
+
+// MigrateUp applies the pending migrations up to version target (0 for all) in version
+// order and returns the ones it applied. It stops at the first failing migration.
+func (db *DB) MigrateUp(ctx context.Context, migrations *Migrations, target int64) ([]Migration, error) {
+	db.migrateMu.Lock()
+	defer db.migrateMu.Unlock()
+
+	applied, err := db.appliedMigrations(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var done []Migration
+	for _, m := range migrations.List() {
+		if target > 0 && m.Version > target {
+			break
+		}
+		if _, ok := applied[m.Version]; ok {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return done, err
+		}
+
+		if err := m.Up(ctx, db); err != nil {
+			return done, fmt.Errorf("migration %d %s: %w", m.Version, m.Name, err)
+		}
+
+		// the migration ran: record it even if ctx ends now
+		err := db.Insert().Table(MigrationsTable).Values(map[string]any{
+			"id":         m.Version,
+			"name":       m.Name,
+			"applied_at": time.Now().UTC(),
+		}).ExecContext(context.WithoutCancel(ctx))
+		if err != nil {
+			return done, fmt.Errorf("migration %d %s ran but was not recorded: %w", m.Version, m.Name, err)
+		}
+
+		done = append(done, m)
+	}
+	return done, nil
+}
+
+// MigrateDown reverts the last steps applied migrations, newest first, and returns the
+// ones it reverted. Applied migrations missing from the registry fail with
+// ErrMigrationUnknown, those without Down with ErrMigrationIrreversible.
+func (db *DB) MigrateDown(ctx context.Context, migrations *Migrations, steps int) ([]Migration, error) {
+	db.migrateMu.Lock()
+	defer db.migrateMu.Unlock()
+
+	applied, err := db.appliedMigrations(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	versions := make([]int64, 0, len(applied))
+	for v := range applied {
+		versions = append(versions, v)
+	}
+	slices.Sort(versions)
+	slices.Reverse(versions)
+
+	var done []Migration
+	for _, v := range versions[:min(max(steps, 0), len(versions))] {
+		m, ok := migrations.find(v)
+		if !ok {
+			return done, fmt.Errorf("%w: %d %s", errors_consts.ErrMigrationUnknown, v, applied[v].Name)
+		}
+		if m.Down == nil {
+			return done, fmt.Errorf("%w: %d %s", errors_consts.ErrMigrationIrreversible, m.Version, m.Name)
+		}
+		if err := ctx.Err(); err != nil {
+			return done, err
+		}
+
+		if err := m.Down(ctx, db); err != nil {
+			return done, fmt.Errorf("migration %d %s: %w", m.Version, m.Name, err)
+		}
+
+		err := db.Delete().Table(MigrationsTable).Where("id", "=", m.Version).ExecContext(context.WithoutCancel(ctx))
+		if err != nil {
+			return done, fmt.Errorf("migration %d %s was reverted but is still recorded: %w", m.Version, m.Name, err)
+		}
+
+		done = append(done, m)
+	}
+	return done, nil
+}
@@ -0,0 +1,394 @@
+package database
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+/*
+   Numbers
+
+   Numbers keep the form they arrive in: int and int64 from Go code, json.Number from
+   stored rows and HTTP bodies (the exact text of the JSON literal), float64, *big.Int
+   and Decimal. Conditions, ordering, index keys, unique keys and group keys all use the
+   exact value:
+     - two integers compare as int64 and two float64s as float64
+     - anything else compares as an exact decimal, where a float64 stands for its
+       shortest decimal form (0.1 is 0.1, not 0.1000000000000000055...)
+   so 64-bit ids and big integers never collapse into the same float64, while 1, 1.0 and
+   json.Number("1.00") stay equal.
+*/
+
+type numberKind uint8
+
+const (
+	numInt numberKind = iota + 1
+	numFloat
+	numExact
+)
+
+type number struct {
+	kind numberKind
+	i    int64
+	f    float64
+	d    Decimal
+}
+
+// toNumber reports whether v is a number and returns it in its cheapest exact form.
+func toNumber(v any) (number, bool) {
+	switch n := v.(type) {
+	case int:
+		return number{kind: numInt, i: int64(n)}, true
+	case int64:
+		return number{kind: numInt, i: n}, true
+	case float64:
+		if math.IsNaN(n) || math.IsInf(n, 0) {
+			return number{}, false
+		}
+		return number{kind: numFloat, f: n}, true
+	case json.Number:
+		if i, err := strconv.ParseInt(string(n), 10, 64); err == nil {
+			return number{kind: numInt, i: i}, true
+		}
+		d, err := ParseDecimal(string(n))
+		return number{kind: numExact, d: d}, err == nil
+	case Decimal:
+		return number{kind: numExact, d: n}, true
+	case *big.Int:
+		if n == nil {
+			return number{}, false
+		}
+		if n.IsInt64() {
+			return number{kind: numInt, i: n.Int64()}, true
+		}
+		return number{kind: numExact, d: Decimal{coef: n}}, true
+	}
+	return number{}, false
+}
+
+func isNumber(v any) bool {
+	_, ok := toNumber(v)
+	return ok
+}
+
+func compareNumbers(a, b number) int {
+	switch {
+	case a.kind == numInt && b.kind == numInt:
+		return cmp.Compare(a.i, b.i)
+	case a.kind == numFloat && b.kind == numFloat:
+		return cmp.Compare(a.f, b.f)
+	}
+	return a.parts().cmp(b.parts())
+}
+
+func (n number) isInteger() bool {
+	switch n.kind {
+	case numInt:
+		return true
+	case numFloat:
+		return n.f == math.Trunc(n.f)
+	}
+	p := n.parts()
+	return p.exp >= len(p.digits)
+}
+
+// int64 returns the value if it is a whole number within the range of int64
+func (n number) int64() (int64, bool) {
+	if n.kind == numInt {
+		return n.i, true
+	}
+
+	p := n.parts()
+	if p.exp > 19 {
+		return 0, false
+	}
+
+	s, ok := p.integer()
+	if !ok {
+		return 0, false
+	}
+	i, err := strconv.ParseInt(s, 10, 64)
+	return i, err == nil
+}
+
+func (n number) decimal() Decimal {
+	switch n.kind {
+	case numInt:
+		return NewDecimal(n.i, 0)
+	case numFloat:
+		d, _ := ParseDecimal(strconv.FormatFloat(n.f, 'g', -1, 64))
+		return d
+	}
+	return n.d
+}
+
+func (n number) parts() decimalParts {
+	switch n.kind {
+	case numInt:
+		abs := uint64(n.i)
+		if n.i < 0 {
+			abs = -abs
+		}
+		return partsOf(n.i < 0, strconv.FormatUint(abs, 10), 0)
+
+	case numFloat:
+		// shortest form that reads back as the same float64: "d.ddde±x"
+		s := strconv.FormatFloat(math.Abs(n.f), 'e', -1, 64)
+		mant, exp, _ := strings.Cut(s, "e")
+		e, _ := strconv.Atoi(exp)
+		digits := strings.Replace(mant, ".", "", 1)
+		return partsOf(n.f < 0, digits, len(digits)-1-e)
+	}
+	return n.d.parts()
+}
+
+// decimalParts is a number as sign × 0.digits × 10^exp, digits without leading or
+// trailing zeros; zero has sign 0 and no digits
+type decimalParts struct {
+	sign   int
+	digits string
+	exp    int
+}
+
+// partsOf normalizes the value digits × 10^-scale
+func partsOf(negative bool, digits string, scale int) decimalParts {
+	digits = strings.TrimLeft(digits, "0")
+	if digits == "" {
+		return decimalParts{}
+	}
+
+	p := decimalParts{sign: 1, exp: len(digits) - scale}
+	if negative {
+		p.sign = -1
+	}
+	p.digits = strings.TrimRight(digits, "0")
+	return p
+}
+
+// integer returns the whole number p in plain digits, or false when p has a fraction
+func (p decimalParts) integer() (string, bool) {
+	switch {
+	case p.sign == 0:
+		return "0", true
+	case p.exp < len(p.digits):
+		return "", false
+	}
+
+	s := p.digits + strings.Repeat("0", p.exp-len(p.digits))
+	if p.sign < 0 {
+		s = "-" + s
+	}
+	return s, true
+}
+
+func (p decimalParts) cmp(q decimalParts) int {
+	if p.sign != q.sign {
+		return cmp.Compare(p.sign, q.sign)
+	}
+
+	c := cmp.Compare(p.exp, q.exp)
+	if c == 0 {
+		c = strings.Compare(p.digits, q.digits)
+	}
+	return c * p.sign
+}
+
+// key encodes the number so that byte order is numeric order: a sign class, the exponent
+// as fixed-width hex and the digits. For negative numbers exponent and digits are inverted
+// and the digits end in '~', so a larger magnitude sorts first.
+func (p decimalParts) key() string {
+	exp := uint32(int32(p.exp)) ^ 1<<31
+
+	switch p.sign {
+	case 0:
+		return "1"
+	case 1:
+		return fmt.Sprintf("2%08x%s", exp, p.digits)
+	}
+
+	inverted := []byte(p.digits)
+	for i, c := range inverted {
+		inverted[i] = '9' - c + '0'
+	}
+	return fmt.Sprintf("0%08x%s~", ^exp, inverted)
+}
+
+/*
+   Exact sums
+*/
+
+// numberSum adds in int64 while every value is an integer and the total fits, and as a
+// Decimal after that. A float64 among the values makes the result a float64.
+type numberSum struct {
+	count  int64
+	i      int64
+	d      Decimal
+	exact  bool // d holds the total
+	floats bool
+}
+
+func (s *numberSum) add(n number) {
+	s.count++
+	if n.kind == numFloat {
+		s.floats = true
+	}
+
+	if !s.exact && n.kind == numInt {
+		// no overflow
+		if total := s.i + n.i; (n.i >= 0) == (total >= s.i) {
+			s.i = total
+			return
+		}
+	}
+
+	if !s.exact {
+		s.d = NewDecimal(s.i, 0)
+		s.exact = true
+	}
+	s.d = s.d.Add(n.decimal())
+}
+
+func (s *numberSum) total() any {
+	switch {
+	case s.count == 0:
+		return nil
+	case s.floats:
+		return s.float()
+	case !s.exact:
+		return s.i
+	}
+	return s.d
+}
+
+func (s *numberSum) float() float64 {
+	if !s.exact {
+		return float64(s.i)
+	}
+	return s.d.Float64()
+}
+
+/*
+   Decimal
+*/
+
+// maxDecimalExponent bounds the exponent of parsed decimals so that "1e999999999" cannot
+// make the database allocate its digits.
+const maxDecimalExponent = 4096
+
+// Decimal is an exact base-10 number, e.g. an amount of money. It is stored as a plain
+// JSON number with all its digits and reads back as json.Number, which compares exactly.
+// The zero value is 0.
+type Decimal struct {
+	coef  *big.Int // nil is zero
+	scale int32    // value = coef × 10^-scale
+}
+
+// NewDecimal returns unscaled × 10^-scale, e.g. NewDecimal(1999, 2) is 19.99.
+func NewDecimal(unscaled int64, scale int32) Decimal {
+	return Decimal{coef: big.NewInt(unscaled), scale: scale}
+}
+
+// ParseDecimal reads a decimal such as "19.99", "-0.5" or "1.5e3", keeping every digit.
+func ParseDecimal(s string) (Decimal, error) {
+	mant, exp := s, 0
+
+	if i := strings.IndexAny(s, "eE"); i >= 0 {
+		e, err := strconv.Atoi(s[i+1:])
+		if err != nil || e > maxDecimalExponent || e < -maxDecimalExponent {
+			return Decimal{}, fmt.Errorf("invalid decimal %q", s)
+		}
+		mant, exp = s[:i], e
+	}
+
+	sign := ""
+	if mant != "" && (mant[0] == '-' || mant[0] == '+') {
+		sign, mant = mant[:1], mant[1:]
+	}
+
+	whole, frac, _ := strings.Cut(mant, ".")
+	if whole+frac == "" || strings.Trim(whole+frac, "0123456789") != "" {
+		return Decimal{}, fmt.Errorf("invalid decimal %q", s)
+	}
+
+	coef, _ := new(big.Int).SetString(sign+whole+frac, 10)
+	return Decimal{coef: coef, scale: int32(len(frac) - exp)}, nil
+}
+
+func (d Decimal) big() *big.Int {
+	if d.coef == nil {
+		return new(big.Int)
+	}
+	return d.coef
+}
+
+func (d Decimal) parts() decimalParts {
+	c := d.big()
+	return partsOf(c.Sign() < 0, new(big.Int).Abs(c).String(), int(d.scale))
+}
+
+// Cmp returns -1, 0 or 1 as d is less than, equal to or greater than o.
+func (d Decimal) Cmp(o Decimal) int {
+	return d.parts().cmp(o.parts())
+}
+
+func (d Decimal) Add(o Decimal) Decimal {
+	scale := max(d.scale, o.scale)
+	sum := new(big.Int).Add(d.rescale(scale), o.rescale(scale))
+	return Decimal{coef: sum, scale: scale}
+}
+
+// rescale returns the coefficient of d at the larger scale
+func (d Decimal) rescale(scale int32) *big.Int {
+	if scale == d.scale {
+		return d.big()
+	}
+	pow := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(scale-d.scale)), nil)
+	return pow.Mul(pow, d.big())
+}
+
+func (d Decimal) Float64() float64 {
+	f, _ := strconv.ParseFloat(d.String(), 64)
+	return f
+}
+
+// String returns d in plain notation with its scale, e.g. "19.90".
+func (d Decimal) String() string {
+	c := d.big()
+	s := new(big.Int).Abs(c).String()
+
+	switch scale := int(d.scale); {
+	case scale < 0:
+		s += strings.Repeat("0", -scale)
+	case scale > 0:
+		if len(s) <= scale {
+			s = strings.Repeat("0", scale-len(s)+1) + s
+		}
+		s = s[:len(s)-scale] + "." + s[len(s)-scale:]
+	}
+
+	if c.Sign() < 0 {
+		return "-" + s
+	}
+	return s
+}
+
+func (d Decimal) MarshalJSON() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+// UnmarshalJSON accepts a JSON number or a string holding one.
+func (d *Decimal) UnmarshalJSON(data []byte) error {
+	s := strings.Trim(string(data), `"`)
+
+	parsed, err := ParseDecimal(s)
+	if err != nil {
+		return err
+	}
+	*d = parsed
+	return nil
+}
@@ -0,0 +1,135 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+/*
+   Options for opening the core
+*/
+
+type SyncMode int
+
+const (
+	// SyncAlways fsyncs the WAL after every record (the historical behaviour).
+	SyncAlways SyncMode = iota
+	// SyncInterval fsyncs the WAL in the background every Options.SyncInterval.
+	SyncInterval
+	// SyncNone never fsyncs the WAL explicitly and leaves flushing to the OS.
+	SyncNone
+)
+
+func (m SyncMode) String() string {
+	switch m {
+	case SyncAlways:
+		return "always"
+	case SyncInterval:
+		return "interval"
+	case SyncNone:
+		return "none"
+	}
+	return fmt.Sprintf("SyncMode(%d)", int(m))
+}
+
+func ParseSyncMode(s string) (SyncMode, error) {
+	switch strings.ToLower(s) {
+	case "always", "":
+		return SyncAlways, nil
+	case "interval":
+		return SyncInterval, nil
+	case "none":
+		return SyncNone, nil
+	}
+	return SyncAlways, fmt.Errorf("unknown sync mode %q (want always, interval or none)", s)
+}
+
+type Options struct {
+	DbPath  string
+	WalPath string
+
+	// snapshot thresholds: the WAL is folded into a snapshot once it grows past
+	// WalSizeLimit bytes or, when SnapshotEvery > 0, after that many WAL records
+	WalSizeLimit  int64
+	SnapshotEvery int64
+
+	SyncMode     SyncMode
+	SyncInterval time.Duration
+
+	FileMode os.FileMode
+	DirMode  os.FileMode
+
+	// Logger receives snapshot and background sync events. nil discards them.
+	Logger *log.Logger
+}
+
+func DefaultOptions() Options {
+	return Options{
+		DbPath:       DbPath,
+		WalPath:      WalPath,
+		WalSizeLimit: WalSizeLimit,
+		SyncMode:     SyncAlways,
+		SyncInterval: time.Second,
+		FileMode:     0644,
+		DirMode:      0755,
+	}
+}
+
+// zero values fall back to the defaults, so Options{DbPath: ..., WalPath: ...} is enough
+func (o Options) withDefaults() Options {
+	def := DefaultOptions()
+
+	if o.WalSizeLimit == 0 {
+		o.WalSizeLimit = def.WalSizeLimit
+	}
+	if o.SyncInterval == 0 {
+		o.SyncInterval = def.SyncInterval
+	}
+	if o.FileMode == 0 {
+		o.FileMode = def.FileMode
+	}
+	if o.DirMode == 0 {
+		o.DirMode = def.DirMode
+	}
+	return o
+}
+
+func (o Options) Validate() error {
+	var errs []error
+
+	if o.DbPath == "" {
+		errs = append(errs, errors.New("database path is empty"))
+	}
+	if o.WalPath == "" {
+		errs = append(errs, errors.New("wal path is empty"))
+	}
+	if o.DbPath != "" && o.DbPath == o.WalPath {
+		errs = append(errs, errors.New("database and wal paths must differ"))
+	}
+	if o.WalSizeLimit <= 0 {
+		errs = append(errs, errors.New("wal size limit must be positive"))
+	}
+	if o.SnapshotEvery < 0 {
+		errs = append(errs, errors.New("snapshot record threshold cannot be negative"))
+	}
+	if o.SyncMode < SyncAlways || o.SyncMode > SyncNone {
+		errs = append(errs, fmt.Errorf("unknown sync mode %d", o.SyncMode))
+	}
+	if o.SyncMode == SyncInterval && o.SyncInterval <= 0 {
+		errs = append(errs, errors.New("sync interval must be positive in interval mode"))
+	}
+
+	return errors.Join(errs...)
+}
+
+func (o Options) logger() *log.Logger {
+	if o.Logger == nil {
+		return log.New(io.Discard, "", 0)
+	}
+	return o.Logger
+}
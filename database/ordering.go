@@ -0,0 +1,176 @@
+package database
+
+import (
+	"container/heap"
+	"sort"
+	"time"
+)
+
+/*
+   Ordering, limit and offset for SelectQuery
+
+   Rows are compared key by key with compareValues. Ties (and queries without OrderBy)
+   fall back to ascending "id" and then to the storage key, so the same query always
+   returns rows in the same order.
+
+   With a Limit only offset+limit rows are kept while scanning, in a heap whose root is
+   the row that would be dropped first.
+*/
+
+type SortOrder string
+
+const (
+	Asc  SortOrder = "asc"
+	Desc SortOrder = "desc"
+)
+
+type orderKey struct {
+	field string
+	desc  bool
+}
+
+type resultRow struct {
+	key string
+	row map[string]any
+}
+
+// rank of each value kind when kinds differ: missing/null < bool < number < string <
+// timestamp < bytes < uuid
+func valueRank(v any) int {
+	if isNumber(v) {
+		return 2
+	}
+
+	switch v.(type) {
+	case nil:
+		return 0
+	case bool:
+		return 1
+	case string:
+		return 3
+	case time.Time:
+		return 4
+	case []byte:
+		return 5
+	case UUID:
+		return 6
+	}
+	return 7
+}
+
+// compareValues orders any two row values, returning -1, 0 or 1
+func compareValues(a, b any) int {
+	ra, rb := valueRank(a), valueRank(b)
+	if ra != rb {
+		if ra < rb {
+			return -1
+		}
+		return 1
+	}
+
+	if an, ok := toNumber(a); ok {
+		bn, _ := toNumber(b)
+		return compareNumbers(an, bn)
+	}
+
+	switch av := a.(type) {
+	case bool:
+		bv := b.(bool)
+		switch {
+		case av == bv:
+			return 0
+		case !av:
+			return -1
+		}
+		return 1
+	case string:
+		bv := b.(string)
+		switch {
+		case av < bv:
+			return -1
+		case av > bv:
+			return 1
+		}
+	default:
+		c, _ := compareTyped(a, b)
+		return c
+	}
+	return 0
+}
+
+// less reports whether row a sorts before row b
+func lessRows(orders []orderKey, a, b resultRow) bool {
+	for _, o := range orders {
+		c := compareValues(fieldValue(a.row, o.field), fieldValue(b.row, o.field))
+		if c == 0 {
+			continue
+		}
+		if o.desc {
+			return c > 0
+		}
+		return c < 0
+	}
+
+	if c := compareValues(a.row["id"], b.row["id"]); c != 0 {
+		return c < 0
+	}
+	return a.key < b.key
+}
+
+// rowCollector keeps the rows a select returns, bounded when keep > 0
+type rowCollector struct {
+	orders []orderKey
+	keep   int
+	rows   []resultRow
+}
+
+func (c *rowCollector) Len() int           { return len(c.rows) }
+func (c *rowCollector) Less(i, j int) bool { return lessRows(c.orders, c.rows[j], c.rows[i]) }
+func (c *rowCollector) Swap(i, j int)      { c.rows[i], c.rows[j] = c.rows[j], c.rows[i] }
+func (c *rowCollector) Push(x any)         { c.rows = append(c.rows, x.(resultRow)) }
+func (c *rowCollector) Pop() any {
+	last := c.rows[len(c.rows)-1]
+	c.rows = c.rows[:len(c.rows)-1]
+	return last
+}
+
+func (c *rowCollector) add(r resultRow) {
+	if c.keep <= 0 {
+		c.rows = append(c.rows, r)
+		return
+	}
+
+	if len(c.rows) < c.keep {
+		heap.Push(c, r)
+		return
+	}
+
+	// the root is the worst row kept so far
+	if lessRows(c.orders, r, c.rows[0]) {
+		c.rows[0] = r
+		heap.Fix(c, 0)
+	}
+}
+
+// result sorts the kept rows and applies offset and limit
+func (c *rowCollector) result(offset, limit int) []map[string]any {
+	sort.Slice(c.rows, func(i, j int) bool {
+		return lessRows(c.orders, c.rows[i], c.rows[j])
+	})
+
+	rows := c.rows
+	if offset >= len(rows) {
+		rows = nil
+	} else {
+		rows = rows[offset:]
+	}
+	if limit > 0 && len(rows) > limit {
+		rows = rows[:limit]
+	}
+
+	out := make([]map[string]any, 0, len(rows))
+	for _, r := range rows {
+		out = append(out, r.row)
+	}
+	return out
+}
@@ -0,0 +1,158 @@
+package database
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+/*
+   Field paths
+
+   Fields used by conditions, OrderBy, GroupBy, aggregates, Columns and indexes can point
+   into nested values:
+     address.city              key "city" of the object stored in "address"
+     tags[0]                   first element of the array stored in "tags"
+     orders[1].items[0].sku    any mix of both
+
+   A field that exists literally in the row (a plain name, or the "address.city" column of
+   an aggregated row) wins over the path reading, so existing fields keep working.
+*/
+
+type pathStep struct {
+	key     string
+	index   int
+	isIndex bool
+}
+
+type fieldPath []pathStep
+
+func isPath(field string) bool {
+	return strings.ContainsAny(field, ".[")
+}
+
+func parseFieldPath(field string) (fieldPath, error) {
+	if !isPath(field) {
+		return fieldPath{{key: field}}, nil
+	}
+
+	var path fieldPath
+
+	rest := field
+	for {
+		name := rest
+		if end := strings.IndexAny(rest, ".["); end >= 0 {
+			name, rest = rest[:end], rest[end:]
+		} else {
+			rest = ""
+		}
+		if name == "" || strings.Contains(name, "]") {
+			return nil, fmt.Errorf("bad field path %q", field)
+		}
+		path = append(path, pathStep{key: name})
+
+		for strings.HasPrefix(rest, "[") {
+			end := strings.IndexByte(rest, ']')
+			if end < 0 {
+				return nil, fmt.Errorf("bad field path %q: missing ]", field)
+			}
+			n, err := strconv.Atoi(rest[1:end])
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("bad field path %q: array index must be a number >= 0", field)
+			}
+			path = append(path, pathStep{index: n, isIndex: true})
+			rest = rest[end+1:]
+		}
+
+		if rest == "" {
+			return path, nil
+		}
+		if rest[0] != '.' {
+			return nil, fmt.Errorf("bad field path %q", field)
+		}
+		rest = rest[1:]
+	}
+}
+
+// lookup walks the path through decoded JSON (map[string]any / []any) and, for rows that
+// were not decoded yet, through typed maps and slices.
+func (p fieldPath) lookup(row map[string]any) (any, bool) {
+	var cur any = row
+
+	for _, step := range p {
+		switch c := cur.(type) {
+		case map[string]any:
+			if step.isIndex {
+				return nil, false
+			}
+			v, ok := c[step.key]
+			if !ok {
+				return nil, false
+			}
+			cur = v
+
+		case []any:
+			if !step.isIndex || step.index >= len(c) {
+				return nil, false
+			}
+			cur = c[step.index]
+
+		default:
+			rv := reflect.ValueOf(cur)
+			switch {
+			case !step.isIndex && rv.Kind() == reflect.Map && rv.Type().Key().Kind() == reflect.String:
+				v := rv.MapIndex(reflect.ValueOf(step.key).Convert(rv.Type().Key()))
+				if !v.IsValid() {
+					return nil, false
+				}
+				cur = v.Interface()
+			case step.isIndex && (rv.Kind() == reflect.Slice || rv.Kind() == reflect.Array) && step.index < rv.Len():
+				cur = rv.Index(step.index).Interface()
+			default:
+				return nil, false
+			}
+		}
+	}
+	return cur, true
+}
+
+// lookupField returns the value of field in row, reading it as a path when the row has no
+// such literal field. Invalid paths are only looked up literally.
+func lookupField(row map[string]any, field string) (any, bool) {
+	if v, ok := row[field]; ok || !isPath(field) {
+		return v, ok
+	}
+
+	path, err := parseFieldPath(field)
+	if err != nil {
+		return nil, false
+	}
+	return path.lookup(row)
+}
+
+func fieldValue(row map[string]any, field string) any {
+	v, _ := lookupField(row, field)
+	return v
+}
+
+// elements returns the items of an array value
+func elements(v any) ([]any, bool) {
+	if list, ok := v.([]any); ok {
+		return list, true
+	}
+	if isTypedValue(v) {
+		return nil, false
+	}
+
+	rv := reflect.ValueOf(v)
+	if !rv.IsValid() || (rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array) {
+		return nil, false
+	}
+
+	out := make([]any, rv.Len())
+	for i := range out {
+		out[i] = rv.Index(i).Interface()
+	}
+	return out, true
+}
@@ -0,0 +1,254 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+/*
+   Query planner
+
+   visitRows decides how a table is read for a set of conditions:
+     full_scan     every row under "<table>:"
+     index_lookup  the index entries of the values of an "=" or "in" condition
+     index_range   an ordered range of index entries ("<", ">", between, starts_with, ...)
+     text_index    the postings of the rarest term of a "match" on a field with a text index
+
+   The statistics are key counts taken from the core's sorted key index without reading
+   any value: the number of rows of the table and the number of entries each candidate
+   index range holds. The rows are only counted when some condition has an index to
+   use. Counting a candidate stops once it is no cheaper than the best plan so far, so
+   planning never walks more keys than the chosen plan reads anyway.
+   Reading through an index costs an extra lookup per row, hence indexCostFactor.
+*/
+
+const (
+	AccessFullScan    = "full_scan"
+	AccessIndexLookup = "index_lookup"
+	AccessIndexRange  = "index_range"
+	AccessTextIndex   = "text_index"
+)
+
+const indexCostFactor = 1.25
+
+// Plan describes how a select ran: the access path of its table, the estimate the
+// planner chose it by and what actually happened.
+type Plan struct {
+	Table         string `json:"table"`
+	Access        string `json:"access"`
+	Index         string `json:"index,omitempty"`
+	Condition     string `json:"condition,omitempty"`
+	EstimatedRows int    `json:"estimated_rows"`
+	// rows read from storage and decoded
+	RowsExamined int      `json:"rows_examined"`
+	RowsReturned int      `json:"rows_returned"`
+	Operations   []string `json:"operations,omitempty"`
+	Joins        []*Plan  `json:"joins,omitempty"`
+}
+
+func (w *WhereClause) String() string {
+	switch whereOperators[w.operator] {
+	case opValueNone:
+		return w.field + " " + w.operator
+	case opValuePattern:
+		return fmt.Sprintf("%s %s %q", w.field, w.operator, w.value)
+	}
+	return fmt.Sprintf("%s %s %v", w.field, w.operator, w.value)
+}
+
+// planAccess returns the plan for reading table and, for index plans, the key ranges to read.
+func (db *DB) planAccess(ctx context.Context, table string, f *filter) (*Plan, []keyRange, error) {
+	plan := &Plan{Table: table, Access: AccessFullScan}
+
+	defs, err := loadIndexes(db.Database.Get, table)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	indexed := make(map[string]bool, len(defs))
+	for _, def := range defs {
+		if len(def.Fields) == 1 {
+			indexed[def.Fields[0]] = true
+		}
+	}
+
+	texts, err := loadTextFields(db.Database.Get, table)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var candidates []*WhereClause
+	for _, clause := range leafClauses(f.conds) {
+		if clause.operator == "match" {
+			if slices.Contains(texts, clause.field) && len(clause.text.terms) > 0 {
+				candidates = append(candidates, clause)
+			}
+		} else if _, ok := indexRanges(table, clause); ok && indexed[clause.field] {
+			candidates = append(candidates, clause)
+		}
+	}
+
+	// without a usable index the table is scanned anyway; visitRows fills in the estimate
+	if len(candidates) == 0 {
+		return plan, nil, nil
+	}
+
+	prefix := table + ":"
+	rows, err := db.Database.CountKeys(ctx, prefix, prefixEnd(prefix), 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	plan.EstimatedRows = rows
+
+	var best []keyRange
+	cost := float64(rows)
+
+	for _, clause := range candidates {
+		if clause.operator == "match" {
+			limit := int(cost/indexCostFactor) + 1
+			r, entries, err := db.textRange(ctx, table, clause, limit)
+			if err != nil {
+				return nil, nil, err
+			}
+			if float64(entries)*indexCostFactor >= cost {
+				continue
+			}
+
+			cost = float64(entries) * indexCostFactor
+			best = []keyRange{r}
+
+			plan.Access = AccessTextIndex
+			plan.Index = clause.field
+			plan.Condition = clause.String()
+			plan.EstimatedRows = entries
+			continue
+		}
+
+		ranges, _ := indexRanges(table, clause)
+
+		// entries beyond limit make the index no cheaper than the current plan
+		limit := int(cost/indexCostFactor) + 1
+		entries := 0
+		for _, r := range ranges {
+			if entries >= limit {
+				break
+			}
+			n, err := db.Database.CountKeys(ctx, r.start, r.end, limit-entries)
+			if err != nil {
+				return nil, nil, err
+			}
+			entries += n
+		}
+
+		if float64(entries)*indexCostFactor >= cost {
+			continue
+		}
+
+		cost = float64(entries) * indexCostFactor
+		best = ranges
+
+		plan.Access = AccessIndexRange
+		if clause.operator == "=" || clause.operator == "in" {
+			plan.Access = AccessIndexLookup
+		}
+		plan.Index = clause.field
+		plan.Condition = clause.String()
+		plan.EstimatedRows = entries
+	}
+
+	return plan, best, nil
+}
+
+// visitRows calls fn with the raw rows of table that may match f, read the way planAccess
+// chose. Rows are read and handed over one at a time, so only fn decides what is kept.
+// The read holds no lock across pages: a row written meanwhile may be missed, or seen
+// twice when an update moves its index entry ahead of the read.
+func (db *DB) visitRows(ctx context.Context, table string, f *filter, fn func(key string, data []byte) error) (*Plan, error) {
+	plan, ranges, err := db.planAccess(ctx, table, f)
+	if err != nil {
+		return nil, err
+	}
+
+	examined := 0
+	visit := func(key string, data []byte) error {
+		// rows of a table named "<table>:x" share the prefix
+		if _, ok := rowID(table, key); !ok {
+			return nil
+		}
+		examined++
+		return fn(key, data)
+	}
+
+	if ranges != nil {
+		err = db.visitByIndex(ctx, table, ranges, visit)
+	} else {
+		prefix := table + ":"
+		err = db.Database.ScanRange(ctx, prefix, prefixEnd(prefix), visit)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	plan.RowsExamined = examined
+	if ranges == nil && plan.EstimatedRows == 0 {
+		// not counted by planAccess: a scan reads what it estimates
+		plan.EstimatedRows = examined
+	}
+	return plan, nil
+}
+
+// fetchRows returns the raw rows of table that may match f, for statements that need
+// them all at once.
+func (db *DB) fetchRows(ctx context.Context, table string, f *filter) (map[string][]byte, *Plan, error) {
+	raw := make(map[string][]byte)
+	plan, err := db.visitRows(ctx, table, f, func(key string, data []byte) error {
+		raw[key] = data
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return raw, plan, nil
+}
+
+// visitByIndex calls fn with the rows the entries in ranges point at. Overlapping ranges
+// are merged first and a row has one entry per index, so no row is visited twice.
+func (db *DB) visitByIndex(ctx context.Context, table string, ranges []keyRange, fn func(key string, data []byte) error) error {
+	for _, r := range mergeRanges(ranges) {
+		err := db.Database.ScanRange(ctx, r.start, r.end, func(entry string, _ []byte) error {
+			id := entry[strings.LastIndexByte(entry, 0)+1:]
+			key := rowKey(table, id)
+
+			data, ok := db.Database.Get(key)
+			if !ok {
+				return nil
+			}
+			return fn(key, data)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mergeRanges sorts ranges by start and joins those that overlap or touch. An empty end
+// has no upper bound.
+func mergeRanges(ranges []keyRange) []keyRange {
+	sorted := slices.Clone(ranges)
+	slices.SortFunc(sorted, func(a, b keyRange) int { return strings.Compare(a.start, b.start) })
+
+	var out []keyRange
+	for _, r := range sorted {
+		if n := len(out); n > 0 && (out[n-1].end == "" || r.start <= out[n-1].end) {
+			if out[n-1].end != "" && (r.end == "" || r.end > out[n-1].end) {
+				out[n-1].end = r.end
+			}
+			continue
+		}
+		out = append(out, r)
+	}
+	return out
+}
@@ -0,0 +1,69 @@
+package database
+
+import (
+	"fmt"
+	"golangdb/errors_consts"
+	"strings"
+)
+
+/*
+   Column projection for SelectQuery
+
+   Columns("id", "email AS mail") keeps only the listed fields (renaming the ones with AS),
+   Exclude("password") drops fields. Both are applied after filtering and ordering,
+   so OrderBy and Where still see the full row.
+*/
+
+type column struct {
+	field string
+	alias string
+}
+
+type projection struct {
+	columns []column
+	exclude map[string]bool
+}
+
+func parseColumn(spec string) (column, error) {
+	parts := strings.Fields(spec)
+
+	switch {
+	case len(parts) == 1:
+		return column{field: parts[0], alias: parts[0]}, nil
+	case len(parts) == 3 && strings.EqualFold(parts[1], "as"):
+		return column{field: parts[0], alias: parts[2]}, nil
+	}
+	return column{}, fmt.Errorf("%w: bad column %q (want \"field\" or \"field AS alias\")", errors_consts.ErrInvalidQuery, spec)
+}
+
+func (p *projection) empty() bool {
+	return len(p.columns) == 0 && len(p.exclude) == 0
+}
+
+// apply returns the projected copy of row. Fields missing from the row are left out.
+func (p *projection) apply(row map[string]any) map[string]any {
+	if p.empty() {
+		return row
+	}
+
+	var out map[string]any
+
+	if len(p.columns) > 0 {
+		out = make(map[string]any, len(p.columns))
+		for _, c := range p.columns {
+			if v, ok := lookupField(row, c.field); ok {
+				out[c.alias] = v
+			}
+		}
+	} else {
+		out = make(map[string]any, len(row))
+		for k, v := range row {
+			out[k] = v
+		}
+	}
+
+	for field := range p.exclude {
+		delete(out, field)
+	}
+	return out
+}
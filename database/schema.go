@@ -0,0 +1,452 @@
+package database
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"golangdb/errors_consts"
+	"reflect"
+	"slices"
+	"time"
+)
+
+/*
+   Table schemas
+
+   A table without a schema accepts any row. CreateTable attaches a schema, stored in
+     __schema__:<table> -> JSON encoded Schema
+   and from then on every row written through writeRow (inserts, updates, AlterTable) is
+   checked against it inside the write transaction:
+     - values must match the column type; null only in Nullable columns
+     - missing columns get their Default; a missing Required column without default is an error
+       ("now()" on a timestamp column and "uuid()" on a uuid column are evaluated per row)
+     - in Strict mode fields that are not columns are rejected ("id" is always allowed)
+*/
+
+const schemaPrefix = "__schema__:"
+
+type ColumnType string
+
+const (
+	TypeString ColumnType = "string"
+	TypeInt    ColumnType = "int"
+	TypeFloat  ColumnType = "float"
+	TypeBool   ColumnType = "bool"
+	TypeArray  ColumnType = "array"
+	TypeObject ColumnType = "object"
+
+	TypeTimestamp ColumnType = "timestamp"
+	TypeBytes     ColumnType = "bytes"
+	TypeUUID      ColumnType = "uuid"
+)
+
+// function defaults, evaluated for every row that leaves the column out
+const (
+	DefaultNow  = "now()"
+	DefaultUUID = "uuid()"
+)
+
+type Column struct {
+	Name     string     `json:"name"`
+	Type     ColumnType `json:"type"`
+	Nullable bool       `json:"nullable,omitempty"`
+	Required bool       `json:"required,omitempty"`
+	// used when an insert leaves the column out; nil means no default
+	Default any `json:"default,omitempty"`
+	// the column holds the id of a row of another table, see foreign_keys.go
+	References *ForeignKey `json:"references,omitempty"`
+}
+
+type Schema struct {
+	Columns []Column `json:"columns"`
+	// reject fields that are not declared columns
+	Strict bool `json:"strict,omitempty"`
+}
+
+func schemaKey(table string) string {
+	return schemaPrefix + table
+}
+
+// defaultValue returns the value a row without the column gets
+func (col Column) defaultValue() any {
+	switch {
+	case col.Type == TypeTimestamp && col.Default == DefaultNow:
+		return time.Now().UTC()
+	case col.Type == TypeUUID && col.Default == DefaultUUID:
+		return NewUUID()
+	}
+	return col.Default
+}
+
+func (s *Schema) column(name string) (Column, bool) {
+	for _, col := range s.Columns {
+		if col.Name == name {
+			return col, true
+		}
+	}
+	return Column{}, false
+}
+
+// matches reports whether v is a valid non-null value of type t
+func (t ColumnType) matches(v any) bool {
+	switch t {
+	case TypeString:
+		_, ok := v.(string)
+		return ok
+	case TypeBool:
+		_, ok := v.(bool)
+		return ok
+	case TypeFloat:
+		return isNumber(v)
+	case TypeInt:
+		n, ok := toNumber(v)
+		return ok && n.isInteger()
+	case TypeArray:
+		_, ok := elements(v)
+		return ok && isAllowedValue(v)
+	case TypeObject:
+		rv := reflect.ValueOf(v)
+		return rv.Kind() == reflect.Map && isAllowedValue(v)
+	case TypeTimestamp:
+		_, ok := v.(time.Time)
+		return ok
+	case TypeBytes:
+		_, ok := v.([]byte)
+		return ok
+	case TypeUUID:
+		_, ok := v.(UUID)
+		return ok
+	}
+	return false
+}
+
+func validateColumn(col Column) error {
+	if col.Name == "" {
+		return fmt.Errorf("%w: column name is empty", errors_consts.ErrInvalidSchema)
+	}
+	if col.Name == "id" {
+		return fmt.Errorf("%w: id is managed by the database and cannot be declared", errors_consts.ErrInvalidSchema)
+	}
+
+	switch col.Type {
+	case TypeString, TypeInt, TypeFloat, TypeBool, TypeArray, TypeObject, TypeTimestamp, TypeBytes, TypeUUID:
+	default:
+		return fmt.Errorf("%w: column %s has unknown type %q", errors_consts.ErrInvalidSchema, col.Name, col.Type)
+	}
+
+	if col.Default != nil && !col.Type.matches(col.defaultValue()) {
+		return fmt.Errorf("%w: default of column %s is not a %s", errors_consts.ErrInvalidSchema, col.Name, col.Type)
+	}
+	if col.References != nil {
+		return validateForeignKey(col)
+	}
+	return nil
+}
+
+func (s *Schema) validate() error {
+	seen := make(map[string]bool, len(s.Columns))
+	for _, col := range s.Columns {
+		if err := validateColumn(col); err != nil {
+			return err
+		}
+		if seen[col.Name] {
+			return fmt.Errorf("%w: column %s is declared twice", errors_consts.ErrInvalidSchema, col.Name)
+		}
+		seen[col.Name] = true
+	}
+	return nil
+}
+
+// conform fills in defaults and checks row against the schema.
+func (s *Schema) conform(table string, row map[string]any) error {
+	for _, col := range s.Columns {
+		v, ok := row[col.Name]
+		if !ok {
+			if col.Default != nil {
+				row[col.Name] = col.defaultValue()
+				continue
+			}
+			if col.Required {
+				return fmt.Errorf("%w: %s.%s is required", errors_consts.ErrSchemaViolation, table, col.Name)
+			}
+			continue
+		}
+
+		if v == nil {
+			if !col.Nullable {
+				return fmt.Errorf("%w: %s.%s cannot be null", errors_consts.ErrSchemaViolation, table, col.Name)
+			}
+			continue
+		}
+		if !col.Type.matches(v) {
+			return fmt.Errorf("%w: %s.%s must be a %s, got %T", errors_consts.ErrSchemaViolation, table, col.Name, col.Type, v)
+		}
+	}
+
+	if s.Strict {
+		for field := range row {
+			if _, ok := s.column(field); !ok && field != "id" {
+				return fmt.Errorf("%w: %s has no column %s", errors_consts.ErrSchemaViolation, table, field)
+			}
+		}
+	}
+	return nil
+}
+
+func loadSchema(get func(string) ([]byte, bool), table string) (*Schema, error) {
+	raw, ok := get(schemaKey(table))
+	if !ok {
+		return nil, nil
+	}
+
+	// defaults keep their JSON number form, like values of stored rows
+	var schema Schema
+
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+
+	if err := dec.Decode(&schema); err != nil {
+		return nil, err
+	}
+
+	for i, col := range schema.Columns {
+		typed, err := untagValue(col.Default)
+		if err != nil {
+			return nil, err
+		}
+		schema.Columns[i].Default = typed
+	}
+	return &schema, nil
+}
+
+// encodeSchema stores typed defaults in their tagged form, like row values
+func encodeSchema(schema Schema) []byte {
+	schema.Columns = slices.Clone(schema.Columns)
+	for i, col := range schema.Columns {
+		schema.Columns[i].Default = tagValue(col.Default)
+	}
+	return mustJson(schema)
+}
+
+// conformRow applies the schema of table to row. Tables without a schema take any row
+// but, as before schemas existed, no null values.
+func conformRow(tx *Tx, table string, row map[string]any) error {
+	schema, err := loadSchema(tx.Get, table)
+	if err != nil {
+		return err
+	}
+
+	if schema == nil {
+		for k, v := range row {
+			if v == nil {
+				return fmt.Errorf("unsupported value type for field %s", k)
+			}
+		}
+		return nil
+	}
+
+	if err := schema.conform(table, row); err != nil {
+		return err
+	}
+	return checkReferences(tx, table, schema, row)
+}
+
+// CreateTable attaches schema to table. Rows the table already holds must satisfy it;
+// columns they miss are filled with their defaults.
+func (db *DB) CreateTable(name string, schema Schema) error {
+	if name == "" {
+		return errors_consts.ErrEmptyName
+	}
+	if err := schema.validate(); err != nil {
+		return err
+	}
+
+	return db.Database.Atomic(func(tx *Tx) error {
+		if _, ok := tx.Get(schemaKey(name)); ok {
+			return fmt.Errorf("%w: %s", errors_consts.ErrTableExists, name)
+		}
+
+		tx.Set(schemaKey(name), encodeSchema(schema))
+		setReferences(tx, name, &schema, true)
+		return rewriteRows(tx, name, nil, nil)
+	})
+}
+
+// rewriteRows runs every row of table through the stored schema, first removing the
+// dropped fields and moving the renamed ones ({old, new} pairs, applied in order, as
+// AlterTable validated them), and writes back the rows that changed.
+func rewriteRows(tx *Tx, table string, dropped []string, renamed [][2]string) error {
+	schema, err := loadSchema(tx.Get, table)
+	if err != nil {
+		return err
+	}
+
+	for key, data := range tx.ScanPrefix(table + ":") {
+		id, ok := rowID(table, key)
+		if !ok {
+			continue
+		}
+
+		row, err := decodeRow(data)
+		if err != nil {
+			return err
+		}
+
+		changed := false
+		for _, field := range dropped {
+			if _, ok := row[field]; ok {
+				delete(row, field)
+				changed = true
+			}
+		}
+		for _, r := range renamed {
+			from, to := r[0], r[1]
+			if v, ok := row[from]; ok {
+				delete(row, from)
+				row[to] = v
+				changed = true
+			}
+		}
+
+		before := len(row)
+		if err := schema.conform(table, row); err != nil {
+			return err
+		}
+		if err := checkReferences(tx, table, schema, row); err != nil {
+			return err
+		}
+
+		if changed || len(row) != before {
+			if err := writeRow(tx, table, id, row); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+/*
+   AlterTable
+*/
+
+type AlterTableQuery struct {
+	db     *DB
+	table  string
+	add    []Column
+	drop   []string
+	rename [][2]string
+}
+
+// AlterTable changes the schema of a table created with CreateTable.
+func (db *DB) AlterTable(name string) *AlterTableQuery {
+	return &AlterTableQuery{db: db, table: name}
+}
+
+// AddColumn declares a new column. Existing rows get its default; a required column
+// without default can only be added while no existing row lacks it.
+func (a *AlterTableQuery) AddColumn(col Column) *AlterTableQuery {
+	a.add = append(a.add, col)
+	return a
+}
+
+// DropColumn removes the column from the schema and the field from every row.
+func (a *AlterTableQuery) DropColumn(name string) *AlterTableQuery {
+	a.drop = append(a.drop, name)
+	return a
+}
+
+// RenameColumn renames a column and moves the field of every row to the new name.
+// Renames apply in the order given, so b -> c followed by a -> b keeps both values.
+func (a *AlterTableQuery) RenameColumn(from, to string) *AlterTableQuery {
+	a.rename = append(a.rename, [2]string{from, to})
+	return a
+}
+
+func (a *AlterTableQuery) Exec() error {
+	return a.ExecContext(context.Background())
+}
+
+// ExecContext applies all changes and rewrites the affected rows in one transaction.
+func (a *AlterTableQuery) ExecContext(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if a.table == "" {
+		return errors_consts.ErrEmptyName
+	}
+
+	return a.db.Database.Atomic(func(tx *Tx) error {
+		schema, err := loadSchema(tx.Get, a.table)
+		if err != nil {
+			return err
+		}
+		if schema == nil {
+			return fmt.Errorf("%w: %s", errors_consts.ErrTableNotFound, a.table)
+		}
+
+		indexes, err := loadIndexes(tx.Get, a.table)
+		if err != nil {
+			return err
+		}
+
+		texts, err := loadTextFields(tx.Get, a.table)
+		if err != nil {
+			return err
+		}
+
+		setReferences(tx, a.table, schema, false)
+
+		for _, name := range a.drop {
+			if _, ok := schema.column(name); !ok {
+				return fmt.Errorf("%w: %s has no column %s", errors_consts.ErrInvalidSchema, a.table, name)
+			}
+			for _, def := range indexes {
+				if slices.Contains(def.Fields, name) {
+					return fmt.Errorf("%w: column %s is used by index %s(%s), drop the index first",
+						errors_consts.ErrInvalidSchema, name, a.table, def.Name())
+				}
+			}
+			if slices.Contains(texts, name) {
+				return fmt.Errorf("%w: column %s has a text index, drop it first", errors_consts.ErrInvalidSchema, name)
+			}
+			schema.Columns = slices.DeleteFunc(schema.Columns, func(col Column) bool {
+				return col.Name == name
+			})
+		}
+
+		for _, r := range a.rename {
+			from, to := r[0], r[1]
+
+			i := slices.IndexFunc(schema.Columns, func(col Column) bool { return col.Name == from })
+			if i < 0 {
+				return fmt.Errorf("%w: %s has no column %s", errors_consts.ErrInvalidSchema, a.table, from)
+			}
+			if _, ok := schema.column(to); ok {
+				return fmt.Errorf("%w: %s already has a column %s", errors_consts.ErrInvalidSchema, a.table, to)
+			}
+			for _, def := range indexes {
+				if slices.Contains(def.Fields, from) {
+					return fmt.Errorf("%w: column %s is used by index %s(%s), drop the index first",
+						errors_consts.ErrInvalidSchema, from, a.table, def.Name())
+				}
+			}
+			if slices.Contains(texts, from) {
+				return fmt.Errorf("%w: column %s has a text index, drop it first", errors_consts.ErrInvalidSchema, from)
+			}
+
+			schema.Columns[i].Name = to
+		}
+
+		schema.Columns = append(schema.Columns, a.add...)
+
+		if err := schema.validate(); err != nil {
+			return err
+		}
+
+		tx.Set(schemaKey(a.table), encodeSchema(*schema))
+		setReferences(tx, a.table, schema, true)
+		return rewriteRows(tx, a.table, a.drop, a.rename)
+	})
+}
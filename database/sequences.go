@@ -0,0 +1,231 @@
+package database
+
+import (
+	"encoding/json"
+	"fmt"
+	"golangdb/errors_consts"
+	"sort"
+	"strings"
+)
+
+/*
+   Sequences
+
+   A sequence is a named counter living in two keys:
+     __seq__:<name>        -> JSON encoded SequenceOptions
+     __seq__:<name>:next   -> next value that has not been reserved yet
+
+   Values are reserved with Database.Increment, so two callers can never get the same value.
+   With Cache > 1 a whole block of values is reserved at once and handed out from memory;
+   values left in a block are lost when the process stops (gaps, never duplicates).
+*/
+
+const sequencePrefix = "__seq__:"
+
+type SequenceOptions struct {
+	Start int64 `json:"start"`
+	Step  int64 `json:"step"`
+	Cache int64 `json:"cache"`
+}
+
+type SequenceInfo struct {
+	Name  string `json:"name"`
+	Start int64  `json:"start"`
+	Step  int64  `json:"step"`
+	Cache int64  `json:"cache"`
+	Next  int64  `json:"next"`
+}
+
+// block of values reserved in storage but not handed out yet
+type sequenceCache struct {
+	next  int64
+	limit int64
+}
+
+func sequenceOptionsKey(name string) string {
+	return sequencePrefix + name
+}
+
+func sequenceValueKey(name string) string {
+	return sequencePrefix + name + ":next"
+}
+
+func (o SequenceOptions) withDefaults() SequenceOptions {
+	if o.Step == 0 {
+		o.Step = 1
+	}
+	if o.Cache <= 0 {
+		o.Cache = 1
+	}
+	if o.Start == 0 {
+		o.Start = 1
+	}
+	return o
+}
+
+func validateSequenceName(name string) error {
+	if name == "" || strings.Contains(name, ":") {
+		return fmt.Errorf("%w: bad name %q", errors_consts.ErrInvalidSequence, name)
+	}
+	return nil
+}
+
+func (db *DB) CreateSequence(name string, opts SequenceOptions) error {
+	if err := validateSequenceName(name); err != nil {
+		return err
+	}
+
+	opts = opts.withDefaults()
+
+	data, err := json.Marshal(opts)
+	if err != nil {
+		return err
+	}
+
+	// options and first value in one batch: a sequence never exists without its value
+	return db.Database.Atomic(func(tx *Tx) error {
+		if _, ok := tx.Get(sequenceOptionsKey(name)); ok {
+			return fmt.Errorf("%w: %s", errors_consts.ErrSequenceExists, name)
+		}
+
+		tx.Set(sequenceOptionsKey(name), data)
+		tx.Set(sequenceValueKey(name), mustJson(opts.Start))
+		return nil
+	})
+}
+
+func (db *DB) sequenceOptions(name string) (SequenceOptions, error) {
+	var opts SequenceOptions
+
+	raw, ok := db.Database.Get(sequenceOptionsKey(name))
+	if !ok {
+		return opts, fmt.Errorf("%w: %s", errors_consts.ErrSequenceNotFound, name)
+	}
+
+	if err := json.Unmarshal(raw, &opts); err != nil {
+		return opts, err
+	}
+	return opts, nil
+}
+
+// NextVal returns the next value of the sequence. It is safe for concurrent use.
+func (db *DB) NextVal(name string) (int64, error) {
+	opts, err := db.sequenceOptions(name)
+	if err != nil {
+		return 0, err
+	}
+
+	db.seqMu.Lock()
+	defer db.seqMu.Unlock()
+
+	cache := db.sequences[name]
+	if cache == nil || cache.next == cache.limit {
+		reserved := opts.Step * opts.Cache
+
+		end, err := db.Database.Increment(sequenceValueKey(name), reserved)
+		if err != nil {
+			return 0, err
+		}
+
+		cache = &sequenceCache{next: end - reserved, limit: end}
+		db.sequences[name] = cache
+	}
+
+	value := cache.next
+	cache.next += opts.Step
+	return value, nil
+}
+
+func (db *DB) SequenceInfo(name string) (SequenceInfo, error) {
+	opts, err := db.sequenceOptions(name)
+	if err != nil {
+		return SequenceInfo{}, err
+	}
+
+	return db.sequenceInfo(name, opts)
+}
+
+func (db *DB) sequenceInfo(name string, opts SequenceOptions) (SequenceInfo, error) {
+	info := SequenceInfo{
+		Name:  name,
+		Start: opts.Start,
+		Step:  opts.Step,
+		Cache: opts.Cache,
+	}
+
+	db.seqMu.Lock()
+	defer db.seqMu.Unlock()
+
+	if cache := db.sequences[name]; cache != nil && cache.next != cache.limit {
+		info.Next = cache.next
+		return info, nil
+	}
+
+	raw, ok := db.Database.Get(sequenceValueKey(name))
+	if !ok {
+		info.Next = opts.Start
+		return info, nil
+	}
+
+	if err := json.Unmarshal(raw, &info.Next); err != nil {
+		return info, err
+	}
+	return info, nil
+}
+
+func (db *DB) ListSequences() ([]SequenceInfo, error) {
+	raw := db.Database.ScanPrefix(sequencePrefix)
+	out := make([]SequenceInfo, 0, len(raw)/2)
+
+	for key, data := range raw {
+		name := strings.TrimPrefix(key, sequencePrefix)
+		if strings.Contains(name, ":") {
+			continue
+		}
+
+		var opts SequenceOptions
+		if err := json.Unmarshal(data, &opts); err != nil {
+			return nil, err
+		}
+
+		info, err := db.sequenceInfo(name, opts)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, info)
+	}
+
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Name < out[j].Name
+	})
+	return out, nil
+}
+
+// ResetSequence makes value the next value NextVal returns. Cached blocks are discarded.
+func (db *DB) ResetSequence(name string, value int64) error {
+	if _, err := db.sequenceOptions(name); err != nil {
+		return err
+	}
+
+	db.seqMu.Lock()
+	defer db.seqMu.Unlock()
+
+	delete(db.sequences, name)
+	return db.Database.Set(sequenceValueKey(name), mustJson(value))
+}
+
+func (db *DB) DropSequence(name string) error {
+	if _, err := db.sequenceOptions(name); err != nil {
+		return err
+	}
+
+	db.seqMu.Lock()
+	defer db.seqMu.Unlock()
+
+	delete(db.sequences, name)
+
+	if err := db.Database.Delete(sequenceValueKey(name)); err != nil {
+		return err
+	}
+	return db.Database.Delete(sequenceOptionsKey(name))
+}
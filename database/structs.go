@@ -0,0 +1,631 @@
+package database
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"golangdb/errors_consts"
+	"math"
+	"math/big"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+   Typed rows
+
+   type User struct {
+       ID      int64   `db:"id"`
+       Email   string  `db:"email"`
+       Address Address `db:"address"`
+   }
+
+   users := database.NewTable[User](db, "users")
+   id, err := users.Insert(&User{Email: "a@b.c"})
+   found, err := users.Find(database.Cond("email", "=", "a@b.c"))
+
+   Exported fields map to columns named by their `db` tag, or by the field name when the
+   tag is missing; `db:"-"` skips a field and `db:"name,omitempty"` leaves out zero values.
+   Embedded structs without a tag contribute their fields to the outer row. Nested
+   structs, slices and maps with string keys become objects and arrays, and nil pointers
+   become null (top-level nil fields are left out of the row).
+
+   Reading converts stored numbers to the field's kind exactly: an integer field takes
+   only whole numbers that fit it, so a fraction or an overflow is an error instead of a
+   silently changed value. Like "id" values (see parseID), it also takes a string holding
+   an integer ("7"); that is the only coercion. Columns without a field are ignored,
+   null leaves the zero value.
+   A zero "id" field is left out on insert so the table assigns the next id.
+*/
+
+type structField struct {
+	name      string
+	index     []int
+	omitEmpty bool
+}
+
+var structFields sync.Map // reflect.Type -> []structField
+
+func fieldsOf(t reflect.Type) []structField {
+	if cached, ok := structFields.Load(t); ok {
+		return cached.([]structField)
+	}
+
+	var fields []structField
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+
+		tag, hasTag := f.Tag.Lookup("db")
+		if tag == "-" {
+			continue
+		}
+
+		name, opts, _ := strings.Cut(tag, ",")
+
+		if f.Anonymous && !hasTag {
+			ft := f.Type
+			if ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				for _, inner := range fieldsOf(ft) {
+					inner.index = append([]int{i}, inner.index...)
+					fields = append(fields, inner)
+				}
+				continue
+			}
+		}
+
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+
+		fields = append(fields, structField{name: name, index: []int{i}, omitEmpty: opts == "omitempty"})
+	}
+
+	structFields.Store(t, fields)
+	return fields
+}
+
+// fieldByIndex is reflect.Value.FieldByIndex that allocates nil embedded pointers when
+// alloc is set and reports false when it meets one otherwise.
+func fieldByIndex(v reflect.Value, index []int, alloc bool) (reflect.Value, bool) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Pointer {
+			if v.IsNil() {
+				if !alloc {
+					return reflect.Value{}, false
+				}
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v, true
+}
+
+/*
+   Go values to rows
+*/
+
+// StructToRow converts a struct (or a pointer to one) into a row.
+func StructToRow(v any) (map[string]any, error) {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Pointer && !rv.IsNil() {
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("%w: %T is not a struct", errors_consts.ErrTypeMismatch, v)
+	}
+
+	out, err := toValue(rv, "")
+	if err != nil {
+		return nil, err
+	}
+
+	// a missing column reads back as nil as well, and tables without a schema reject null
+	row := out.(map[string]any)
+	for k, v := range row {
+		if v == nil {
+			delete(row, k)
+		}
+	}
+	return row, nil
+}
+
+var (
+	decimalType = reflect.TypeOf(Decimal{})
+	bigIntType  = reflect.TypeOf(big.Int{})
+	timeType    = reflect.TypeOf(time.Time{})
+	bytesType   = reflect.TypeOf([]byte(nil))
+	uuidType    = reflect.TypeOf(UUID{})
+)
+
+func toValue(v reflect.Value, path string) (any, error) {
+	// numbers and typed values stored as they are, not field by field
+	switch v.Type() {
+	case decimalType, timeType, uuidType:
+		return v.Interface(), nil
+	case bytesType:
+		if v.IsNil() {
+			return nil, nil
+		}
+		return bytes.Clone(v.Bytes()), nil
+	case bigIntType:
+		n := v.Interface().(big.Int)
+		return new(big.Int).Set(&n), nil
+	}
+
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		if v.IsNil() {
+			return nil, nil
+		}
+		return toValue(v.Elem(), path)
+
+	case reflect.Bool:
+		return v.Bool(), nil
+	case reflect.String:
+		return v.String(), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int(), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if v.Uint() > math.MaxInt64 {
+			return nil, fmt.Errorf("%w: %s: %d does not fit int64", errors_consts.ErrTypeMismatch, path, v.Uint())
+		}
+		return int64(v.Uint()), nil
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), nil
+
+	case reflect.Slice:
+		if v.IsNil() {
+			return nil, nil
+		}
+		fallthrough
+	case reflect.Array:
+		out := make([]any, v.Len())
+		for i := range out {
+			item, err := toValue(v.Index(i), fmt.Sprintf("%s[%d]", path, i))
+			if err != nil {
+				return nil, err
+			}
+			out[i] = item
+		}
+		return out, nil
+
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			return nil, fmt.Errorf("%w: %s: map keys must be strings, got %s", errors_consts.ErrTypeMismatch, path, v.Type().Key())
+		}
+		if v.IsNil() {
+			return nil, nil
+		}
+		out := make(map[string]any, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			key := iter.Key().String()
+			item, err := toValue(iter.Value(), joinPath(path, key))
+			if err != nil {
+				return nil, err
+			}
+			out[key] = item
+		}
+		return out, nil
+
+	case reflect.Struct:
+		out := make(map[string]any)
+		for _, f := range fieldsOf(v.Type()) {
+			fv, ok := fieldByIndex(v, f.index, false)
+			if !ok || (f.omitEmpty && fv.IsZero()) {
+				continue
+			}
+			item, err := toValue(fv, joinPath(path, f.name))
+			if err != nil {
+				return nil, err
+			}
+			out[f.name] = item
+		}
+		return out, nil
+	}
+
+	return nil, fmt.Errorf("%w: %s: unsupported type %s", errors_consts.ErrTypeMismatch, path, v.Type())
+}
+
+func joinPath(path, field string) string {
+	if path == "" {
+		return field
+	}
+	return path + "." + field
+}
+
+/*
+   Rows to Go values
+*/
+
+// RowToStruct fills the struct dst points to from row.
+func RowToStruct(row map[string]any, dst any) error {
+	rv := reflect.ValueOf(dst)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("%w: %T is not a pointer to a struct", errors_consts.ErrTypeMismatch, dst)
+	}
+	return fromValue(row, rv.Elem(), "")
+}
+
+func fromValue(src any, dst reflect.Value, path string) error {
+	if src == nil {
+		dst.SetZero()
+		return nil
+	}
+
+	mismatch := func() error {
+		return fmt.Errorf("%w: %s: cannot store %T in %s", errors_consts.ErrTypeMismatch, path, src, dst.Type())
+	}
+
+	switch dst.Type() {
+	case decimalType:
+		n, ok := toNumber(src)
+		if !ok {
+			return mismatch()
+		}
+		dst.Set(reflect.ValueOf(n.decimal()))
+		return nil
+
+	case bigIntType:
+		n, ok := toNumber(src)
+		if !ok {
+			return mismatch()
+		}
+		digits, ok := n.parts().integer()
+		if !ok {
+			return mismatch()
+		}
+		dst.Addr().Interface().(*big.Int).SetString(digits, 10)
+		return nil
+
+	case timeType, bytesType, uuidType:
+		if reflect.TypeOf(src) != dst.Type() {
+			return mismatch()
+		}
+		dst.Set(reflect.ValueOf(src))
+		return nil
+	}
+
+	switch dst.Kind() {
+	case reflect.Pointer:
+		if dst.IsNil() {
+			dst.Set(reflect.New(dst.Type().Elem()))
+		}
+		return fromValue(src, dst.Elem(), path)
+
+	case reflect.Interface:
+		if dst.NumMethod() != 0 {
+			return mismatch()
+		}
+		dst.Set(reflect.ValueOf(src))
+		return nil
+
+	case reflect.Bool:
+		b, ok := src.(bool)
+		if !ok {
+			return mismatch()
+		}
+		dst.SetBool(b)
+		return nil
+
+	case reflect.String:
+		s, ok := src.(string)
+		if !ok {
+			return mismatch()
+		}
+		dst.SetString(s)
+		return nil
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := toInt64(src)
+		if err != nil || dst.OverflowInt(n) {
+			return fmt.Errorf("%w: %s: %v does not fit %s", errors_consts.ErrTypeMismatch, path, src, dst.Type())
+		}
+		dst.SetInt(n)
+		return nil
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		n, err := toInt64(src)
+		if err != nil || n < 0 || dst.OverflowUint(uint64(n)) {
+			return fmt.Errorf("%w: %s: %v does not fit %s", errors_consts.ErrTypeMismatch, path, src, dst.Type())
+		}
+		dst.SetUint(uint64(n))
+		return nil
+
+	case reflect.Float32, reflect.Float64:
+		f, ok := toFloat64(src)
+		if !ok {
+			return mismatch()
+		}
+		dst.SetFloat(f)
+		return nil
+
+	case reflect.Slice, reflect.Array:
+		items, ok := src.([]any)
+		if !ok {
+			return mismatch()
+		}
+		if dst.Kind() == reflect.Array {
+			if len(items) > dst.Len() {
+				return fmt.Errorf("%w: %s: %d items do not fit %s", errors_consts.ErrTypeMismatch, path, len(items), dst.Type())
+			}
+			dst.SetZero()
+		} else {
+			dst.Set(reflect.MakeSlice(dst.Type(), len(items), len(items)))
+		}
+		for i, item := range items {
+			if err := fromValue(item, dst.Index(i), fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case reflect.Map:
+		obj, ok := src.(map[string]any)
+		if !ok || dst.Type().Key().Kind() != reflect.String {
+			return mismatch()
+		}
+		m := reflect.MakeMapWithSize(dst.Type(), len(obj))
+		for k, item := range obj {
+			elem := reflect.New(dst.Type().Elem()).Elem()
+			if err := fromValue(item, elem, joinPath(path, k)); err != nil {
+				return err
+			}
+			m.SetMapIndex(reflect.ValueOf(k).Convert(dst.Type().Key()), elem)
+		}
+		dst.Set(m)
+		return nil
+
+	case reflect.Struct:
+		obj, ok := src.(map[string]any)
+		if !ok {
+			return mismatch()
+		}
+		for _, f := range fieldsOf(dst.Type()) {
+			item, ok := obj[f.name]
+			if !ok {
+				item, ok = lookupFold(obj, f.name)
+			}
+			if !ok {
+				continue
+			}
+
+			fv, _ := fieldByIndex(dst, f.index, true)
+			if err := fromValue(item, fv, joinPath(path, f.name)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	return mismatch()
+}
+
+// lookupFold finds a column whose name matches field ignoring case, like encoding/json.
+func lookupFold(obj map[string]any, field string) (any, bool) {
+	for k, v := range obj {
+		if strings.EqualFold(k, field) {
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+// toInt64 converts a stored number, or a string holding an integer as ids accept it,
+// into an integer without losing anything.
+func toInt64(v any) (int64, error) {
+	if s, ok := v.(string); ok {
+		return strconv.ParseInt(s, 10, 64)
+	}
+
+	n, ok := toNumber(v)
+	if !ok {
+		return 0, fmt.Errorf("%T is not a number", v)
+	}
+
+	i, ok := n.int64()
+	if !ok {
+		return 0, fmt.Errorf("%v is not an int64", v)
+	}
+	return i, nil
+}
+
+func toFloat64(v any) (float64, bool) {
+	n, ok := toNumber(v)
+	if !ok {
+		return 0, false
+	}
+
+	switch n.kind {
+	case numInt:
+		return float64(n.i), true
+	case numFloat:
+		return n.f, true
+	}
+	return n.d.Float64(), true
+}
+
+/*
+   Generic helpers
+*/
+
+// InsertStruct inserts v into table and returns its id. A zero "id" field is left out
+// so the table assigns one, which is then written back into v.
+func InsertStruct[T any](db *DB, table string, v *T) (int64, error) {
+	return InsertStructContext(context.Background(), db, table, v)
+}
+
+func InsertStructContext[T any](ctx context.Context, db *DB, table string, v *T) (int64, error) {
+	row, err := structRow(v)
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := db.Insert().Table(table).Values(row).ExecAndReturnIDContext(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	return id, setStructID(v, id)
+}
+
+// SelectInto runs q and converts its rows into T.
+func SelectInto[T any](q *SelectQuery) ([]T, error) {
+	return SelectIntoContext[T](context.Background(), q)
+}
+
+func SelectIntoContext[T any](ctx context.Context, q *SelectQuery) ([]T, error) {
+	rows, err := q.AllContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]T, len(rows))
+	for i, row := range rows {
+		if err := RowToStruct(row, &out[i]); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+func structRow[T any](v *T) (map[string]any, error) {
+	row, err := StructToRow(v)
+	if err != nil {
+		return nil, err
+	}
+
+	if id, ok := row["id"]; ok {
+		if n, err := toInt64(id); err == nil && n == 0 {
+			delete(row, "id")
+		}
+	}
+	return row, nil
+}
+
+// setStructID writes id into the field mapped to "id", if v has one
+func setStructID[T any](v *T, id int64) error {
+	rv := reflect.ValueOf(v).Elem()
+	for _, f := range fieldsOf(rv.Type()) {
+		if f.name != "id" {
+			continue
+		}
+
+		fv, _ := fieldByIndex(rv, f.index, true)
+		return fromValue(id, fv, "id")
+	}
+	return nil
+}
+
+// Table is a table whose rows are Ts.
+type Table[T any] struct {
+	db   *DB
+	name string
+}
+
+func NewTable[T any](db *DB, name string) *Table[T] {
+	return &Table[T]{db: db, name: name}
+}
+
+func (t *Table[T]) Name() string {
+	return t.name
+}
+
+// Insert stores v and sets its id field to the assigned id.
+func (t *Table[T]) Insert(v *T) (int64, error) {
+	return InsertStruct(t.db, t.name, v)
+}
+
+func (t *Table[T]) InsertContext(ctx context.Context, v *T) (int64, error) {
+	return InsertStructContext(ctx, t.db, t.name, v)
+}
+
+// InsertMany stores all values in one atomic batch (see DB.InsertMany) and sets their ids.
+func (t *Table[T]) InsertMany(vs []T) ([]int64, error) {
+	return t.InsertManyContext(context.Background(), vs)
+}
+
+func (t *Table[T]) InsertManyContext(ctx context.Context, vs []T) ([]int64, error) {
+	rows := make([]map[string]any, len(vs))
+	for i := range vs {
+		row, err := structRow(&vs[i])
+		if err != nil {
+			return nil, err
+		}
+		rows[i] = row
+	}
+
+	ids, err := t.db.InsertManyContext(ctx, t.name, rows)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, id := range ids {
+		if err := setStructID(&vs[i], id); err != nil {
+			return nil, err
+		}
+	}
+	return ids, nil
+}
+
+// Upsert writes v with Upsert().On(on...) in merge mode; on defaults to "id".
+func (t *Table[T]) Upsert(v *T, on ...string) (int64, error) {
+	row, err := structRow(v)
+	if err != nil {
+		return 0, err
+	}
+
+	q := t.db.Upsert().Table(t.name).Values(row)
+	if len(on) > 0 {
+		q = q.On(on...)
+	}
+
+	id, err := q.Exec()
+	if err != nil {
+		return 0, err
+	}
+	return id, setStructID(v, id)
+}
+
+// Get reads the row with id directly by its key; found is false when there is none.
+func (t *Table[T]) Get(id int64) (v T, found bool, err error) {
+	raw, ok := t.db.Database.Get(rowKey(t.name, fmt.Sprint(id)))
+	if !ok {
+		return v, false, nil
+	}
+
+	row, err := decodeRow(raw)
+	if err != nil {
+		return v, false, err
+	}
+
+	if err := RowToStruct(row, &v); err != nil {
+		return v, false, err
+	}
+	return v, true, nil
+}
+
+// Find returns the rows matching all conds.
+func (t *Table[T]) Find(conds ...*Condition) ([]T, error) {
+	q := t.Select()
+	for _, c := range conds {
+		q = q.WhereCond(c)
+	}
+	return SelectInto[T](q)
+}
+
+// Select starts a query on the table for the full builder; run it with SelectInto.
+func (t *Table[T]) Select() *SelectQuery {
+	return t.db.Select().Table(t.name)
+}
@@ -2,9 +2,16 @@ package database
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"golangdb/errors_consts"
+	"maps"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
 )
 
 /*
@@ -13,10 +20,21 @@ import (
 
 type DB struct {
 	Database *Database
+
+	seqMu     sync.Mutex
+	sequences map[string]*sequenceCache
+
+	hookMu sync.RWMutex
+	hooks  map[hookKey][]Hook
+
+	migrateMu sync.Mutex
 }
 
 func NewDB(storage *Database) *DB {
-	return &DB{Database: storage}
+	return &DB{
+		Database:  storage,
+		sequences: make(map[string]*sequenceCache),
+	}
 }
 
 /*
@@ -30,23 +48,40 @@ type InsertQuery struct {
 }
 
 type SelectQuery struct {
-	db    *DB
-	table string
-	where *WhereClause
-	err   error
+	db     *DB
+	table  string
+	orders []orderKey
+	limit  int
+	offset int
+	proj   projection
+	joins  []*join
+	filter
+
+	groupBy []string
+	aggs    []aggregate
+	having  filter
 }
 
 type DeleteQuery struct {
 	db    *DB
 	table string
-	where *WhereClause
-	err   error
+	filter
+}
+
+type UpdateQuery struct {
+	db     *DB
+	table  string
+	values map[string]any
+	filter
 }
 
 type WhereClause struct {
 	field    string
+	path     fieldPath // set when field is a nested path like address.city
 	operator string
 	value    any
+	pattern  *regexp.Regexp
+	text     *textQuery // parsed query of "match"
 }
 
 /*
@@ -65,6 +100,10 @@ func (db *DB) Delete() *DeleteQuery {
 	return &DeleteQuery{db: db}
 }
 
+func (db *DB) Update() *UpdateQuery {
+	return &UpdateQuery{db: db}
+}
+
 /*
    Insert
 */
@@ -80,70 +119,98 @@ func (q *InsertQuery) Values(values map[string]any) *InsertQuery {
 }
 
 // auto ID generation
+// The meta key keeps the next id to hand out. It is read and advanced inside the write
+// transaction, so concurrent inserts into the same table never get the same id and a
+// failed insert does not use one up.
+
+func nextIDKey(table string) string {
+	return "__Meta__:" + table + ":next_id"
+}
 
-func (q *InsertQuery) nextID() (int64, error) {
-	metaKey := "__Meta__:" + q.table + ":next_id"
+// reserveIDs hands out n consecutive ids of table and returns the first one.
+func reserveIDs(tx *Tx, table string, n int) (int64, error) {
+	key := nextIDKey(table)
+	next := int64(1)
 
-	raw, ok := q.db.Database.Get(metaKey)
-	if !ok {
-		if err := q.db.Database.Set(metaKey, mustJson(int64(2))); err != nil {
-			return 0, nil
+	if raw, ok := tx.Get(key); ok {
+		if err := json.Unmarshal(raw, &next); err != nil {
+			return 0, fmt.Errorf("key %s does not hold an integer: %w", key, err)
 		}
-		return 1, nil
 	}
 
-	var next int64
-
-	if err := json.Unmarshal(raw, &next); err != nil {
-		return 0, err
+	if next > math.MaxInt64-int64(n) {
+		return 0, fmt.Errorf("ids of table %s are exhausted", table)
 	}
 
-	if err := q.db.Database.Set(metaKey, mustJson(next+1)); err != nil {
-		return 0, err
-	}
+	tx.Set(key, mustJson(next+int64(n)))
 	return next, nil
 }
 
-func (q *InsertQuery) Exec() error {
-	_, err := q.ExecAndReturnID()
-	if err != nil {
-		return err
+// advanceIDs moves the id counter of table past an id chosen by the caller, so later
+// inserts without id never hand it out again.
+func advanceIDs(tx *Tx, table string, id int64) error {
+	key := nextIDKey(table)
+	next := int64(1)
+
+	if raw, ok := tx.Get(key); ok {
+		if err := json.Unmarshal(raw, &next); err != nil {
+			return fmt.Errorf("key %s does not hold an integer: %w", key, err)
+		}
+	}
+
+	if id >= next {
+		if id < math.MaxInt64 {
+			id++
+		}
+		tx.Set(key, mustJson(id))
 	}
 	return nil
-	//if q.table == "" {
-	//	return errors_consts.ErrEmptyName
-	//}
-	//if len(q.values) == 0 {
-	//	return errors_consts.ErrEmptyValues
-	//}
-	//
-	//idRaw, ok := q.values["id"]
-	//if !ok {
-	//	id, err := q.nextID()
-	//	if err != nil {
-	//		return err
-	//	}
-	//	idRaw = id
-	//	q.values["id"] = idRaw
-	//}
-	//
-	//// validate values
-	//for k, v := range q.values {
-	//	if !isAllowedValue(v) {
-	//		return fmt.Errorf("unsupported value type for field %s", k)
-	//	}
-	//}
-	//
-	//data, err := json.Marshal(q.values)
-	//if err != nil {
-	//	return err
-	//}
-	//
-	//key := q.table + ":" + fmt.Sprint(idRaw)
-	//return q.db.database.Set(key, data)
+}
+
+// parseID reads an "id" value given by the caller: an integer number or a string holding
+// one ("1"). Ids are int64; a fraction or a value out of range is rejected instead of
+// being truncated.
+func parseID(raw any) (int64, error) {
+	if v, ok := raw.(string); ok {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("id %q is not an integer", v)
+		}
+		return n, nil
+	}
+
+	n, ok := toNumber(raw)
+	if !ok {
+		return 0, fmt.Errorf("unsupported id type %T", raw)
+	}
+
+	id, ok := n.int64()
+	if !ok {
+		return 0, fmt.Errorf("id %v is not an int64", raw)
+	}
+	return id, nil
+}
+
+func (q *InsertQuery) Exec() error {
+	return q.ExecContext(context.Background())
+}
+
+func (q *InsertQuery) ExecContext(ctx context.Context) error {
+	_, err := q.ExecAndReturnIDContext(ctx)
+	return err
 }
 
 func (q *InsertQuery) ExecAndReturnID() (int64, error) {
+	return q.ExecAndReturnIDContext(context.Background())
+}
+
+// ExecAndReturnIDContext inserts the row and returns its id. A row that already exists
+// under the given id is left alone and ErrRowExists returned; use Upsert to overwrite it.
+func (q *InsertQuery) ExecAndReturnIDContext(ctx context.Context) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
 	if q.table == "" {
 		return 0, errors_consts.ErrEmptyName
 	}
@@ -151,52 +218,70 @@ func (q *InsertQuery) ExecAndReturnID() (int64, error) {
 		return 0, errors_consts.ErrEmptyValues
 	}
 
+	if err := checkValues(q.values); err != nil {
+		return 0, err
+	}
+
+	// last chance to back out before the row becomes durable
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
 	var id int64
+	hooks := q.db.newHookRun(ctx)
 
-	if raw, ok := q.values["id"]; ok {
-		switch v := raw.(type) {
-		case int:
-			id = int64(v)
-		case int64:
-			id = v
-		case json.Number:
-			n, err := v.Int64()
-			if err != nil {
-				return 0, err
-			}
-			id = n
-		case float64:
-			id = int64(v)
-		default:
-			return 0, fmt.Errorf("unsupported id type %T", raw)
-		}
-	} else {
+	err := q.db.Database.Atomic(func(tx *Tx) error {
 		var err error
-		id, err := q.nextID()
-		if err != nil {
-			return 0, err
-		}
-		q.values["id"] = id
+
+		id, err = insertRow(tx, q.table, q.values, hooks)
+		return err
+	})
+	if err != nil {
+		return 0, err
 	}
 
-	for k, v := range q.values {
-		if !isAllowedValue(v) {
-			return 0, fmt.Errorf("unsupported value type for field %s", k)
+	return id, hooks.after()
+}
+
+// checkValues rejects values that cannot be stored; nulls are checked against the
+// table schema when the row is written
+func checkValues(values map[string]any) error {
+	for k, v := range values {
+		if v != nil && !isAllowedValue(v) {
+			return fmt.Errorf("unsupported value type for field %s", k)
 		}
 	}
+	return nil
+}
 
-	data, err := json.Marshal(q.values)
+// insertRow writes a new row, reserving an id when values has none.
+func insertRow(tx *Tx, table string, values map[string]any, hooks *hookRun) (int64, error) {
+	var id int64
+	var err error
 
-	if err != nil {
-		return 0, err
+	if raw, ok := values["id"]; ok {
+		if id, err = parseID(raw); err != nil {
+			return 0, err
+		}
+		if err := advanceIDs(tx, table, id); err != nil {
+			return 0, err
+		}
+	} else {
+		if id, err = reserveIDs(tx, table, 1); err != nil {
+			return 0, err
+		}
+		values["id"] = id
 	}
 
-	key := q.table + ":" + fmt.Sprint(id)
-	if err := q.db.Database.Set(key, data); err != nil {
-		return 0, err
+	// ids stored before explicit ids advanced the counter may still be taken
+	if _, exists := tx.Get(rowKey(table, fmt.Sprint(id))); exists {
+		return 0, fmt.Errorf("%w: %s id %d", errors_consts.ErrRowExists, table, id)
 	}
 
-	return id, nil
+	if err := hooks.before(tx, table, BeforeInsert, id, values, nil); err != nil {
+		return 0, err
+	}
+	return id, writeRow(tx, table, fmt.Sprint(id), values)
 }
 
 /*
@@ -209,53 +294,302 @@ func (s *SelectQuery) Table(name string) *SelectQuery {
 }
 
 func (s *SelectQuery) Where(field, op string, value any) *SelectQuery {
-	switch op {
-	case "=", "!=", "<", ">":
+	s.add(Cond(field, op, value))
+	return s
+}
+
+// WhereCond adds a condition tree built with Cond, And, Or and Not.
+func (s *SelectQuery) WhereCond(cond *Condition) *SelectQuery {
+	s.add(cond)
+	return s
+}
+
+func (s *SelectQuery) Or(conds ...*Condition) *SelectQuery {
+	s.add(Or(conds...))
+	return s
+}
+
+func (s *SelectQuery) Not(cond *Condition) *SelectQuery {
+	s.add(Not(cond))
+	return s
+}
+
+// OrderBy adds a sort key. Call it again to break ties with further keys.
+func (s *SelectQuery) OrderBy(field string, order SortOrder) *SelectQuery {
+	if field == "" {
+		s.err = fmt.Errorf("%w: order by field is empty", errors_consts.ErrInvalidQuery)
+		return s
+	}
+
+	switch SortOrder(strings.ToLower(string(order))) {
+	case Asc, "":
+		s.orders = append(s.orders, orderKey{field: field})
+	case Desc:
+		s.orders = append(s.orders, orderKey{field: field, desc: true})
 	default:
-		s.err = fmt.Errorf("unsupported operator %s", op)
+		s.err = fmt.Errorf("%w: unsupported sort order %q", errors_consts.ErrInvalidQuery, order)
+	}
+	return s
+}
+
+// Limit caps the number of rows returned. 0 means no limit.
+func (s *SelectQuery) Limit(n int) *SelectQuery {
+	if n < 0 {
+		s.err = fmt.Errorf("%w: negative limit %d", errors_consts.ErrInvalidQuery, n)
 		return s
 	}
-	if !isAllowedValue(value) {
-		s.err = fmt.Errorf("unsupported where value type %T", value)
+	s.limit = n
+	return s
+}
+
+func (s *SelectQuery) Offset(n int) *SelectQuery {
+	if n < 0 {
+		s.err = fmt.Errorf("%w: negative offset %d", errors_consts.ErrInvalidQuery, n)
 		return s
 	}
+	s.offset = n
+	return s
+}
+
+// Columns keeps only the given fields in the result. "field AS alias" renames a field.
+func (s *SelectQuery) Columns(cols ...string) *SelectQuery {
+	for _, spec := range cols {
+		c, err := parseColumn(spec)
+		if err != nil {
+			s.err = err
+			return s
+		}
+		s.proj.columns = append(s.proj.columns, c)
+	}
+	return s
+}
+
+// Exclude drops the given fields (after renaming, if Columns is used too) from the result.
+func (s *SelectQuery) Exclude(fields ...string) *SelectQuery {
+	if s.proj.exclude == nil {
+		s.proj.exclude = make(map[string]bool, len(fields))
+	}
+	for _, f := range fields {
+		s.proj.exclude[f] = true
+	}
+	return s
+}
+
+// Aggregate adds an aggregate column: fn is count, count_distinct, sum, avg, min or max.
+// An empty alias defaults to fn (count) or fn_field (sum_amount).
+func (s *SelectQuery) Aggregate(fn, field, alias string) *SelectQuery {
+	agg, err := newAggregate(fn, field, alias)
+	if err != nil {
+		s.err = err
+		return s
+	}
+	s.aggs = append(s.aggs, agg)
+	return s
+}
+
+func (s *SelectQuery) Count() *SelectQuery {
+	return s.Aggregate("count", "", "")
+}
+
+func (s *SelectQuery) CountDistinct(field string) *SelectQuery {
+	return s.Aggregate("count_distinct", field, "")
+}
+
+func (s *SelectQuery) Sum(field string) *SelectQuery {
+	return s.Aggregate("sum", field, "")
+}
+
+func (s *SelectQuery) Avg(field string) *SelectQuery {
+	return s.Aggregate("avg", field, "")
+}
+
+func (s *SelectQuery) Min(field string) *SelectQuery {
+	return s.Aggregate("min", field, "")
+}
+
+func (s *SelectQuery) Max(field string) *SelectQuery {
+	return s.Aggregate("max", field, "")
+}
+
+func (s *SelectQuery) GroupBy(fields ...string) *SelectQuery {
+	s.groupBy = append(s.groupBy, fields...)
+	return s
+}
 
-	s.where = &WhereClause{
-		field:    field,
-		operator: op,
-		value:    value,
+// Having filters grouped rows; it can refer to group fields and aggregate aliases.
+func (s *SelectQuery) Having(field, op string, value any) *SelectQuery {
+	return s.HavingCond(Cond(field, op, value))
+}
+
+func (s *SelectQuery) HavingCond(cond *Condition) *SelectQuery {
+	s.having.add(cond)
+	if s.having.err != nil && s.err == nil {
+		s.err = s.having.err
 	}
 	return s
 }
 
+func (s *SelectQuery) aggregating() bool {
+	return len(s.aggs) > 0 || len(s.groupBy) > 0
+}
+
 func (s *SelectQuery) All() ([]map[string]any, error) {
+	return s.AllContext(context.Background())
+}
+
+// AllContext stops scanning and decoding as soon as ctx is cancelled or its deadline passes.
+func (s *SelectQuery) AllContext(ctx context.Context) ([]map[string]any, error) {
+	rows, _, err := s.run(ctx)
+	return rows, err
+}
+
+// Explain runs the query and returns its plan instead of the rows: how the table was read,
+// the planner's row estimate, the rows actually examined and returned, and the steps
+// applied after reading.
+func (s *SelectQuery) Explain() (*Plan, error) {
+	return s.ExplainContext(context.Background())
+}
+
+func (s *SelectQuery) ExplainContext(ctx context.Context) (*Plan, error) {
+	_, plan, err := s.run(ctx)
+	return plan, err
+}
+
+func (s *SelectQuery) run(ctx context.Context) ([]map[string]any, *Plan, error) {
 	if s.err != nil {
-		return nil, s.err
+		return nil, nil, s.err
 	}
 
 	if s.table == "" {
-		return nil, errors_consts.ErrEmptyName
+		return nil, nil, errors_consts.ErrEmptyName
+	}
+
+	if len(s.having.conds) > 0 && !s.aggregating() {
+		return nil, nil, fmt.Errorf("%w: having needs GroupBy or an aggregate", errors_consts.ErrInvalidQuery)
+	}
+
+	// with joins the conditions address namespaced rows, so the base table is read in full
+	var jn *joiner
+	fetchFilter := &s.filter
+
+	if len(s.joins) > 0 {
+		if err := s.planJoins(); err != nil {
+			return nil, nil, err
+		}
+
+		var err error
+		if jn, err = s.newJoiner(ctx); err != nil {
+			return nil, nil, err
+		}
+		fetchFilter = &filter{}
+	}
+
+	collector := &rowCollector{orders: s.orders}
+	if s.limit > 0 {
+		collector.keep = s.offset + s.limit
+	}
+
+	var agg *aggregator
+	if s.aggregating() {
+		agg = newAggregator(s.groupBy, s.aggs)
 	}
 
-	raw := s.db.Database.ScanPrefix(s.table + ":")
-	out := make([]map[string]any, 0, len(raw))
+	// rows are decoded and folded in as they are read: an aggregate keeps only its groups
+	plan, err := s.db.visitRows(ctx, s.table, fetchFilter, func(key string, data []byte) error {
+		row, err := decodeRow(data)
+		if err != nil {
+			return err
+		}
 
-	for _, data := range raw {
-		var row map[string]any
+		rows := []resultRow{{key: key, row: row}}
+		if jn != nil {
+			rows = jn.expand(key, row)
+		}
 
-		dec := json.NewDecoder(bytes.NewReader(data))
-		dec.UseNumber()
+		for _, r := range rows {
+			if !s.matches(r.row) {
+				continue
+			}
 
-		if err := dec.Decode(&row); err != nil {
-			return nil, err
+			if agg != nil {
+				agg.add(r.row)
+				continue
+			}
+			collector.add(r)
 		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
 
-		if s.where == nil || s.where.match(row) {
-			out = append(out, row)
+	if agg != nil {
+		for _, r := range agg.rows() {
+			if s.having.matches(r.row) {
+				collector.add(r)
+			}
 		}
 	}
 
-	return out, nil
+	rows := collector.result(s.offset, s.limit)
+	for i, row := range rows {
+		rows[i] = s.proj.apply(row)
+	}
+
+	if jn != nil {
+		plan.Joins = jn.plans
+	}
+	plan.RowsReturned = len(rows)
+	plan.Operations = s.operations()
+
+	return rows, plan, nil
+}
+
+// operations lists what the query does after reading the table, in order
+func (s *SelectQuery) operations() []string {
+	var ops []string
+
+	for _, j := range s.joins {
+		kind := "hash join"
+		if j.left {
+			kind = "hash left join"
+		}
+		ops = append(ops, fmt.Sprintf("%s %s on %s = %s", kind, j.name, j.probe, j.name+"."+j.build))
+	}
+	if len(s.conds) > 0 {
+		ops = append(ops, "filter")
+	}
+	if s.aggregating() {
+		op := "aggregate"
+		if len(s.groupBy) > 0 {
+			op += " group by " + strings.Join(s.groupBy, ", ")
+		}
+		ops = append(ops, op)
+		if len(s.having.conds) > 0 {
+			ops = append(ops, "having")
+		}
+	}
+	if len(s.orders) > 0 {
+		keys := make([]string, len(s.orders))
+		for i, o := range s.orders {
+			keys[i] = o.field
+			if o.desc {
+				keys[i] += " desc"
+			}
+		}
+		op := "sort by " + strings.Join(keys, ", ")
+		if s.limit > 0 {
+			op = fmt.Sprintf("top %d %s", s.offset+s.limit, op)
+		}
+		ops = append(ops, op)
+	}
+	if s.limit > 0 || s.offset > 0 {
+		ops = append(ops, fmt.Sprintf("limit %d offset %d", s.limit, s.offset))
+	}
+	if !s.proj.empty() {
+		ops = append(ops, "project columns")
+	}
+	return ops
 }
 
 /*
@@ -268,60 +602,229 @@ func (d *DeleteQuery) Table(name string) *DeleteQuery {
 }
 
 func (d *DeleteQuery) Where(field, op string, value any) *DeleteQuery {
-	switch op {
-	case "=", "!=", "<", ">":
-	default:
-		d.err = fmt.Errorf("unsupported operator %s", op)
-		return d
-	}
-	if !isAllowedValue(value) {
-		d.err = fmt.Errorf("unsupported where value type %T", value)
-		return d
-	}
+	d.add(Cond(field, op, value))
+	return d
+}
 
-	d.where = &WhereClause{
-		field:    field,
-		operator: op,
-		value:    value,
-	}
+// WhereCond adds a condition tree built with Cond, And, Or and Not.
+func (d *DeleteQuery) WhereCond(cond *Condition) *DeleteQuery {
+	d.add(cond)
+	return d
+}
+
+func (d *DeleteQuery) Or(conds ...*Condition) *DeleteQuery {
+	d.add(Or(conds...))
+	return d
+}
+
+func (d *DeleteQuery) Not(cond *Condition) *DeleteQuery {
+	d.add(Not(cond))
 	return d
 }
 
 func (d *DeleteQuery) Exec() error {
+	return d.ExecContext(context.Background())
+}
+
+// ExecContext deletes the matching rows, with their foreign key actions and hooks, in one
+// transaction: a failing row or a ctx that ends before the commit deletes nothing.
+func (d *DeleteQuery) ExecContext(ctx context.Context) error {
+	_, err := d.ExecAndCountContext(ctx)
+	return err
+}
+
+func (d *DeleteQuery) ExecAndCount() (int, error) {
+	return d.ExecAndCountContext(context.Background())
+}
+
+// ExecAndCountContext deletes like ExecContext and returns how many rows it removed.
+func (d *DeleteQuery) ExecAndCountContext(ctx context.Context) (int, error) {
 	if d.err != nil {
-		return d.err
+		return 0, d.err
 	}
 
 	if d.table == "" {
-		return errors_consts.ErrEmptyName
+		return 0, errors_consts.ErrEmptyName
 	}
 
 	prefix := d.table + ":"
-	raw := d.db.Database.ScanPrefix(prefix)
+	raw, _, err := d.db.fetchRows(ctx, d.table, &d.filter)
+	if err != nil {
+		return 0, err
+	}
+
+	hooks := d.db.newHookRun(ctx)
+	watched := hooks.watches(d.table, BeforeDelete)
+
+	deleted := 0
+	err = d.db.Database.Atomic(func(tx *Tx) error {
+		for key := range raw {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			// the row is checked again inside the transaction in case it changed since the
+			// scan; a cascade of an earlier row may also have removed it
+			data, ok := tx.Get(key)
+			if !ok {
+				continue
+			}
 
-	for key, data := range raw {
-		if d.where == nil {
-			// this DELETES all the table!
-			if err := d.db.Database.Delete(key); err != nil {
+			id := strings.TrimPrefix(key, prefix)
+
+			if len(d.conds) > 0 || watched {
+				row, err := decodeRow(data)
+				if err != nil {
+					return err
+				}
+				if !d.matches(row) {
+					continue
+				}
+
+				n, _ := strconv.ParseInt(id, 10, 64)
+				if err := hooks.before(tx, d.table, BeforeDelete, n, row, nil); err != nil {
+					return err
+				}
+			}
+			// without conditions this DELETES all the table!
+			if err := removeRow(tx, hooks, d.table, id); err != nil {
 				return err
 			}
-			continue
+			deleted++
 		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return deleted, hooks.after()
+}
+
+/*
+   Update
+*/
 
-		var row map[string]any
+func (u *UpdateQuery) Table(name string) *UpdateQuery {
+	u.table = name
+	return u
+}
 
-		dec := json.NewDecoder(bytes.NewReader(data))
-		dec.UseNumber()
+// Set merges values into every matching row. Fields not mentioned are kept as they are.
+func (u *UpdateQuery) Set(values map[string]any) *UpdateQuery {
+	u.values = values
+	return u
+}
 
-		if err := dec.Decode(&row); err != nil {
-			return err
+func (u *UpdateQuery) Where(field, op string, value any) *UpdateQuery {
+	u.add(Cond(field, op, value))
+	return u
+}
+
+// WhereCond adds a condition tree built with Cond, And, Or and Not.
+func (u *UpdateQuery) WhereCond(cond *Condition) *UpdateQuery {
+	u.add(cond)
+	return u
+}
+
+func (u *UpdateQuery) Or(conds ...*Condition) *UpdateQuery {
+	u.add(Or(conds...))
+	return u
+}
+
+func (u *UpdateQuery) Not(cond *Condition) *UpdateQuery {
+	u.add(Not(cond))
+	return u
+}
+
+// Exec returns the number of rows it changed; rows that already hold the values are not
+// written, run no hooks and are not counted. Without Where every row of the table is updated.
+func (u *UpdateQuery) Exec() (int, error) {
+	return u.ExecContext(context.Background())
+}
+
+func (u *UpdateQuery) ExecContext(ctx context.Context) (int, error) {
+	if u.err != nil {
+		return 0, u.err
+	}
+
+	if u.table == "" {
+		return 0, errors_consts.ErrEmptyName
+	}
+	if len(u.values) == 0 {
+		return 0, errors_consts.ErrEmptyValues
+	}
+
+	for k, v := range u.values {
+		if k == "id" {
+			return 0, errors_consts.ErrUpdateID
 		}
+		if v != nil && !isAllowedValue(v) {
+			return 0, fmt.Errorf("unsupported value type for field %s", k)
+		}
+	}
+
+	prefix := u.table + ":"
+	raw, _, err := u.db.fetchRows(ctx, u.table, &u.filter)
+	if err != nil {
+		return 0, err
+	}
+
+	hooks := u.db.newHookRun(ctx)
+	watched := hooks.watches(u.table, BeforeUpdate)
 
-		if d.where.match(row) {
-			if err := d.db.Database.Delete(key); err != nil {
+	// one transaction for the statement: a failing row (unique key, schema, hook) leaves
+	// every row as it was
+	updated := 0
+	err = u.db.Database.Atomic(func(tx *Tx) error {
+		for key := range raw {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			// the row is checked again inside the transaction in case it changed since the scan
+			data, ok := tx.Get(key)
+			if !ok {
+				continue
+			}
+
+			row, err := decodeRow(data)
+			if err != nil {
 				return err
 			}
+			if !u.matches(row) {
+				continue
+			}
+
+			var old map[string]any
+			if watched {
+				old = maps.Clone(row)
+			}
+
+			for k, v := range u.values {
+				row[k] = v
+			}
+
+			// a row that already holds the new values is left alone and not counted
+			if enc, err := json.Marshal(tagValue(row)); err == nil && bytes.Equal(enc, data) {
+				continue
+			}
+
+			id := strings.TrimPrefix(key, prefix)
+			n, _ := strconv.ParseInt(id, 10, 64)
+			if err := hooks.before(tx, u.table, BeforeUpdate, n, row, old); err != nil {
+				return err
+			}
+
+			if err := writeRow(tx, u.table, id, row); err != nil {
+				return err
+			}
+			updated++
 		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
 	}
-	return nil
+
+	return updated, hooks.after()
 }
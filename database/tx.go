@@ -0,0 +1,145 @@
+package database
+
+import (
+	"sort"
+	"strings"
+)
+
+/*
+   Atomic multi-key writes
+
+   Atomic runs fn under the write lock. Reads through the Tx see the committed data plus the
+   transaction's own pending writes. When fn returns nil all writes are appended to the WAL
+   as a single batch record, so after a crash either all of them are replayed or none.
+   When fn returns an error nothing is written.
+
+   fn must not call methods of the Database itself (the lock is already held) and the Tx must
+   not be used after fn returns.
+*/
+
+type Tx struct {
+	db      *Database
+	pending map[string]*Record
+	order   []string
+}
+
+func (db *Database) Atomic(fn func(tx *Tx) error) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	tx := &Tx{
+		db:      db,
+		pending: make(map[string]*Record),
+	}
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+
+	switch len(tx.order) {
+	case 0:
+		return nil
+	case 1:
+		return applyHelper(db, tx.pending[tx.order[0]])
+	}
+
+	recs := make([]*Record, 0, len(tx.order))
+	for _, key := range tx.order {
+		recs = append(recs, tx.pending[key])
+	}
+
+	batch, err := encodeBatch(recs)
+	if err != nil {
+		return err
+	}
+	return applyHelper(db, batch)
+}
+
+func (tx *Tx) record(rec *Record) {
+	key := string(rec.Key)
+	if _, ok := tx.pending[key]; !ok {
+		tx.order = append(tx.order, key)
+	}
+	tx.pending[key] = rec
+}
+
+func (tx *Tx) Get(key string) ([]byte, bool) {
+	var val []byte
+
+	if rec, ok := tx.pending[key]; ok {
+		if rec.Op == 'D' {
+			return nil, false
+		}
+		val = rec.Value
+	} else {
+		v, ok := tx.db.mem.data[key]
+		if !ok {
+			return nil, false
+		}
+		val = v
+	}
+
+	out := make([]byte, len(val))
+	copy(out, val)
+	return out, true
+}
+
+func (tx *Tx) Set(key string, val []byte) {
+	v := make([]byte, len(val))
+	copy(v, val)
+
+	tx.record(&Record{
+		Op:    'S',
+		Key:   []byte(key),
+		Value: v,
+	})
+}
+
+func (tx *Tx) Delete(key string) {
+	tx.record(&Record{
+		Op:  'D',
+		Key: []byte(key),
+	})
+}
+
+// ScanKeys returns the keys in [start, end) in ascending order, pending writes included.
+func (tx *Tx) ScanKeys(start, end string) []string {
+	var keys []string
+
+	tx.db.mem.keys.ascend(start, end, func(key string) bool {
+		if rec, ok := tx.pending[key]; !ok || rec.Op != 'D' {
+			keys = append(keys, key)
+		}
+		return true
+	})
+
+	added := false
+	for key, rec := range tx.pending {
+		if rec.Op != 'S' || key < start || (end != "" && key >= end) {
+			continue
+		}
+		if _, ok := tx.db.mem.data[key]; !ok {
+			keys = append(keys, key)
+			added = true
+		}
+	}
+
+	if added {
+		sort.Strings(keys)
+	}
+	return keys
+}
+
+func (tx *Tx) ScanPrefix(prefix string) map[string][]byte {
+	res := make(map[string][]byte)
+
+	for _, key := range tx.ScanKeys(prefix, prefixEnd(prefix)) {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		if val, ok := tx.Get(key); ok {
+			res[key] = val
+		}
+	}
+	return res
+}
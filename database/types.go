@@ -0,0 +1,277 @@
+package database
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"golangdb/errors_consts"
+	"reflect"
+	"time"
+)
+
+/*
+   Typed values
+
+   Besides strings, numbers and bools, rows hold three typed scalars:
+     time.Time  timestamps, compared by instant whatever their time zone
+     []byte     binary data, compared byte by byte
+     UUID       compared by their 16 bytes
+   JSON has no such types, so stored rows tag them with a one-key object:
+     {"$time": "2024-05-01T12:00:00.5+02:00"}   RFC 3339 with nanoseconds, zone kept
+     {"$bytes": "aGVsbG8="}                      standard base64
+     {"$uuid": "9b2c3f4e-..."}
+   and reading a row turns the tags back into the Go values. Callers without Go types
+   (HTTP bodies, SQL parameters) write the same tagged objects as values and condition
+   values; responses encode the values as plain JSON strings (RFC 3339, base64, UUID).
+
+   An object with exactly one key that is a tag is always read as a typed value, so a
+   malformed one ({"$time": "yesterday"}) is an error rather than a plain object.
+*/
+
+const (
+	tagTime  = "$time"
+	tagBytes = "$bytes"
+	tagUUID  = "$uuid"
+)
+
+// UUID is a 16-byte universally unique identifier.
+type UUID [16]byte
+
+// NewUUID returns a random (version 4) UUID.
+func NewUUID() UUID {
+	var u UUID
+	if _, err := rand.Read(u[:]); err != nil {
+		panic(err)
+	}
+	u[6] = u[6]&0x0f | 0x40
+	u[8] = u[8]&0x3f | 0x80
+	return u
+}
+
+// ParseUUID reads the canonical form "xxxxxxxx-xxxx-xxxx-xxxx-xxxxxxxxxxxx".
+func ParseUUID(s string) (UUID, error) {
+	var u UUID
+
+	if len(s) != 36 || s[8] != '-' || s[13] != '-' || s[18] != '-' || s[23] != '-' {
+		return u, fmt.Errorf("invalid uuid %q", s)
+	}
+
+	digits := s[0:8] + s[9:13] + s[14:18] + s[19:23] + s[24:]
+	if _, err := hex.Decode(u[:], []byte(digits)); err != nil {
+		return u, fmt.Errorf("invalid uuid %q", s)
+	}
+	return u, nil
+}
+
+func (u UUID) String() string {
+	h := hex.EncodeToString(u[:])
+	return h[0:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:]
+}
+
+func (u UUID) MarshalText() ([]byte, error) {
+	return []byte(u.String()), nil
+}
+
+func (u *UUID) UnmarshalText(text []byte) error {
+	parsed, err := ParseUUID(string(text))
+	if err != nil {
+		return err
+	}
+	*u = parsed
+	return nil
+}
+
+func isTypedValue(v any) bool {
+	switch v.(type) {
+	case time.Time, []byte, UUID:
+		return true
+	}
+	return false
+}
+
+/*
+   Stored form
+*/
+
+// tagValue returns v with every typed value replaced by its tagged object, ready for
+// json.Marshal. Values without typed values inside are returned as they are.
+func tagValue(v any) any {
+	switch t := v.(type) {
+	case time.Time:
+		return map[string]any{tagTime: t.Format(time.RFC3339Nano)}
+	case []byte:
+		return map[string]any{tagBytes: base64.StdEncoding.EncodeToString(t)}
+	case UUID:
+		return map[string]any{tagUUID: t.String()}
+	case map[string]any:
+		var out map[string]any
+		for k, item := range t {
+			tagged := tagValue(item)
+			if out == nil && !sameValue(tagged, item) {
+				out = make(map[string]any, len(t))
+				for k2, v2 := range t {
+					out[k2] = v2
+				}
+			}
+			if out != nil {
+				out[k] = tagged
+			}
+		}
+		if out == nil {
+			return t
+		}
+		return out
+	case []any:
+		var out []any
+		for i, item := range t {
+			tagged := tagValue(item)
+			if out == nil && !sameValue(tagged, item) {
+				out = append([]any(nil), t...)
+			}
+			if out != nil {
+				out[i] = tagged
+			}
+		}
+		if out == nil {
+			return t
+		}
+		return out
+	}
+
+	// typed slices and maps (e.g. []time.Time) go through their elements
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array:
+		list, _ := elements(v)
+		return tagValue(list)
+	case reflect.Map:
+		obj := make(map[string]any, rv.Len())
+		iter := rv.MapRange()
+		for iter.Next() {
+			obj[iter.Key().String()] = iter.Value().Interface()
+		}
+		return tagValue(obj)
+	}
+	return v
+}
+
+// sameValue reports whether tagValue left a value alone: only typed values, and the
+// containers holding them, come back as new maps
+func sameValue(tagged, v any) bool {
+	switch v.(type) {
+	case map[string]any, []any:
+		return reflect.ValueOf(tagged).Pointer() == reflect.ValueOf(v).Pointer()
+	}
+	return !isTypedValue(v) && reflect.TypeOf(tagged) == reflect.TypeOf(v)
+}
+
+// untagValue turns tagged objects inside v into typed values.
+func untagValue(v any) (any, error) {
+	switch t := v.(type) {
+	case map[string]any:
+		if len(t) == 1 {
+			for k, item := range t {
+				if typed, ok, err := untagObject(k, item); ok {
+					return typed, err
+				}
+			}
+		}
+
+		for k, item := range t {
+			typed, err := untagValue(item)
+			if err != nil {
+				return nil, err
+			}
+			t[k] = typed
+		}
+		return t, nil
+
+	case []any:
+		for i, item := range t {
+			typed, err := untagValue(item)
+			if err != nil {
+				return nil, err
+			}
+			t[i] = typed
+		}
+		return t, nil
+	}
+	return v, nil
+}
+
+// untagObject reads the object {tag: value}; ok is false when tag is not a type tag
+func untagObject(tag string, value any) (typed any, ok bool, err error) {
+	s, isString := value.(string)
+
+	switch tag {
+	case tagTime:
+		t, perr := time.Parse(time.RFC3339Nano, s)
+		if !isString || perr != nil {
+			return nil, true, fmt.Errorf("%w: %s needs an RFC 3339 timestamp, got %v", errors_consts.ErrInvalidValue, tag, value)
+		}
+		return t, true, nil
+
+	case tagBytes:
+		b, derr := base64.StdEncoding.DecodeString(s)
+		if !isString || derr != nil {
+			return nil, true, fmt.Errorf("%w: %s needs standard base64, got %v", errors_consts.ErrInvalidValue, tag, value)
+		}
+		return b, true, nil
+
+	case tagUUID:
+		u, uerr := ParseUUID(s)
+		if !isString || uerr != nil {
+			return nil, true, fmt.Errorf("%w: %s needs a UUID, got %v", errors_consts.ErrInvalidValue, tag, value)
+		}
+		return u, true, nil
+	}
+	return nil, false, nil
+}
+
+// untagRow replaces the tagged objects among the values of row.
+func untagRow(row map[string]any) error {
+	for k, v := range row {
+		typed, err := untagValue(v)
+		if err != nil {
+			return fmt.Errorf("field %s: %w", k, err)
+		}
+		row[k] = typed
+	}
+	return nil
+}
+
+/*
+   Comparison and index keys
+*/
+
+// compareTyped compares two typed values of the same kind; ok is false otherwise
+func compareTyped(a, b any) (c int, ok bool) {
+	switch av := a.(type) {
+	case time.Time:
+		bv, ok := b.(time.Time)
+		return av.Compare(bv), ok
+	case []byte:
+		bv, ok := b.([]byte)
+		return bytes.Compare(av, bv), ok
+	case UUID:
+		bv, ok := b.(UUID)
+		return bytes.Compare(av[:], bv[:]), ok
+	}
+	return 0, false
+}
+
+// typedKey encodes a typed value so that byte order is value order
+func typedKey(v any) (string, bool) {
+	switch t := v.(type) {
+	case time.Time:
+		sec := uint64(t.Unix()) ^ 1<<63
+		return fmt.Sprintf("%c%016x%08x", indexTagTime, sec, t.Nanosecond()), true
+	case []byte:
+		return string(indexTagBytes) + hex.EncodeToString(t), true
+	case UUID:
+		return string(indexTagUUID) + hex.EncodeToString(t[:]), true
+	}
+	return "", false
+}
@@ -0,0 +1,262 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"golangdb/errors_consts"
+	"maps"
+	"slices"
+	"strconv"
+)
+
+/*
+   Upserts and bulk inserts
+
+   db.Upsert().Table("users").Values(row).On("email").Exec()
+
+   Upsert writes a row unless one already holds the same conflict key, in which case
+   that row is updated instead: Merge (the default) sets the given fields and keeps the
+   others, Replace stores the given fields only. The conflict key is "id" unless On names
+   fields with a unique index, so the lookup is a single key either way. The row keeps
+   its id; a different "id" in the values is rejected.
+
+   InsertMany validates all rows, assigns the missing ids and writes every row in one
+   atomic batch: either all rows are stored or none.
+*/
+
+type UpsertQuery struct {
+	db      *DB
+	table   string
+	values  map[string]any
+	on      []string
+	replace bool
+}
+
+func (db *DB) Upsert() *UpsertQuery {
+	return &UpsertQuery{db: db, on: []string{"id"}}
+}
+
+func (u *UpsertQuery) Table(name string) *UpsertQuery {
+	u.table = name
+	return u
+}
+
+func (u *UpsertQuery) Values(values map[string]any) *UpsertQuery {
+	u.values = values
+	return u
+}
+
+// On sets the conflict key. Fields other than "id" need a unique index on exactly them.
+func (u *UpsertQuery) On(fields ...string) *UpsertQuery {
+	u.on = fields
+	return u
+}
+
+// Merge updates the given fields of an existing row and keeps the others (default).
+func (u *UpsertQuery) Merge() *UpsertQuery {
+	u.replace = false
+	return u
+}
+
+// Replace overwrites an existing row with the given fields.
+func (u *UpsertQuery) Replace() *UpsertQuery {
+	u.replace = true
+	return u
+}
+
+func (u *UpsertQuery) Exec() (int64, error) {
+	return u.ExecContext(context.Background())
+}
+
+// ExecContext writes the row and returns its id.
+func (u *UpsertQuery) ExecContext(ctx context.Context) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	if u.table == "" {
+		return 0, errors_consts.ErrEmptyName
+	}
+	if len(u.values) == 0 {
+		return 0, errors_consts.ErrEmptyValues
+	}
+	if len(u.on) == 0 {
+		return 0, fmt.Errorf("%w: upsert needs a conflict key", errors_consts.ErrInvalidQuery)
+	}
+
+	if err := checkValues(u.values); err != nil {
+		return 0, err
+	}
+
+	// the conflict key is read before writeRow would turn tagged values into typed ones
+	values := maps.Clone(u.values)
+	if err := untagRow(values); err != nil {
+		return 0, err
+	}
+
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	var id int64
+	hooks := u.db.newHookRun(ctx)
+
+	err := u.db.Database.Atomic(func(tx *Tx) error {
+		owner, err := u.conflict(tx, values)
+		if err != nil {
+			return err
+		}
+
+		if owner == "" {
+			id, err = insertRow(tx, u.table, values, hooks)
+			return err
+		}
+
+		raw, _ := tx.Get(rowKey(u.table, owner))
+		old, err := decodeRow(raw)
+		if err != nil {
+			return err
+		}
+
+		if id, err = strconv.ParseInt(owner, 10, 64); err != nil {
+			return err
+		}
+
+		if raw, ok := values["id"]; ok {
+			if given, err := parseID(raw); err != nil || given != id {
+				return errors_consts.ErrUpdateID
+			}
+		}
+
+		row := values
+		if !u.replace {
+			row = maps.Clone(old)
+			maps.Copy(row, values)
+		}
+		row["id"] = old["id"]
+
+		if err := hooks.before(tx, u.table, BeforeUpdate, id, row, old); err != nil {
+			return err
+		}
+		return writeRow(tx, u.table, owner, row)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return id, hooks.after()
+}
+
+// conflict returns the id of the row holding the conflict key of values, or "".
+func (u *UpsertQuery) conflict(tx *Tx, values map[string]any) (string, error) {
+	if len(u.on) == 1 && u.on[0] == "id" {
+		raw, ok := values["id"]
+		if !ok {
+			return "", nil
+		}
+
+		id, err := parseID(raw)
+		if err != nil {
+			return "", err
+		}
+
+		if _, ok := tx.Get(rowKey(u.table, fmt.Sprint(id))); !ok {
+			return "", nil
+		}
+		return fmt.Sprint(id), nil
+	}
+
+	defs, err := loadIndexes(tx.Get, u.table)
+	if err != nil {
+		return "", err
+	}
+
+	for _, def := range defs {
+		if !def.Unique || !slices.Equal(def.Fields, u.on) {
+			continue
+		}
+
+		key, ok := uniqueKey(u.table, def, values)
+		if !ok {
+			return "", fmt.Errorf("%w: upsert needs a value for every conflict field %v", errors_consts.ErrInvalidQuery, u.on)
+		}
+
+		owner, _ := tx.Get(key)
+		return string(owner), nil
+	}
+	return "", fmt.Errorf("%w: no unique index on %v of %s", errors_consts.ErrIndexNotFound, u.on, u.table)
+}
+
+// InsertMany inserts rows into table in one atomic batch and returns their ids in the
+// order of rows. Rows without an "id" get the next ids of the table, skipping the
+// explicit ids of the batch. If any row is invalid, already exists or breaks a constraint,
+// nothing is written and the error names the row. The given maps are not modified.
+func (db *DB) InsertMany(table string, rows []map[string]any) ([]int64, error) {
+	return db.InsertManyContext(context.Background(), table, rows)
+}
+
+func (db *DB) InsertManyContext(ctx context.Context, table string, rows []map[string]any) ([]int64, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if table == "" {
+		return nil, errors_consts.ErrEmptyName
+	}
+	if len(rows) == 0 {
+		return nil, errors_consts.ErrEmptyValues
+	}
+
+	// explicit ids of the batch, which generated ids skip
+	taken := make(map[int64]bool)
+	for i, row := range rows {
+		if len(row) == 0 {
+			return nil, fmt.Errorf("row %d: %w", i, errors_consts.ErrEmptyValues)
+		}
+		if err := checkValues(row); err != nil {
+			return nil, fmt.Errorf("row %d: %w", i, err)
+		}
+
+		if raw, ok := row["id"]; ok {
+			id, err := parseID(raw)
+			if err != nil {
+				return nil, fmt.Errorf("row %d: %w", i, err)
+			}
+			taken[id] = true
+		}
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	ids := make([]int64, len(rows))
+	hooks := db.newHookRun(ctx)
+
+	err := db.Database.Atomic(func(tx *Tx) error {
+		for i, row := range rows {
+			row = maps.Clone(row)
+			if _, ok := row["id"]; !ok {
+				id, err := reserveIDs(tx, table, 1)
+				for err == nil && taken[id] {
+					id, err = reserveIDs(tx, table, 1)
+				}
+				if err != nil {
+					return err
+				}
+				row["id"] = id
+			}
+
+			var err error
+			if ids[i], err = insertRow(tx, table, row, hooks); err != nil {
+				return fmt.Errorf("row %d: %w", i, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return ids, hooks.after()
+}
@@ -1,8 +1,12 @@
 package main_test
 
 import (
+	"context"
+	"errors"
 	"golangdb/database"
+	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -122,3 +126,148 @@ func TestScanPrefix(t *testing.T) {
 		t.Fatalf("expected 2 results, got %d", len(res))
 	}
 }
+
+func TestIncrement(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "db.data")
+	walPath := filepath.Join(dir, "db.wal")
+
+	db, err := database.OpenDB(dbPath, walPath, database.WalSizeLimit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	for want := int64(5); want <= 15; want += 5 {
+		got, err := db.Increment("counter", 5)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Fatalf("expected %d, got %d", want, got)
+		}
+	}
+
+	db.Set("text", []byte(`"abc"`))
+	if _, err := db.Increment("text", 1); err == nil {
+		t.Fatalf("expected error incrementing a non-integer value")
+	}
+}
+
+func TestOpenWithOptions(t *testing.T) {
+	dir := t.TempDir()
+
+	opts := database.Options{
+		DbPath:        filepath.Join(dir, "data", "db.data"),
+		WalPath:       filepath.Join(dir, "wal", "db.wal"),
+		SnapshotEvery: 3,
+		SyncMode:      database.SyncNone,
+	}
+
+	db, err := database.Open(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, k := range []string{"a", "b", "c", "d"} {
+		if err := db.Set(k, []byte(k)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	db.Close()
+
+	// three records triggered a snapshot, only "d" is left in the WAL
+	info, err := os.Stat(opts.WalPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() == 0 || info.Size() > 32 {
+		t.Fatalf("unexpected wal size %d", info.Size())
+	}
+
+	db, err = database.Open(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if len(db.ScanPrefix("")) != 4 {
+		t.Fatalf("expected 4 keys after reopen")
+	}
+
+	if _, err := database.Open(database.Options{DbPath: "same", WalPath: "same"}); err == nil {
+		t.Fatalf("expected error for identical paths")
+	}
+}
+
+func TestAtomicBatchRecovery(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "db.data")
+	walPath := filepath.Join(dir, "db.wal")
+
+	db, err := database.OpenDB(dbPath, walPath, database.WalSizeLimit)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db.Set("c", []byte("old"))
+
+	err = db.Atomic(func(tx *database.Tx) error {
+		tx.Set("a", []byte("1"))
+		tx.Set("b", []byte("2"))
+		tx.Delete("c")
+		if v, ok := tx.Get("a"); !ok || string(v) != "1" {
+			t.Fatalf("tx must see its own writes")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = db.Atomic(func(tx *database.Tx) error {
+		tx.Set("d", []byte("4"))
+		return errors.New("rollback")
+	})
+	if err == nil {
+		t.Fatalf("expected the error from fn")
+	}
+	db.Close()
+
+	db, err = database.OpenDB(dbPath, walPath, database.WalSizeLimit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	keys, err := db.ScanKeys(context.Background(), "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(keys, ",") != "a,b" {
+		t.Fatalf("unexpected keys after replay: %v", keys)
+	}
+}
+
+func TestScanKeysOrdered(t *testing.T) {
+	dir := t.TempDir()
+
+	db, err := database.OpenDB(filepath.Join(dir, "db.data"), filepath.Join(dir, "db.wal"), database.WalSizeLimit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	for _, k := range []string{"b:2", "a:1", "b:1", "c:1", "b:3"} {
+		db.Set(k, []byte("x"))
+	}
+	db.Delete("b:2")
+
+	keys, _ := db.ScanKeys(context.Background(), "b:", "c")
+	if strings.Join(keys, ",") != "b:1,b:3" {
+		t.Fatalf("unexpected range %v", keys)
+	}
+	if len(db.ScanPrefix("b:")) != 2 {
+		t.Fatalf("unexpected prefix scan")
+	}
+}
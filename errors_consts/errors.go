@@ -3,6 +3,32 @@ package errors_consts
 import "errors"
 
 var (
-	ErrEmptyName   = errors.New("table name is not set")
-	ErrEmptyValues = errors.New("values are empty, they cannot be empty")
+	ErrEmptyName    = errors.New("table name is not set")
+	ErrEmptyValues  = errors.New("values are empty, they cannot be empty")
+	ErrUpdateID     = errors.New("id cannot be changed by an update")
+	ErrInvalidWhere = errors.New("invalid where clause")
+	ErrInvalidQuery = errors.New("invalid query")
+	ErrRowExists    = errors.New("row already exists")
+	ErrTypeMismatch = errors.New("value does not match the Go type")
+	ErrInvalidValue = errors.New("invalid typed value")
+
+	ErrInvalidStatement = errors.New("invalid statement")
+
+	ErrIndexExists   = errors.New("index already exists")
+	ErrIndexNotFound = errors.New("index does not exist")
+
+	ErrUniqueViolation     = errors.New("unique constraint violation")
+	ErrForeignKeyViolation = errors.New("foreign key constraint violation")
+
+	ErrTableExists     = errors.New("table already exists")
+	ErrTableNotFound   = errors.New("table does not exist")
+	ErrInvalidSchema   = errors.New("invalid schema definition")
+	ErrSchemaViolation = errors.New("row does not match the table schema")
+
+	ErrSequenceNotFound = errors.New("sequence does not exist")
+	ErrSequenceExists   = errors.New("sequence already exists")
+	ErrInvalidSequence  = errors.New("invalid sequence definition")
+
+	ErrMigrationUnknown      = errors.New("applied migration is not registered")
+	ErrMigrationIrreversible = errors.New("migration has no down step")
 )
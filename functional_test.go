@@ -1,11 +1,18 @@
 package main_test
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"golangdb/database"
+	"golangdb/errors_consts"
 	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestInsertExec(t *testing.T) {
@@ -233,3 +240,1867 @@ func TestInsertAutoIncrement(t *testing.T) {
 	}
 
 }
+
+func TestSequenceConcurrentNextVal(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "db.data")
+	walPath := filepath.Join(dir, "db.wal")
+
+	storage, err := database.OpenDB(dbPath, walPath, database.WalSizeLimit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer storage.Close()
+
+	db := database.NewDB(storage)
+
+	if err := db.CreateSequence("orders", database.SequenceOptions{Start: 100, Step: 2, Cache: 10}); err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		mu   sync.Mutex
+		wg   sync.WaitGroup
+		seen = make(map[int64]bool)
+	)
+
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 25; j++ {
+				v, err := db.NextVal("orders")
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				mu.Lock()
+				if seen[v] || v < 100 || v%2 != 0 {
+					t.Errorf("unexpected value %d", v)
+				}
+				seen[v] = true
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if len(seen) != 200 {
+		t.Fatalf("expected 200 unique values, got %d", len(seen))
+	}
+
+	if err := db.ResetSequence("orders", 7); err != nil {
+		t.Fatal(err)
+	}
+
+	v, err := db.NextVal("orders")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v != 7 {
+		t.Fatalf("expected 7 after reset, got %d", v)
+	}
+}
+
+func TestInsertAutoIncrementConcurrent(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "db.data")
+	walPath := filepath.Join(dir, "db.wal")
+
+	storage, err := database.OpenDB(dbPath, walPath, database.WalSizeLimit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer storage.Close()
+
+	db := database.NewDB(storage)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := db.Insert().Table("users").Values(map[string]any{"name": "x"}).Exec(); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	rows, err := db.Select().Table("users").All()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 50 {
+		t.Fatalf("expected 50 rows, got %d", len(rows))
+	}
+}
+
+func TestSelectAllContextCancelled(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "db.data")
+	walPath := filepath.Join(dir, "db.wal")
+
+	storage, err := database.OpenDB(dbPath, walPath, database.WalSizeLimit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer storage.Close()
+
+	db := database.NewDB(storage)
+
+	for i := 0; i < 10; i++ {
+		db.Insert().Table("users").Values(map[string]any{"age": i}).Exec()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := db.Select().Table("users").AllContext(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+
+	if err := db.Delete().Table("users").ExecContext(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+
+	rows, err := db.Select().Table("users").AllContext(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 10 {
+		t.Fatalf("cancelled delete must not remove rows, got %d left", len(rows))
+	}
+}
+
+func TestUpdateWhere(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "db.data")
+	walPath := filepath.Join(dir, "db.wal")
+
+	storage, err := database.OpenDB(dbPath, walPath, database.WalSizeLimit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer storage.Close()
+
+	db := database.NewDB(storage)
+
+	db.Insert().Table("users").Values(map[string]any{"name": "Alice", "age": 20}).Exec()
+	db.Insert().Table("users").Values(map[string]any{"name": "Bob", "age": 15}).Exec()
+	db.Insert().Table("users").Values(map[string]any{"name": "Carl", "age": 30}).Exec()
+
+	n, err := db.Update().
+		Table("users").
+		Set(map[string]any{"adult": true}).
+		Where("age", ">", 18).
+		Exec()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Fatalf("expected 2 updated rows, got %d", n)
+	}
+
+	rows, _ := db.Select().Table("users").Where("adult", "=", true).All()
+	if len(rows) != 2 {
+		t.Fatalf("expected 2 adults, got %d", len(rows))
+	}
+	for _, row := range rows {
+		if row["name"] == nil || row["age"] == nil {
+			t.Fatalf("update must keep untouched fields, got %v", row)
+		}
+	}
+
+	if _, err := db.Update().Table("users").Set(map[string]any{"id": 5}).Exec(); err == nil {
+		t.Fatalf("expected error when updating id")
+	}
+	if _, err := db.Update().Table("users").Set(map[string]any{"tags": struct{}{}}).Exec(); err == nil {
+		t.Fatalf("expected error for unsupported value type")
+	}
+}
+
+func TestSelectCompoundWhere(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "db.data")
+	walPath := filepath.Join(dir, "db.wal")
+
+	storage, err := database.OpenDB(dbPath, walPath, database.WalSizeLimit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer storage.Close()
+
+	db := database.NewDB(storage)
+
+	db.Insert().Table("users").Values(map[string]any{"name": "Alice", "age": 20, "city": "Oslo"}).Exec()
+	db.Insert().Table("users").Values(map[string]any{"name": "Bob", "age": 15, "city": "Oslo"}).Exec()
+	db.Insert().Table("users").Values(map[string]any{"name": "Carl", "age": 30, "city": "Rome"}).Exec()
+	db.Insert().Table("users").Values(map[string]any{"name": "Dana", "age": 40, "city": "Kyiv"}).Exec()
+
+	// chained Where calls are combined with AND
+	rows, err := db.Select().Table("users").Where("age", ">", 18).Where("city", "=", "Oslo").All()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 1 || rows[0]["name"] != "Alice" {
+		t.Fatalf("expected only Alice, got %v", rows)
+	}
+
+	// age > 18 AND (city = Oslo OR NOT (city = Kyiv))
+	rows, err = db.Select().
+		Table("users").
+		Where("age", ">", 18).
+		WhereCond(database.Or(
+			database.Cond("city", "=", "Oslo"),
+			database.Not(database.Cond("city", "=", "Kyiv")),
+		)).
+		All()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 {
+		t.Fatalf("expected Alice and Carl, got %v", rows)
+	}
+
+	err = db.Delete().Table("users").Or(database.Cond("name", "=", "Bob"), database.Cond("name", "=", "Dana")).Exec()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows, _ = db.Select().Table("users").All()
+	if len(rows) != 2 {
+		t.Fatalf("expected 2 rows after delete, got %d", len(rows))
+	}
+
+	_, err = db.Select().Table("users").WhereCond(database.And(database.Cond("age", "~", 1))).All()
+	if !errors.Is(err, errors_consts.ErrInvalidWhere) {
+		t.Fatalf("expected ErrInvalidWhere, got %v", err)
+	}
+}
+
+func TestSelectOperators(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "db.data")
+	walPath := filepath.Join(dir, "db.wal")
+
+	storage, err := database.OpenDB(dbPath, walPath, database.WalSizeLimit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer storage.Close()
+
+	db := database.NewDB(storage)
+
+	db.Insert().Table("users").Values(map[string]any{"name": "Alice", "age": 20, "email": "alice@mail.com"}).Exec()
+	db.Insert().Table("users").Values(map[string]any{"name": "Bob", "age": 15}).Exec()
+	db.Insert().Table("users").Values(map[string]any{"name": "alfred", "age": 30, "email": "alf@corp.org"}).Exec()
+
+	cases := []struct {
+		field, op string
+		value     any
+		want      int
+	}{
+		{"age", ">=", 20, 2},
+		{"age", "<=", 20, 2},
+		{"age", "IN", []any{15, 30}, 2},
+		{"age", "not in", []int{15, 30}, 1},
+		{"age", "BETWEEN", []any{15, 20}, 2},
+		{"name", "like", "Al%", 1},
+		{"name", "ilike", "al%", 2},
+		{"name", "like", "B_b", 1},
+		{"email", "starts_with", "alf", 1},
+		{"email", "contains", "@", 2},
+		{"email", "exists", nil, 2},
+		{"email", "is null", nil, 1},
+		{"email", "is not null", nil, 2},
+	}
+
+	for _, c := range cases {
+		rows, err := db.Select().Table("users").Where(c.field, c.op, c.value).All()
+		if err != nil {
+			t.Fatalf("%s %s %v: %v", c.field, c.op, c.value, err)
+		}
+		if len(rows) != c.want {
+			t.Fatalf("%s %s %v: expected %d rows, got %d", c.field, c.op, c.value, c.want, len(rows))
+		}
+	}
+
+	if _, err := db.Select().Table("users").Where("age", "between", []any{1}).All(); err == nil {
+		t.Fatalf("expected error for malformed between")
+	}
+}
+
+func TestSelectOrderLimitOffset(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "db.data")
+	walPath := filepath.Join(dir, "db.wal")
+
+	storage, err := database.OpenDB(dbPath, walPath, database.WalSizeLimit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer storage.Close()
+
+	db := database.NewDB(storage)
+
+	ages := []int{40, 15, 30, 15, 25, 50, 20}
+	for i, age := range ages {
+		db.Insert().Table("users").Values(map[string]any{"name": fmt.Sprint("u", i), "age": age}).Exec()
+	}
+
+	names := func(rows []map[string]any) []string {
+		out := make([]string, 0, len(rows))
+		for _, row := range rows {
+			out = append(out, row["name"].(string))
+		}
+		return out
+	}
+
+	rows, err := db.Select().Table("users").OrderBy("age", database.Asc).OrderBy("name", database.Desc).All()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(names(rows), ","); got != "u3,u1,u6,u4,u2,u0,u5" {
+		t.Fatalf("unexpected order %s", got)
+	}
+
+	rows, err = db.Select().Table("users").OrderBy("age", database.Desc).Offset(1).Limit(2).All()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(names(rows), ","); got != "u0,u2" {
+		t.Fatalf("unexpected page %s", got)
+	}
+
+	// without OrderBy rows come back by id
+	rows, _ = db.Select().Table("users").Limit(3).All()
+	if got := strings.Join(names(rows), ","); got != "u0,u1,u2" {
+		t.Fatalf("unexpected default order %s", got)
+	}
+}
+
+func TestSelectColumns(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "db.data")
+	walPath := filepath.Join(dir, "db.wal")
+
+	storage, err := database.OpenDB(dbPath, walPath, database.WalSizeLimit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer storage.Close()
+
+	db := database.NewDB(storage)
+
+	db.Insert().Table("users").Values(map[string]any{"email": "a@b.c", "password": "hash", "bio": "long text"}).Exec()
+
+	rows, err := db.Select().Table("users").Columns("id", "email AS mail").All()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows[0]) != 2 || rows[0]["mail"] != "a@b.c" || rows[0]["id"] == nil {
+		t.Fatalf("unexpected projection %v", rows[0])
+	}
+
+	rows, err = db.Select().Table("users").Exclude("password", "bio").All()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := rows[0]["password"]; ok || len(rows[0]) != 2 {
+		t.Fatalf("unexpected projection %v", rows[0])
+	}
+
+	if _, err := db.Select().Table("users").Columns("email as").All(); err == nil {
+		t.Fatalf("expected error for malformed column")
+	}
+}
+
+func TestSelectAggregates(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "db.data")
+	walPath := filepath.Join(dir, "db.wal")
+
+	storage, err := database.OpenDB(dbPath, walPath, database.WalSizeLimit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer storage.Close()
+
+	db := database.NewDB(storage)
+
+	orders := []map[string]any{
+		{"status": "paid", "amount": 10, "customer": "a"},
+		{"status": "paid", "amount": 30, "customer": "b"},
+		{"status": "paid", "amount": 20, "customer": "a"},
+		{"status": "new", "amount": 5, "customer": "c"},
+		{"status": "cancelled", "customer": "c"},
+	}
+	for _, o := range orders {
+		db.Insert().Table("orders").Values(o).Exec()
+	}
+
+	rows, err := db.Select().Table("orders").Count().Sum("amount").Max("amount").All()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 1 || rows[0]["count"] != int64(5) || rows[0]["sum_amount"] != int64(65) {
+		t.Fatalf("unexpected totals %v", rows)
+	}
+
+	rows, err = db.Select().
+		Table("orders").
+		GroupBy("status").
+		Count().
+		Avg("amount").
+		CountDistinct("customer").
+		Having("count", ">", 1).
+		All()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 1 {
+		t.Fatalf("expected only the paid group, got %v", rows)
+	}
+	paid := rows[0]
+	if paid["status"] != "paid" || paid["count"] != int64(3) || paid["avg_amount"] != float64(20) || paid["count_distinct_customer"] != int64(2) {
+		t.Fatalf("unexpected paid group %v", paid)
+	}
+
+	rows, err = db.Select().Table("orders").GroupBy("status").Aggregate("count", "", "n").OrderBy("n", database.Desc).OrderBy("status", database.Asc).All()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 3 || rows[0]["status"] != "paid" || rows[1]["status"] != "cancelled" {
+		t.Fatalf("unexpected group order %v", rows)
+	}
+
+	rows, _ = db.Select().Table("orders").Where("status", "=", "missing").Count().All()
+	if len(rows) != 1 || rows[0]["count"] != int64(0) {
+		t.Fatalf("count over no rows should be 0, got %v", rows)
+	}
+
+	// rows stream in pages: a table spanning several of them, read by a scan and through
+	// overlapping index ranges, is aggregated once per row
+	batch := make([]map[string]any, 2500)
+	for i := range batch {
+		batch[i] = map[string]any{"k": i % 3, "v": 1}
+	}
+	if _, err := db.InsertMany("big", batch); err != nil {
+		t.Fatal(err)
+	}
+	rows, err = db.Select().Table("big").GroupBy("k").Sum("v").OrderBy("k", database.Asc).All()
+	if err != nil || fmt.Sprint(rows) != "[map[k:0 sum_v:834] map[k:1 sum_v:833] map[k:2 sum_v:833]]" {
+		t.Fatalf("unexpected groups over a scan %v %v", rows, err)
+	}
+
+	db.CreateIndex("big", "k")
+	query := db.Select().Table("big").Where("k", "in", []any{1, 1}).Count()
+	plan, err := query.Explain()
+	if err != nil || plan.Access != database.AccessIndexLookup {
+		t.Fatalf("expected an index lookup, got %+v %v", plan, err)
+	}
+	rows, err = query.All()
+	if err != nil || len(rows) != 1 || rows[0]["count"] != int64(833) {
+		t.Fatalf("unexpected count through the index %v %v", rows, err)
+	}
+}
+
+func TestSecondaryIndex(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "db.data")
+	walPath := filepath.Join(dir, "db.wal")
+
+	storage, err := database.OpenDB(dbPath, walPath, database.WalSizeLimit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer storage.Close()
+
+	db := database.NewDB(storage)
+
+	for i := 0; i < 20; i++ {
+		db.Insert().Table("users").Values(map[string]any{"age": i % 10, "name": fmt.Sprint("n", i)}).Exec()
+	}
+	db.Insert().Table("users").Values(map[string]any{"age": "unknown"}).Exec()
+	// its index entries sort among those of "unknown"
+	db.Insert().Table("users").Values(map[string]any{"age": "unknown\x00x"}).Exec()
+
+	queries := []struct {
+		op    string
+		value any
+	}{
+		{"=", 3}, {">", 7}, {">=", 7}, {"<", 2}, {"<=", 2}, {"between", []any{4, 6}}, {"in", []any{1, 9}}, {">", "a"},
+		{">", "unknown"}, {"=", "unknown"}, {"<=", "unknown"},
+	}
+
+	count := func(op string, value any) int {
+		rows, err := db.Select().Table("users").Where("age", op, value).All()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(rows)
+	}
+
+	expected := make([]int, len(queries))
+	for i, q := range queries {
+		expected[i] = count(q.op, q.value)
+	}
+
+	// backfills the 22 rows above
+	if err := db.CreateIndex("users", "age"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.CreateIndex("users", "age"); !errors.Is(err, errors_consts.ErrIndexExists) {
+		t.Fatalf("expected ErrIndexExists, got %v", err)
+	}
+
+	for i, q := range queries {
+		if got := count(q.op, q.value); got != expected[i] {
+			t.Fatalf("age %s %v: index returned %d rows, scan returned %d", q.op, q.value, got, expected[i])
+		}
+	}
+
+	// index entries follow updates and deletes
+	db.Update().Table("users").Set(map[string]any{"age": 100}).Where("age", "=", 3).Exec()
+	if count("=", 3) != 0 || count("=", 100) != 2 {
+		t.Fatalf("index not updated")
+	}
+	db.Delete().Table("users").Where("age", "=", 100).Exec()
+	if count(">=", 100) != 0 {
+		t.Fatalf("index not cleaned on delete")
+	}
+
+	if n := len(storage.ScanPrefix("__idx__:users:age:")); n != 20 {
+		t.Fatalf("expected 20 index entries, got %d", n)
+	}
+
+	indexes, _ := db.ListIndexes("")
+	if len(indexes) != 1 || indexes[0].Name() != "age" {
+		t.Fatalf("unexpected indexes %v", indexes)
+	}
+
+	if err := db.DropIndex("users", "age"); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(storage.ScanPrefix("__idx__:")); n != 0 {
+		t.Fatalf("expected index entries to be dropped, %d left", n)
+	}
+}
+
+func TestUniqueIndex(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "db.data")
+	walPath := filepath.Join(dir, "db.wal")
+
+	storage, err := database.OpenDB(dbPath, walPath, database.WalSizeLimit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer storage.Close()
+
+	db := database.NewDB(storage)
+
+	db.Insert().Table("dups").Values(map[string]any{"email": "a@x"}).Exec()
+	db.Insert().Table("dups").Values(map[string]any{"email": "a@x"}).Exec()
+	if err := db.CreateUniqueIndex("dups", "email"); !errors.Is(err, errors_consts.ErrUniqueViolation) {
+		t.Fatalf("expected ErrUniqueViolation on backfill, got %v", err)
+	}
+
+	if err := db.CreateUniqueIndex("users", "email"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.CreateUniqueIndex("users", "team", "number"); err != nil {
+		t.Fatal(err)
+	}
+
+	// concurrent inserts of one email: exactly one wins
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- db.Insert().Table("users").Values(map[string]any{"email": "same@x"}).Exec()
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	ok := 0
+	for err := range errs {
+		var violation *database.UniqueViolationError
+		switch {
+		case err == nil:
+			ok++
+		case errors.As(err, &violation):
+			if violation.Table != "users" || violation.Fields[0] != "email" {
+				t.Fatalf("unexpected violation %+v", violation)
+			}
+		default:
+			t.Fatal(err)
+		}
+	}
+	if ok != 1 {
+		t.Fatalf("expected one successful insert, got %d", ok)
+	}
+
+	// compound keys: only the full tuple has to be unique, rows missing a field are not constrained
+	for _, values := range []map[string]any{
+		{"team": "red", "number": 1},
+		{"team": "blue", "number": 1},
+		{"team": "red"},
+		{"team": "red"},
+	} {
+		if err := db.Insert().Table("users").Values(values).Exec(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = db.Insert().Table("users").Values(map[string]any{"team": "red", "number": 1.0}).Exec()
+	if !errors.Is(err, errors_consts.ErrUniqueViolation) {
+		t.Fatalf("expected ErrUniqueViolation, got %v", err)
+	}
+
+	// updates are checked as well and a failed update changes nothing
+	_, err = db.Update().Table("users").Set(map[string]any{"number": 1}).Where("team", "=", "blue").Exec()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Update().Table("users").Set(map[string]any{"team": "red"}).Where("team", "=", "blue").Exec()
+	if !errors.Is(err, errors_consts.ErrUniqueViolation) {
+		t.Fatalf("expected ErrUniqueViolation on update, got %v", err)
+	}
+	rows, _ := db.Select().Table("users").Where("team", "=", "blue").All()
+	if len(rows) != 1 {
+		t.Fatalf("failed update modified rows: %v", rows)
+	}
+
+	// the statement is one transaction: the first row's change is undone when the second collides
+	db.InsertMany("users", []map[string]any{{"email": "p1@x", "group": "g"}, {"email": "p2@x", "group": "g"}})
+	_, err = db.Update().Table("users").Set(map[string]any{"email": "z@x"}).Where("group", "=", "g").Exec()
+	if !errors.Is(err, errors_consts.ErrUniqueViolation) {
+		t.Fatalf("expected ErrUniqueViolation on a multi-row update, got %v", err)
+	}
+	if rows, _ := db.Select().Table("users").Where("email", "=", "z@x").All(); len(rows) != 0 {
+		t.Fatalf("failed multi-row update left rows changed: %v", rows)
+	}
+
+	// deleting the owner frees the value
+	if err := db.Delete().Table("users").Where("email", "=", "same@x").Exec(); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Insert().Table("users").Values(map[string]any{"email": "same@x"}).Exec(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.DropIndex("users", "team", "number"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Insert().Table("users").Values(map[string]any{"team": "red", "number": 1}).Exec(); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(storage.ScanPrefix("__uniq__:users:team,number:")); n != 0 {
+		t.Fatalf("expected unique keys to be dropped, %d left", n)
+	}
+}
+
+func TestTableSchema(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "db.data")
+	walPath := filepath.Join(dir, "db.wal")
+
+	storage, err := database.OpenDB(dbPath, walPath, database.WalSizeLimit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer storage.Close()
+
+	db := database.NewDB(storage)
+
+	// rows written before the schema are filled with defaults
+	db.Insert().Table("users").Values(map[string]any{"email": "old@x"}).Exec()
+
+	err = db.CreateTable("users", database.Schema{
+		Strict: true,
+		Columns: []database.Column{
+			{Name: "email", Type: database.TypeString, Required: true},
+			{Name: "age", Type: database.TypeInt, Nullable: true},
+			{Name: "active", Type: database.TypeBool, Default: true},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.CreateTable("users", database.Schema{}); !errors.Is(err, errors_consts.ErrTableExists) {
+		t.Fatalf("expected ErrTableExists, got %v", err)
+	}
+	if err := db.CreateTable("bad", database.Schema{Columns: []database.Column{{Name: "x", Type: "date"}}}); !errors.Is(err, errors_consts.ErrInvalidSchema) {
+		t.Fatalf("expected ErrInvalidSchema, got %v", err)
+	}
+
+	bad := []map[string]any{
+		{"age": 3},                        // required column missing
+		{"email": "a@x", "emial": "typo"}, // unknown field in strict mode
+		{"email": "a@x", "age": 1.5},      // not an int
+		{"email": "a@x", "active": nil},   // not nullable
+		{"email": 42},                     // wrong type
+	}
+	for _, values := range bad {
+		if err := db.Insert().Table("users").Values(values).Exec(); !errors.Is(err, errors_consts.ErrSchemaViolation) {
+			t.Fatalf("insert %v: expected ErrSchemaViolation, got %v", values, err)
+		}
+	}
+
+	if err := db.Insert().Table("users").Values(map[string]any{"email": "new@x", "age": nil}).Exec(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Update().Table("users").Set(map[string]any{"age": "ten"}).Exec(); !errors.Is(err, errors_consts.ErrSchemaViolation) {
+		t.Fatalf("update: expected ErrSchemaViolation, got %v", err)
+	}
+
+	rows, _ := db.Select().Table("users").Where("active", "=", true).All()
+	if len(rows) != 2 {
+		t.Fatalf("expected defaults on both rows, got %v", rows)
+	}
+
+	// a required column without default cannot be added while rows lack it
+	if err := db.AlterTable("users").AddColumn(database.Column{Name: "name", Type: database.TypeString, Required: true}).Exec(); !errors.Is(err, errors_consts.ErrSchemaViolation) {
+		t.Fatalf("expected ErrSchemaViolation, got %v", err)
+	}
+
+	err = db.AlterTable("users").
+		AddColumn(database.Column{Name: "plan", Type: database.TypeString, Default: "free"}).
+		DropColumn("age").
+		Exec()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rows, _ = db.Select().Table("users").Where("plan", "=", "free").All()
+	if len(rows) != 2 {
+		t.Fatalf("expected plan on both rows, got %v", rows)
+	}
+	for _, row := range rows {
+		if _, ok := row["age"]; ok {
+			t.Fatalf("dropped column still stored: %v", row)
+		}
+	}
+	if err := db.Insert().Table("users").Values(map[string]any{"email": "b@x", "age": 1}).Exec(); !errors.Is(err, errors_consts.ErrSchemaViolation) {
+		t.Fatalf("expected dropped column to be unknown, got %v", err)
+	}
+	if err := db.AlterTable("nope").DropColumn("x").Exec(); !errors.Is(err, errors_consts.ErrTableNotFound) {
+		t.Fatalf("expected ErrTableNotFound, got %v", err)
+	}
+
+	// chained renames apply in order: b -> c first, then a -> b
+	db.CreateTable("pairs", database.Schema{Columns: []database.Column{
+		{Name: "a", Type: database.TypeString}, {Name: "b", Type: database.TypeString},
+	}})
+	db.Insert().Table("pairs").Values(map[string]any{"a": "A", "b": "B"}).Exec()
+
+	for i := 0; i < 10; i++ {
+		if err := db.AlterTable("pairs").RenameColumn("b", "c").RenameColumn("a", "b").Exec(); err != nil {
+			t.Fatal(err)
+		}
+		rows, _ = db.Select().Table("pairs").All()
+		if rows[0]["b"] != "A" || rows[0]["c"] != "B" || rows[0]["a"] != nil {
+			t.Fatalf("run %d: unexpected renamed row %v", i, rows[0])
+		}
+		// and back
+		if err := db.AlterTable("pairs").RenameColumn("b", "a").RenameColumn("c", "b").Exec(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestNestedValues(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "db.data")
+	walPath := filepath.Join(dir, "db.wal")
+
+	storage, err := database.OpenDB(dbPath, walPath, database.WalSizeLimit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer storage.Close()
+
+	db := database.NewDB(storage)
+
+	people := []map[string]any{
+		{"name": "ann", "address": map[string]any{"city": "Oslo", "zip": 150}, "tags": []string{"admin", "dev"}},
+		{"name": "bob", "address": map[string]string{"city": "Bergen"}, "tags": []any{"dev"}},
+		{"name": "cid", "orders": []any{map[string]any{"sku": "x1", "qty": 2}}},
+	}
+	for _, p := range people {
+		if err := db.Insert().Table("people").Values(p).Exec(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Insert().Table("people").Values(map[string]any{"bad": []any{func() {}}}).Exec(); err == nil {
+		t.Fatal("expected unsupported nested value to be rejected")
+	}
+
+	names := func(q *database.SelectQuery) []string {
+		rows, err := q.OrderBy("name", database.Asc).All()
+		if err != nil {
+			t.Fatal(err)
+		}
+		out := make([]string, len(rows))
+		for i, row := range rows {
+			out[i] = row["name"].(string)
+		}
+		return out
+	}
+
+	cases := []struct {
+		field, op string
+		value     any
+		want      string
+	}{
+		{"address.city", "=", "Oslo", "ann"},
+		{"address.zip", ">", 100, "ann"},
+		{"tags[0]", "=", "dev", "bob"},
+		{"tags[1]", "exists", nil, "ann"},
+		{"orders[0].sku", "=", "x1", "cid"},
+		{"tags", "array_contains", "dev", "ann,bob"},
+		{"tags", "any", []string{"admin", "ops"}, "ann"},
+		{"address.city", "is null", nil, "cid"},
+	}
+	for _, c := range cases {
+		got := strings.Join(names(db.Select().Table("people").Where(c.field, c.op, c.value)), ",")
+		if got != c.want {
+			t.Fatalf("%s %s %v: expected %s, got %s", c.field, c.op, c.value, c.want, got)
+		}
+	}
+
+	if _, err := db.Select().Table("people").Where("tags[x]", "=", "a").All(); !errors.Is(err, errors_consts.ErrInvalidWhere) {
+		t.Fatalf("expected ErrInvalidWhere for a bad path, got %v", err)
+	}
+
+	// indexes on paths give the same answers
+	if err := db.CreateIndex("people", "address.city"); err != nil {
+		t.Fatal(err)
+	}
+	if got := names(db.Select().Table("people").Where("address.city", "=", "Bergen")); len(got) != 1 || got[0] != "bob" {
+		t.Fatalf("indexed path lookup returned %v", got)
+	}
+
+	rows, err := db.Select().Table("people").Columns("name", "address.city AS city").Where("name", "=", "ann").All()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 1 || rows[0]["city"] != "Oslo" {
+		t.Fatalf("unexpected projection %v", rows)
+	}
+}
+
+func TestSelectJoin(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "db.data")
+	walPath := filepath.Join(dir, "db.wal")
+
+	storage, err := database.OpenDB(dbPath, walPath, database.WalSizeLimit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer storage.Close()
+
+	db := database.NewDB(storage)
+
+	db.Insert().Table("user:1:customers").Values(map[string]any{"id": 1, "name": "ann"}).Exec()
+	db.Insert().Table("user:1:customers").Values(map[string]any{"id": 2, "name": "bob"}).Exec()
+	db.Insert().Table("user:1:orders").Values(map[string]any{"customer_id": 1, "total": 10}).Exec()
+	db.Insert().Table("user:1:orders").Values(map[string]any{"customer_id": 1, "total": 20}).Exec()
+	db.Insert().Table("user:1:orders").Values(map[string]any{"customer_id": 2, "total": 5}).Exec()
+	db.Insert().Table("user:1:orders").Values(map[string]any{"customer_id": 9, "total": 1}).Exec()
+	db.Insert().Table("user:1:items").Values(map[string]any{"order_id": 1, "sku": "x"}).Exec()
+
+	rows, err := db.Select().Table("user:1:orders").
+		Join("user:1:customers", "orders.customer_id", "customers.id").
+		Where("customers.name", "=", "ann").
+		OrderBy("orders.total", database.Desc).
+		Columns("orders.total AS total", "customers.name AS name").
+		All()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 || fmt.Sprint(rows[0]["total"]) != "20" || rows[1]["name"] != "ann" {
+		t.Fatalf("unexpected inner join result %v", rows)
+	}
+
+	// the unmatched order survives a left join with a null customer
+	rows, err = db.Select().Table("user:1:orders").
+		LeftJoin("user:1:customers", "customers.id", "orders.customer_id").
+		Where("customers", "is null", nil).
+		All()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 1 || fmt.Sprint(rows[0]["orders"].(map[string]any)["customer_id"]) != "9" {
+		t.Fatalf("unexpected left join result %v", rows)
+	}
+
+	// joins chain and work with aggregates
+	rows, err = db.Select().Table("user:1:customers").
+		Join("user:1:orders", "customers.id", "orders.customer_id").
+		LeftJoin("user:1:items", "items.order_id", "orders.id").
+		GroupBy("customers.name").
+		Sum("orders.total").
+		Count().
+		OrderBy("customers.name", database.Asc).
+		All()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 || fmt.Sprint(rows[0]["sum_orders.total"]) != "30" || fmt.Sprint(rows[1]["count"]) != "1" {
+		t.Fatalf("unexpected aggregate over join %v", rows)
+	}
+
+	_, err = db.Select().Table("orders").Join("customers", "orders.customer_id", "vendors.id").All()
+	if !errors.Is(err, errors_consts.ErrInvalidQuery) {
+		t.Fatalf("expected ErrInvalidQuery for a join without the joined table, got %v", err)
+	}
+}
+
+func TestSelectExplain(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "db.data")
+	walPath := filepath.Join(dir, "db.wal")
+
+	storage, err := database.OpenDB(dbPath, walPath, database.WalSizeLimit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer storage.Close()
+
+	db := database.NewDB(storage)
+
+	for i := 0; i < 100; i++ {
+		db.Insert().Table("users").Values(map[string]any{"age": i, "active": i%10 != 0}).Exec()
+	}
+
+	explain := func(q *database.SelectQuery) *database.Plan {
+		plan, err := q.Explain()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return plan
+	}
+
+	plan := explain(db.Select().Table("users").Where("age", "=", 5))
+	if plan.Access != database.AccessFullScan || plan.EstimatedRows != 100 || plan.RowsExamined != 100 || plan.RowsReturned != 1 {
+		t.Fatalf("unexpected plan without index %+v", plan)
+	}
+
+	db.CreateIndex("users", "age")
+	db.CreateIndex("users", "active")
+
+	plan = explain(db.Select().Table("users").Where("age", "=", 5))
+	if plan.Access != database.AccessIndexLookup || plan.Index != "age" || plan.EstimatedRows != 1 || plan.RowsExamined != 1 {
+		t.Fatalf("unexpected plan for =: %+v", plan)
+	}
+
+	plan = explain(db.Select().Table("users").Where("age", ">", 89).OrderBy("age", database.Desc).Limit(3))
+	if plan.Access != database.AccessIndexRange || plan.RowsExamined != 10 || plan.RowsReturned != 3 {
+		t.Fatalf("unexpected plan for >: %+v", plan)
+	}
+	if fmt.Sprint(plan.Operations) != "[filter top 3 sort by age desc limit 3 offset 0]" {
+		t.Fatalf("unexpected operations %q", plan.Operations)
+	}
+
+	// 90 of 100 rows match, so reading the index would cost more than the table
+	plan = explain(db.Select().Table("users").Where("active", "=", true))
+	if plan.Access != database.AccessFullScan || plan.RowsReturned != 90 {
+		t.Fatalf("expected a full scan for an unselective condition, got %+v", plan)
+	}
+
+	// the most selective index wins
+	plan = explain(db.Select().Table("users").Where("active", "=", false).Where("age", "<", 3))
+	if plan.Index != "age" || plan.RowsExamined != 3 || plan.RowsReturned != 1 {
+		t.Fatalf("unexpected plan for two indexed conditions %+v", plan)
+	}
+}
+
+func TestUpsertAndInsertMany(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "db.data")
+	walPath := filepath.Join(dir, "db.wal")
+
+	storage, err := database.OpenDB(dbPath, walPath, database.WalSizeLimit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer storage.Close()
+
+	db := database.NewDB(storage)
+
+	ids, err := db.InsertMany("users", []map[string]any{
+		{"email": "a@x", "name": "ann", "age": 30},
+		{"email": "b@x", "name": "bob"},
+		{"id": 10, "email": "c@x"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(ids) != "[1 2 10]" {
+		t.Fatalf("unexpected ids %v", ids)
+	}
+
+	if err := db.Insert().Table("users").Values(map[string]any{"id": 2, "name": "eve"}).Exec(); !errors.Is(err, errors_consts.ErrRowExists) {
+		t.Fatalf("expected ErrRowExists, got %v", err)
+	}
+
+	db.CreateUniqueIndex("users", "email")
+
+	// the second row collides with a stored email, so none of the batch is written
+	_, err = db.InsertMany("users", []map[string]any{{"email": "d@x"}, {"email": "a@x"}})
+	if !errors.Is(err, errors_consts.ErrUniqueViolation) {
+		t.Fatalf("expected ErrUniqueViolation, got %v", err)
+	}
+	if rows, _ := db.Select().Table("users").All(); len(rows) != 3 {
+		t.Fatalf("failed batch left %d rows", len(rows))
+	}
+
+	id, err := db.Upsert().Table("users").Values(map[string]any{"email": "a@x", "age": 31}).On("email").Exec()
+	if err != nil || id != 1 {
+		t.Fatalf("merge upsert returned %d %v", id, err)
+	}
+
+	rows, _ := db.Select().Table("users").Where("id", "=", 1).All()
+	if rows[0]["name"] != "ann" || fmt.Sprint(rows[0]["age"]) != "31" {
+		t.Fatalf("unexpected merged row %v", rows[0])
+	}
+
+	db.Upsert().Table("users").Values(map[string]any{"id": 2, "email": "b@x"}).Replace().Exec()
+	rows, _ = db.Select().Table("users").Where("id", "=", 2).All()
+	if len(rows[0]) != 2 || rows[0]["name"] != nil {
+		t.Fatalf("unexpected replaced row %v", rows[0])
+	}
+
+	// the explicit id 10 moved the counter past it
+	id, err = db.Upsert().Table("users").Values(map[string]any{"email": "new@x"}).On("email").Exec()
+	if err != nil || id != 11 {
+		t.Fatalf("inserting upsert returned %d %v", id, err)
+	}
+
+	if _, err := db.Upsert().Table("users").Values(map[string]any{"name": "x"}).On("name").Exec(); !errors.Is(err, errors_consts.ErrIndexNotFound) {
+		t.Fatalf("expected ErrIndexNotFound for a key without unique index, got %v", err)
+	}
+}
+
+func TestExplicitThenAutoID(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "db.data")
+	walPath := filepath.Join(dir, "db.wal")
+
+	storage, err := database.OpenDB(dbPath, walPath, database.WalSizeLimit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer storage.Close()
+
+	db := database.NewDB(storage)
+
+	if err := db.Insert().Table("users").Values(map[string]any{"id": 1, "name": "explicit"}).Exec(); err != nil {
+		t.Fatal(err)
+	}
+
+	id, err := db.Insert().Table("users").Values(map[string]any{"name": "auto"}).ExecAndReturnID()
+	if err != nil || id != 2 {
+		t.Fatalf("expected the auto insert to get id 2, got %d %v", id, err)
+	}
+
+	// generated ids of a batch start past its explicit ids
+	ids, err := db.InsertMany("users", []map[string]any{{"id": 5, "name": "explicit"}, {"name": "auto"}})
+	if err != nil || fmt.Sprint(ids) != "[5 6]" {
+		t.Fatalf("unexpected batch ids %v %v", ids, err)
+	}
+	id, err = db.Insert().Table("users").Values(map[string]any{"name": "auto"}).ExecAndReturnID()
+	if err != nil || id != 7 {
+		t.Fatalf("expected the auto insert to get id 7, got %d %v", id, err)
+	}
+
+	ids, err = db.InsertMany("imports", []map[string]any{{"a": 0}, {"id": 1, "name": "explicit"}, {"a": 2}})
+	if err != nil || fmt.Sprint(ids) != "[2 1 3]" {
+		t.Fatalf("unexpected ids for a mixed batch on an empty table: %v %v", ids, err)
+	}
+
+	rows, _ := db.Select().Table("users").Where("name", "=", "explicit").All()
+	if len(rows) != 2 {
+		t.Fatalf("explicit rows were overwritten: %v", rows)
+	}
+}
+
+type testAddress struct {
+	City string `db:"city"`
+	Zip  *int   `db:"zip"`
+}
+
+type testAudit struct {
+	Version int `db:"version"`
+}
+
+type testCustomer struct {
+	testAudit
+	ID      int64            `db:"id"`
+	Name    string           `db:"name"`
+	Age     uint8            `db:"age,omitempty"`
+	Score   float64          `db:"score"`
+	Address testAddress      `db:"address"`
+	Tags    []string         `db:"tags"`
+	Extra   map[string]any   `db:"extra"`
+	Limits  map[string]int64 `db:"limits"`
+	Secret  string           `db:"-"`
+	Notes   *string          `db:"notes"`
+}
+
+func TestTypedTable(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "db.data")
+	walPath := filepath.Join(dir, "db.wal")
+
+	storage, err := database.OpenDB(dbPath, walPath, database.WalSizeLimit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer storage.Close()
+
+	db := database.NewDB(storage)
+	customers := database.NewTable[testCustomer](db, "customers")
+
+	zip := 1234
+	ann := testCustomer{
+		testAudit: testAudit{Version: 2},
+		Name:      "ann",
+		Age:       30,
+		Score:     1.5,
+		Address:   testAddress{City: "Oslo", Zip: &zip},
+		Tags:      []string{"vip"},
+		Extra:     map[string]any{"k": "v"},
+		Limits:    map[string]int64{"daily": 1 << 60},
+		Secret:    "hidden",
+	}
+
+	id, err := customers.Insert(&ann)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != 1 || ann.ID != 1 {
+		t.Fatalf("expected the assigned id to be written back, got %d %d", id, ann.ID)
+	}
+
+	if _, err := customers.InsertMany([]testCustomer{{Name: "bob"}, {Name: "cid", Score: 3}}); err != nil {
+		t.Fatal(err)
+	}
+
+	got, found, err := customers.Get(1)
+	if err != nil || !found {
+		t.Fatalf("get failed: %v %v", found, err)
+	}
+	if got.Version != 2 || got.Age != 30 || got.Address.City != "Oslo" || *got.Address.Zip != 1234 ||
+		got.Tags[0] != "vip" || got.Extra["k"] != "v" || got.Limits["daily"] != 1<<60 || got.Secret != "" || got.Notes != nil {
+		t.Fatalf("unexpected row %+v", got)
+	}
+
+	rows, _ := db.Select().Table("customers").Where("id", "=", 2).All()
+	if _, ok := rows[0]["age"]; ok {
+		t.Fatalf("omitempty field was stored: %v", rows[0])
+	}
+
+	found2, err := database.SelectInto[testCustomer](customers.Select().Where("score", ">", 1).OrderBy("score", database.Desc))
+	if err != nil || len(found2) != 2 || found2[0].Name != "cid" {
+		t.Fatalf("unexpected select %+v %v", found2, err)
+	}
+
+	// numbers must fit the field exactly
+	db.Insert().Table("customers").Values(map[string]any{"id": 9, "age": 300}).Exec()
+	db.Insert().Table("customers").Values(map[string]any{"id": 10, "age": 2.5}).Exec()
+
+	// the one coercion: an integer field takes a string holding an integer, like ids do
+	db.Insert().Table("customers").Values(map[string]any{"id": 11, "age": "42"}).Exec()
+	db.Insert().Table("customers").Values(map[string]any{"id": 12, "age": "forty"}).Exec()
+	if got, _, err := customers.Get(11); err != nil || got.Age != 42 {
+		t.Fatalf("expected the numeric string to be read, got %+v %v", got, err)
+	}
+
+	for _, id := range []int64{9, 10, 12} {
+		if _, _, err := customers.Get(id); !errors.Is(err, errors_consts.ErrTypeMismatch) {
+			t.Fatalf("id %d: expected ErrTypeMismatch, got %v", id, err)
+		}
+	}
+}
+
+func TestExactNumbers(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "db.data")
+	walPath := filepath.Join(dir, "db.wal")
+
+	storage, err := database.OpenDB(dbPath, walPath, database.WalSizeLimit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer storage.Close()
+
+	db := database.NewDB(storage)
+
+	// 2^53 and 2^53+1 are the same float64
+	const snowflake = int64(1) << 53
+	db.Insert().Table("events").Values(map[string]any{"sid": snowflake}).Exec()
+	db.Insert().Table("events").Values(map[string]any{"sid": snowflake + 1}).Exec()
+	db.Insert().Table("events").Values(map[string]any{"sid": json.Number("123456789012345678901234567890")}).Exec()
+
+	count := func(q *database.SelectQuery) int {
+		rows, err := q.All()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(rows)
+	}
+
+	for _, indexed := range []bool{false, true} {
+		if indexed {
+			if err := db.CreateUniqueIndex("events", "sid"); err != nil {
+				t.Fatalf("distinct 64-bit ids violate the unique index: %v", err)
+			}
+		}
+
+		if n := count(db.Select().Table("events").Where("sid", "=", snowflake+1)); n != 1 {
+			t.Fatalf("indexed=%v: = matched %d rows", indexed, n)
+		}
+		if n := count(db.Select().Table("events").Where("sid", ">", snowflake)); n != 2 {
+			t.Fatalf("indexed=%v: > matched %d rows", indexed, n)
+		}
+		if n := count(db.Select().Table("events").Where("sid", "=", json.Number("123456789012345678901234567891"))); n != 0 {
+			t.Fatalf("indexed=%v: big integers compared inexactly", indexed)
+		}
+	}
+
+	price, _ := database.ParseDecimal("0.10")
+	amounts := []any{price, database.NewDecimal(20, 2), json.Number("-1.25"), -1.5, -10}
+	for _, a := range amounts {
+		db.Insert().Table("payments").Values(map[string]any{"amount": a}).Exec()
+	}
+	db.CreateIndex("payments", "amount")
+
+	rows, _ := db.Select().Table("payments").Where("amount", ">", 0).Sum("amount").All()
+	if sum, ok := rows[0]["sum_amount"].(database.Decimal); !ok || sum.String() != "0.30" {
+		t.Fatalf("expected the exact decimal 0.30, got %#v", rows[0]["sum_amount"])
+	}
+
+	if n := count(db.Select().Table("payments").Where("amount", "=", 0.1)); n != 1 {
+		t.Fatalf("float 0.1 should equal decimal 0.10, matched %d", n)
+	}
+
+	rows, _ = db.Select().Table("payments").Where("amount", "<", -1.25).OrderBy("amount", database.Asc).All()
+	if len(rows) != 2 || fmt.Sprint(rows[0]["amount"]) != "-10" || fmt.Sprint(rows[1]["amount"]) != "-1.5" {
+		t.Fatalf("unexpected negative range %v", rows)
+	}
+
+	if err := db.Insert().Table("events").Values(map[string]any{"id": 1.5}).Exec(); err == nil {
+		t.Fatal("expected a fractional id to be rejected")
+	}
+
+	// the first upgrade rewrites the keys, the second finds them current
+	for i, want := range []bool{true, false} {
+		if rebuilt, err := db.UpgradeIndexes(); err != nil || rebuilt != want {
+			t.Fatalf("upgrade %d: rebuilt=%v err=%v", i, rebuilt, err)
+		}
+	}
+	if n := count(db.Select().Table("events").Where("sid", "=", snowflake)); n != 1 {
+		t.Fatalf("index lookup after upgrade matched %d rows", n)
+	}
+
+	// index metadata of single-field indexes written before compound indexes
+	storage.Set("__idxmeta__:legacy", []byte(`[{"table":"legacy","field":"age"}]`))
+	defs, err := db.ListIndexes("legacy")
+	if err != nil || len(defs) != 1 || fmt.Sprint(defs[0].Fields) != "[age]" {
+		t.Fatalf("legacy index metadata read as %+v %v", defs, err)
+	}
+}
+
+func TestTypedValues(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "db.data")
+	walPath := filepath.Join(dir, "db.wal")
+
+	storage, err := database.OpenDB(dbPath, walPath, database.WalSizeLimit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer storage.Close()
+
+	db := database.NewDB(storage)
+
+	err = db.CreateTable("events", database.Schema{Columns: []database.Column{
+		{Name: "at", Type: database.TypeTimestamp, Default: database.DefaultNow},
+		{Name: "ref", Type: database.TypeUUID, Default: database.DefaultUUID},
+		{Name: "payload", Type: database.TypeBytes, Nullable: true},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	berlin := time.FixedZone("CEST", 2*60*60)
+	noon := time.Date(2024, 5, 1, 12, 0, 0, 0, berlin) // 10:00 UTC
+	ref := database.NewUUID()
+
+	db.Insert().Table("events").Values(map[string]any{"at": noon, "ref": ref, "payload": []byte{0, 1, 2}}).Exec()
+	db.Insert().Table("events").Values(map[string]any{"at": noon.Add(time.Hour).UTC()}).Exec()
+	// the tagged form HTTP bodies use
+	db.Insert().Table("events").Values(map[string]any{"at": map[string]any{"$time": "2024-05-01T09:00:00Z"}, "payload": map[string]any{"$bytes": "AQ=="}}).Exec()
+
+	count := func(q *database.SelectQuery) int {
+		rows, err := q.All()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(rows)
+	}
+
+	for _, indexed := range []bool{false, true} {
+		if indexed {
+			db.CreateIndex("events", "at")
+			db.CreateIndex("events", "ref")
+		}
+
+		if n := count(db.Select().Table("events").Where("at", "=", noon.UTC())); n != 1 {
+			t.Fatalf("indexed=%v: the same instant in another zone matched %d rows", indexed, n)
+		}
+		if n := count(db.Select().Table("events").Where("at", ">=", map[string]any{"$time": "2024-05-01T12:00:00+02:00"})); n != 2 {
+			t.Fatalf("indexed=%v: range by instant matched %d rows", indexed, n)
+		}
+		if n := count(db.Select().Table("events").Where("ref", "=", ref)); n != 1 {
+			t.Fatalf("indexed=%v: uuid lookup matched %d rows", indexed, n)
+		}
+	}
+
+	rows, err := db.Select().Table("events").OrderBy("at", database.Asc).All()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first, ok := rows[0]["at"].(time.Time)
+	if !ok || !first.Equal(time.Date(2024, 5, 1, 9, 0, 0, 0, time.UTC)) {
+		t.Fatalf("expected the earliest timestamp first, got %#v", rows[0]["at"])
+	}
+	if !bytes.Equal(rows[0]["payload"].([]byte), []byte{1}) {
+		t.Fatalf("bytes did not round trip: %#v", rows[0]["payload"])
+	}
+
+	stored := rows[1]
+	if at := stored["at"].(time.Time); !at.Equal(noon) {
+		t.Fatalf("timestamp did not round trip: %v", at)
+	}
+	if _, offset := stored["at"].(time.Time).Zone(); offset != 2*60*60 {
+		t.Fatalf("the time zone of the timestamp was lost")
+	}
+	if stored["ref"] != ref || !bytes.Equal(stored["payload"].([]byte), []byte{0, 1, 2}) {
+		t.Fatalf("typed values did not round trip: %#v", stored)
+	}
+	if _, ok := rows[2]["ref"].(database.UUID); !ok || rows[2]["ref"] == ref {
+		t.Fatalf("uuid() default should generate a new uuid, got %#v", rows[2]["ref"])
+	}
+
+	err = db.Insert().Table("events").Values(map[string]any{"at": map[string]any{"$time": "yesterday"}}).Exec()
+	if !errors.Is(err, errors_consts.ErrInvalidValue) {
+		t.Fatalf("expected ErrInvalidValue for a malformed tag, got %v", err)
+	}
+}
+
+func TestTableCatalog(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "db.data")
+	walPath := filepath.Join(dir, "db.wal")
+
+	storage, err := database.OpenDB(dbPath, walPath, database.WalSizeLimit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer storage.Close()
+
+	db := database.NewDB(storage)
+
+	db.Insert().Table("user:1:contacts").Values(map[string]any{"name": "Ann", "age": 30}).Exec()
+	db.Insert().Table("user:1:contacts").Values(map[string]any{"name": "Bob", "age": 31.5, "note": "vip"}).Exec()
+	// shares the key prefix of user:1:contacts
+	db.Insert().Table("user:1:contacts:archive").Values(map[string]any{"name": "Cid"}).Exec()
+	db.CreateTable("user:1:empty", database.Schema{Columns: []database.Column{{Name: "x", Type: database.TypeInt, Nullable: true}}})
+	db.Insert().Table("user:2:contacts").Values(map[string]any{"name": "Dan"}).Exec()
+	db.CreateUniqueIndex("user:1:contacts", "name")
+
+	tables, err := db.ListTables("user:1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(tables) != "[user:1:contacts user:1:contacts:archive user:1:empty]" {
+		t.Fatalf("unexpected tables %v", tables)
+	}
+
+	info, err := db.DescribeTable("user:1:contacts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Rows != 2 || info.NextID != 3 || len(info.Indexes) != 1 || info.Bytes == 0 {
+		t.Fatalf("unexpected description %+v", info)
+	}
+	if c := info.Columns[0]; c.Name != "age" || fmt.Sprint(c.Types) != "[float int]" || c.Rows != 2 {
+		t.Fatalf("unexpected inferred column %+v", c)
+	}
+	if c := info.Columns[3]; c.Name != "note" || c.Rows != 1 {
+		t.Fatalf("unexpected inferred column %+v", c)
+	}
+
+	if err := db.RenameTable("user:1:contacts", "user:1:empty"); !errors.Is(err, errors_consts.ErrTableExists) {
+		t.Fatalf("expected ErrTableExists, got %v", err)
+	}
+	if err := db.RenameTable("user:1:contacts", "user:1:people"); err != nil {
+		t.Fatal(err)
+	}
+
+	rows, err := db.Select().Table("user:1:people").Where("name", "=", "Bob").All()
+	if err != nil || len(rows) != 1 {
+		t.Fatalf("renamed rows not found through the index: %v %v", rows, err)
+	}
+	if err := db.Insert().Table("user:1:people").Values(map[string]any{"name": "Ann"}).Exec(); !errors.Is(err, errors_consts.ErrUniqueViolation) {
+		t.Fatalf("the unique index did not move with the table: %v", err)
+	}
+	if _, err := db.DescribeTable("user:1:contacts"); !errors.Is(err, errors_consts.ErrTableNotFound) {
+		t.Fatalf("the old name still exists: %v", err)
+	}
+	if rows, _ := db.Select().Table("user:1:contacts:archive").All(); len(rows) != 1 {
+		t.Fatalf("rename touched a table sharing the prefix")
+	}
+
+	if err := db.TruncateTable("user:1:people"); err != nil {
+		t.Fatal(err)
+	}
+	id, _ := db.Insert().Table("user:1:people").Values(map[string]any{"name": "Ann"}).ExecAndReturnID()
+	if id != 1 {
+		t.Fatalf("ids should restart after truncate, got %d", id)
+	}
+
+	if err := db.DropTable("user:1:people"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.DropTable("user:1:people"); !errors.Is(err, errors_consts.ErrTableNotFound) {
+		t.Fatalf("expected ErrTableNotFound, got %v", err)
+	}
+	if n, _ := storage.CountKeys(context.Background(), "", "", 0); n != 5 {
+		keys, _ := storage.ScanKeys(context.Background(), "", "")
+		t.Fatalf("drop left keys behind: %q", keys)
+	}
+}
+
+func TestForeignKeys(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "db.data")
+	walPath := filepath.Join(dir, "db.wal")
+
+	storage, err := database.OpenDB(dbPath, walPath, database.WalSizeLimit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer storage.Close()
+
+	db := database.NewDB(storage)
+
+	db.CreateTable("customers", database.Schema{Columns: []database.Column{{Name: "name", Type: database.TypeString}}})
+	err = db.CreateTable("orders", database.Schema{Columns: []database.Column{
+		{Name: "customer_id", Type: database.TypeInt, References: &database.ForeignKey{Table: "customers", OnDelete: database.Cascade}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.CreateTable("items", database.Schema{Columns: []database.Column{
+		{Name: "order_id", Type: database.TypeInt, References: &database.ForeignKey{Table: "orders", OnDelete: database.Cascade}},
+	}})
+	db.CreateTable("reviews", database.Schema{Columns: []database.Column{
+		{Name: "customer_id", Type: database.TypeInt, Nullable: true, References: &database.ForeignKey{Table: "customers", OnDelete: database.SetNull}},
+	}})
+	db.CreateTable("invoices", database.Schema{Columns: []database.Column{
+		{Name: "order_id", Type: database.TypeInt, References: &database.ForeignKey{Table: "orders"}},
+	}})
+	db.CreateIndex("orders", "customer_id")
+
+	err = db.CreateTable("bad", database.Schema{Columns: []database.Column{
+		{Name: "c", Type: database.TypeInt, References: &database.ForeignKey{Table: "customers", OnDelete: database.SetNull}},
+	}})
+	if !errors.Is(err, errors_consts.ErrInvalidSchema) {
+		t.Fatalf("set null on a non-nullable column should be rejected, got %v", err)
+	}
+
+	db.Insert().Table("customers").Values(map[string]any{"name": "Ann"}).Exec()
+	db.Insert().Table("customers").Values(map[string]any{"name": "Bob"}).Exec()
+	db.Insert().Table("orders").Values(map[string]any{"customer_id": 1}).Exec()
+	db.Insert().Table("orders").Values(map[string]any{"customer_id": 2}).Exec()
+	db.Insert().Table("items").Values(map[string]any{"order_id": 1}).Exec()
+	db.Insert().Table("reviews").Values(map[string]any{"customer_id": 1}).Exec()
+	db.Insert().Table("invoices").Values(map[string]any{"order_id": 2}).Exec()
+
+	err = db.Insert().Table("orders").Values(map[string]any{"customer_id": 99}).Exec()
+	if !errors.Is(err, errors_consts.ErrForeignKeyViolation) {
+		t.Fatalf("expected ErrForeignKeyViolation for a missing customer, got %v", err)
+	}
+	if _, err := db.Update().Table("orders").Set(map[string]any{"customer_id": 99}).Exec(); !errors.Is(err, errors_consts.ErrForeignKeyViolation) {
+		t.Fatalf("expected ErrForeignKeyViolation on update, got %v", err)
+	}
+
+	count := func(table string) int {
+		rows, err := db.Select().Table(table).All()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(rows)
+	}
+
+	// rows removed or changed by foreign key actions run the hooks of their table
+	var events []string
+	record := func(ctx context.Context, ev *database.HookEvent) error {
+		events = append(events, fmt.Sprintf("%s %s %d %v", ev.Point, ev.Table, ev.ID, ev.Row["customer_id"]))
+		return nil
+	}
+	db.On("orders", database.AfterDelete, record)
+	db.On("items", database.AfterDelete, record)
+	db.On("reviews", database.AfterUpdate, func(ctx context.Context, ev *database.HookEvent) error {
+		if ev.Old["customer_id"] == nil {
+			t.Errorf("set null hook without the old customer: %v", ev.Old)
+		}
+		return record(ctx, ev)
+	})
+
+	// Ann: her order and its item cascade, her review loses the reference
+	if err := db.Delete().Table("customers").Where("id", "=", 1).Exec(); err != nil {
+		t.Fatal(err)
+	}
+	want := "[after delete orders 1 1 after delete items 1 <nil> after update reviews 1 <nil>]"
+	if got := fmt.Sprint(events); got != want {
+		t.Fatalf("foreign key actions ran hooks %s, want %s", got, want)
+	}
+	if count("orders") != 1 || count("items") != 0 {
+		t.Fatalf("cascade left orders=%d items=%d", count("orders"), count("items"))
+	}
+	rows, _ := db.Select().Table("reviews").All()
+	if len(rows) != 1 || rows[0]["customer_id"] != nil {
+		t.Fatalf("expected the review to be kept with a null customer, got %v", rows)
+	}
+
+	// Bob: his order has an invoice, which restricts the whole cascade
+	err = db.Delete().Table("customers").Where("id", "=", 2).Exec()
+	if !errors.Is(err, errors_consts.ErrForeignKeyViolation) {
+		t.Fatalf("expected ErrForeignKeyViolation from the invoice, got %v", err)
+	}
+	if count("customers") != 1 || count("orders") != 1 {
+		t.Fatalf("a restricted delete must not delete anything")
+	}
+
+	if err := db.DropTable("customers"); !errors.Is(err, errors_consts.ErrForeignKeyViolation) {
+		t.Fatalf("dropping a referenced table should fail, got %v", err)
+	}
+	if err := db.DropTable("invoices"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Delete().Table("customers").Where("id", "=", 2).Exec(); err != nil {
+		t.Fatalf("the delete should cascade once the invoices are gone: %v", err)
+	}
+	if count("orders") != 0 {
+		t.Fatalf("cascade left %d orders", count("orders"))
+	}
+}
+
+func TestHooks(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "db.data")
+	walPath := filepath.Join(dir, "db.wal")
+
+	storage, err := database.OpenDB(dbPath, walPath, database.WalSizeLimit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer storage.Close()
+
+	db := database.NewDB(storage)
+
+	errTooBig := errors.New("too big")
+
+	db.On("orders", database.BeforeInsert, func(ctx context.Context, ev *database.HookEvent) error {
+		if n, _ := ev.Row["qty"].(int); n > 100 {
+			return errTooBig
+		}
+		ev.Row["status"] = "new"
+
+		// a counter stored in the same transaction as the row
+		count := 0
+		if raw, ok := ev.Tx.Get("stats:orders"); ok {
+			json.Unmarshal(raw, &count)
+		}
+		data, _ := json.Marshal(count + 1)
+		ev.Tx.Set("stats:orders", data)
+		return nil
+	})
+
+	var inserted []int64
+	db.On("orders", database.AfterInsert, func(ctx context.Context, ev *database.HookEvent) error {
+		inserted = append(inserted, ev.ID)
+		// after-hooks may use the DB
+		rows, err := db.Select().Table("orders").Where("id", "=", ev.ID).All()
+		if err != nil || len(rows) != 1 || ev.Row["status"] != "new" {
+			return fmt.Errorf("the committed row is not visible: %v %v", rows, err)
+		}
+		return nil
+	})
+
+	var updates []string
+	db.On("orders", database.BeforeUpdate, func(ctx context.Context, ev *database.HookEvent) error {
+		updates = append(updates, fmt.Sprint(ev.Old["status"], "->", ev.Row["status"]))
+		return nil
+	})
+
+	var deleted []any
+	db.On("orders", database.AfterDelete, func(ctx context.Context, ev *database.HookEvent) error {
+		deleted = append(deleted, ev.Row["qty"])
+		return nil
+	})
+
+	db.Insert().Table("orders").Values(map[string]any{"qty": 1}).Exec()
+	db.InsertMany("orders", []map[string]any{{"qty": 2}, {"qty": 3}})
+
+	_, err = db.InsertMany("orders", []map[string]any{{"qty": 4}, {"qty": 500}})
+	if !errors.Is(err, errTooBig) {
+		t.Fatalf("expected the hook to veto the batch, got %v", err)
+	}
+
+	rows, _ := db.Select().Table("orders").Where("status", "=", "new").All()
+	if len(rows) != 3 || fmt.Sprint(inserted) != "[1 2 3]" {
+		t.Fatalf("expected 3 orders with status new, got %v (after hooks saw %v)", rows, inserted)
+	}
+	if raw, _ := storage.Get("stats:orders"); string(raw) != "3" {
+		t.Fatalf("expected the counter to be 3, got %s", raw)
+	}
+
+	db.Update().Table("orders").Set(map[string]any{"status": "paid"}).Where("id", "=", 2).Exec()
+	if fmt.Sprint(updates) != "[new->paid]" {
+		t.Fatalf("unexpected update events %v", updates)
+	}
+
+	// order 2 is already paid: only order 1 is written and counted
+	n, err := db.Update().Table("orders").Set(map[string]any{"status": "paid"}).Where("id", "<=", 2).Exec()
+	if err != nil || n != 1 {
+		t.Fatalf("expected 1 changed row, got %d %v", n, err)
+	}
+	if fmt.Sprint(updates) != "[new->paid new->paid]" {
+		t.Fatalf("unchanged rows ran update hooks: %v", updates)
+	}
+
+	// a delete is one transaction: vetoing one row keeps every row
+	errKeep := errors.New("order 3 is kept")
+	keep := true
+	db.On("orders", database.BeforeDelete, func(ctx context.Context, ev *database.HookEvent) error {
+		if keep && fmt.Sprint(ev.Row["qty"]) == "3" {
+			return errKeep
+		}
+		return nil
+	})
+	n, err = db.Delete().Table("orders").Where("qty", ">=", 2).ExecAndCount()
+	if !errors.Is(err, errKeep) || n != 0 {
+		t.Fatalf("expected the hook to veto the delete, got %d %v", n, err)
+	}
+	if rows, _ := db.Select().Table("orders").Where("qty", ">=", 2).All(); len(rows) != 2 || len(deleted) != 0 {
+		t.Fatalf("a vetoed delete removed rows: %v (after hooks saw %v)", rows, deleted)
+	}
+	keep = false
+
+	db.Delete().Table("orders").Where("qty", ">=", 2).Exec()
+	if len(deleted) != 2 {
+		t.Fatalf("expected 2 delete events, got %v", deleted)
+	}
+}
+
+func TestFullTextSearch(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "db.data")
+	walPath := filepath.Join(dir, "db.wal")
+
+	storage, err := database.OpenDB(dbPath, walPath, database.WalSizeLimit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer storage.Close()
+
+	db := database.NewDB(storage)
+
+	db.InsertMany("notes", []map[string]any{
+		{"body": "The quick brown fox jumps over the lazy dog", "lang": "en"},
+		{"body": "A brown dog is running in the park", "lang": "en"},
+		{"body": "Foxes and dogs: the dog jumped, the dog ran", "lang": "en"},
+		{"body": "Nothing to see here", "lang": "en"},
+	})
+
+	if _, err := db.Search().Table("notes").Match("body", "dog").All(); !errors.Is(err, errors_consts.ErrIndexNotFound) {
+		t.Fatalf("expected ErrIndexNotFound without a text index, got %v", err)
+	}
+
+	if err := db.CreateTextIndex("notes", "body"); err != nil {
+		t.Fatal(err)
+	}
+	db.Insert().Table("notes").Values(map[string]any{"body": "Lazy dogs sleep", "lang": "de"}).Exec()
+
+	ids := func(hits []database.SearchHit, err error) string {
+		if err != nil {
+			t.Fatal(err)
+		}
+		out := make([]int64, len(hits))
+		for i, h := range hits {
+			out[i] = h.ID
+		}
+		return fmt.Sprint(out)
+	}
+
+	// stemming: dog, dogs; three "dog"s rank row 3 first, shorter rows rank higher
+	if got := ids(db.Search().Table("notes").Match("body", "DOGS").All()); got != "[3 5 2 1]" {
+		t.Fatalf("unexpected ranking for dogs: %s", got)
+	}
+	if got := ids(db.Search().Table("notes").Match("body", "jumping fox").All()); got != "[3 1]" {
+		t.Fatalf("unexpected hits for jumping fox: %s", got)
+	}
+	if got := ids(db.Search().Table("notes").Match("body", `"lazy dog"`).All()); got != "[5 1]" {
+		t.Fatalf("unexpected hits for the phrase: %s", got)
+	}
+	if got := ids(db.Search().Table("notes").Match("body", `"dog lazy"`).All()); got != "[]" {
+		t.Fatalf("expected no hits for the reversed phrase, got %s", got)
+	}
+	if got := ids(db.Search().Table("notes").Match("body", "dog").Where("lang", "=", "de").All()); got != "[5]" {
+		t.Fatalf("unexpected hits with a where: %s", got)
+	}
+
+	// the index follows updates and deletes
+	db.Update().Table("notes").Set(map[string]any{"body": "a cat"}).Where("id", "=", 3).Exec()
+	db.Delete().Table("notes").Where("id", "=", 5).Exec()
+	if got := ids(db.Search().Table("notes").Match("body", "dog").All()); got != "[2 1]" {
+		t.Fatalf("unexpected hits after writes: %s", got)
+	}
+
+	plan, err := db.Select().Table("notes").WhereCond(database.Match("body", "park dog")).Explain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plan.Access != database.AccessTextIndex || plan.RowsExamined != 1 || plan.RowsReturned != 1 {
+		t.Fatalf("unexpected plan for match: %+v", plan)
+	}
+
+	db.RenameTable("notes", "memos")
+	if got := ids(db.Search().Table("memos").Match("body", "cat").All()); got != "[3]" {
+		t.Fatalf("unexpected hits after rename: %s", got)
+	}
+
+	if _, err := db.Search().Table("memos").Match("body", "cat").Limit(-1).All(); !errors.Is(err, errors_consts.ErrInvalidQuery) {
+		t.Fatalf("expected ErrInvalidQuery for a negative limit, got %v", err)
+	}
+	if _, err := db.Search().Table("memos").Match("body", "cat").Offset(-1).All(); !errors.Is(err, errors_consts.ErrInvalidQuery) {
+		t.Fatalf("expected ErrInvalidQuery for a negative offset, got %v", err)
+	}
+
+	db.DropTable("memos")
+	if keys, _ := storage.ScanPrefixContext(context.Background(), "__fts"); len(keys) != 0 {
+		t.Fatalf("expected no text index keys after drop, got %v", keys)
+	}
+
+	// a text-indexed column is not dropped under its postings
+	db.CreateTable("docs", database.Schema{Columns: []database.Column{{Name: "body", Type: database.TypeString}}})
+	db.CreateTextIndex("docs", "body")
+	db.Insert().Table("docs").Values(map[string]any{"body": "a dog"}).Exec()
+	if err := db.AlterTable("docs").DropColumn("body").Exec(); !errors.Is(err, errors_consts.ErrInvalidSchema) {
+		t.Fatalf("expected ErrInvalidSchema dropping a text-indexed column, got %v", err)
+	}
+	if got := ids(db.Search().Table("docs").Match("body", "dog").All()); got != "[1]" {
+		t.Fatalf("the failed drop changed the text index: %s", got)
+	}
+}
+
+func TestMigrations(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "db.data")
+	walPath := filepath.Join(dir, "db.wal")
+
+	storage, err := database.OpenDB(dbPath, walPath, database.WalSizeLimit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer storage.Close()
+
+	db := database.NewDB(storage)
+	ctx := context.Background()
+
+	var set database.Migrations
+	set.Register(database.Migration{
+		Version: 1,
+		Name:    "create orders",
+		Up: func(ctx context.Context, db *database.DB) error {
+			return db.CreateTable("orders", database.Schema{Columns: []database.Column{
+				{Name: "qty", Type: database.TypeInt},
+			}})
+		},
+		Down: func(ctx context.Context, db *database.DB) error {
+			return db.DropTable("orders")
+		},
+	})
+	// registered out of order on purpose
+	set.Register(database.Migration{
+		Version: 3,
+		Name:    "rename qty",
+		Up: func(ctx context.Context, db *database.DB) error {
+			return db.AlterTable("orders").RenameColumn("qty", "quantity").ExecContext(ctx)
+		},
+	})
+	set.Register(database.Migration{
+		Version: 2,
+		Name:    "backfill status",
+		Up: func(ctx context.Context, db *database.DB) error {
+			if err := db.AlterTable("orders").AddColumn(database.Column{Name: "status", Type: database.TypeString, Nullable: true}).ExecContext(ctx); err != nil {
+				return err
+			}
+			_, err := db.Update().Table("orders").Set(map[string]any{"status": "new"}).Where("status", "is null", nil).ExecContext(ctx)
+			return err
+		},
+		Down: func(ctx context.Context, db *database.DB) error {
+			return db.AlterTable("orders").DropColumn("status").ExecContext(ctx)
+		},
+	})
+
+	applied, err := db.MigrateUp(ctx, &set, 1)
+	if err != nil || len(applied) != 1 {
+		t.Fatalf("expected migration 1 to apply, got %v %v", applied, err)
+	}
+	db.InsertMany("orders", []map[string]any{{"qty": 1}, {"qty": 2}})
+
+	applied, err = db.MigrateUp(ctx, &set, 0)
+	if err != nil || len(applied) != 2 || applied[0].Version != 2 || applied[1].Version != 3 {
+		t.Fatalf("expected migrations 2 and 3 to apply in order, got %v %v", applied, err)
+	}
+	if applied, _ := db.MigrateUp(ctx, &set, 0); len(applied) != 0 {
+		t.Fatalf("expected nothing left to apply, got %v", applied)
+	}
+
+	rows, _ := db.Select().Table("orders").Where("status", "=", "new").Where("quantity", "exists", nil).All()
+	if len(rows) != 2 || rows[0]["qty"] != nil {
+		t.Fatalf("expected both rows backfilled and renamed, got %v", rows)
+	}
+
+	status, err := db.MigrationStatus(ctx, &set)
+	if err != nil || len(status) != 3 || !status[2].Applied || status[2].AppliedAt.IsZero() {
+		t.Fatalf("unexpected status %+v %v", status, err)
+	}
+
+	// 3 has no down step
+	if _, err := db.MigrateDown(ctx, &set, 1); !errors.Is(err, errors_consts.ErrMigrationIrreversible) {
+		t.Fatalf("expected ErrMigrationIrreversible, got %v", err)
+	}
+
+	var older database.Migrations
+	older.Register(database.Migration{Version: 1, Name: "create orders", Up: set.List()[0].Up})
+	status, _ = db.MigrationStatus(ctx, &older)
+	if len(status) != 3 || !status[1].Unknown || status[1].Name != "backfill status" {
+		t.Fatalf("expected the ledger-only migrations to be listed as unknown, got %+v", status)
+	}
+	if _, err := db.MigrateDown(ctx, &older, 1); !errors.Is(err, errors_consts.ErrMigrationUnknown) {
+		t.Fatalf("expected ErrMigrationUnknown, got %v", err)
+	}
+}
@@ -2,12 +2,20 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"golangdb/config"
 	"golangdb/database"
+	"golangdb/errors_consts"
+	"golangdb/migrations"
 	"golangdb/server"
+	"io/fs"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
@@ -20,14 +28,42 @@ func LoadENV() error {
 
 func main() {
 	// Loading .env file
-	// If there is an error, it is a problem with the .env file -> we panic, nothing more to do
-	if err := LoadENV(); err != nil {
+	// A missing .env is fine now (everything can come from flags or a config file),
+	// but a broken one is a problem -> we panic, nothing more to do
+	if err := LoadENV(); err != nil && !errors.Is(err, fs.ErrNotExist) {
 		log.Panicf("Error loading .env file: %s", err.Error())
 	}
+
+	// Merging defaults, config file, environment and flags into one validated config.
+	cfg, err := config.Load(os.Args[1:], os.Getenv)
+
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+
+	if err != nil {
+		log.Fatalf("Invalid configuration: %s", err.Error())
+	}
+
+	if cfg.PrintConfig {
+		if err := cfg.Print(os.Stdout); err != nil {
+			log.Fatalf("Failed to print config: %s", err.Error())
+		}
+		return
+	}
+
+	opts, err := cfg.DatabaseOptions()
+
+	if err != nil {
+		log.Fatalf("Invalid configuration: %s", err.Error())
+	}
+
+	opts.Logger = log.Default()
+
 	// Initialing the core db. It is necessary here since by opening the DB core we re-initialize files (WAL and .db file),
 	// drop in-memory storage, re-allocate it, then we check for snapshot and replaying wal.
 	// Initialize the core in here is a necessity.
-	databaseCore, err := database.OpenDB(database.DbPath, database.WalPath, database.WalSizeLimit)
+	databaseCore, err := database.Open(opts)
 
 	// Of course, if an error happened, it is a problem with the core -> we panic, nothing more to do
 	if err != nil {
@@ -39,20 +75,67 @@ func main() {
 	// It cannot return an error because it is fully dependent on core -> if there is a core, this will function.
 	myDatabaseStorage := database.NewDB(databaseCore)
 
-	port := os.Getenv("PORT")
+	// Index keys written by an older version encode numbers differently; rebuild them before use.
+	rebuilt, err := myDatabaseStorage.UpgradeIndexes()
+	if err != nil {
+		log.Panicf("Failed to upgrade indexes: %s", err.Error())
+	}
+	if rebuilt {
+		log.Println("Indexes rebuilt for the current key format")
+	}
+
+	// "golangdb [flags] migrate up" and friends run against the database and exit instead of serving.
+	if len(cfg.Command) > 0 {
+		err := runCommand(context.Background(), myDatabaseStorage, cfg.Command, os.Stdout)
+		if closeErr := databaseCore.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			log.Fatalf("%s failed: %s", cfg.Command[0], err.Error())
+		}
+		return
+	}
 
-	if port == "" {
-		port = "8080"
+	// Pending migrations run before the first request when asked for; a failing one stops the start.
+	if cfg.AutoMigrate {
+		applied, err := myDatabaseStorage.MigrateUp(context.Background(), &migrations.All, 0)
+		for _, m := range applied {
+			log.Printf("Migration %d %s applied", m.Version, m.Name)
+		}
+		if err != nil {
+			log.Panicf("Failed to migrate: %s", err.Error())
+		}
 	}
 
-	myServer := server.NewServer(myDatabaseStorage, port)
+	// Emails identify users: the unique index makes two concurrent sign-ups with one email impossible
+	// and serves the lookups of sign-up and login. A database that already holds duplicate emails
+	// does not start: the duplicate accounts have to be merged or removed first, e.g. by a migration
+	// ("golangdb migrate up" or -auto-migrate, both run before this check).
+	err = myDatabaseStorage.CreateUniqueIndex("__users__", "email")
+
+	switch {
+	case err == nil, errors.Is(err, errors_consts.ErrIndexExists):
+	case errors.Is(err, errors_consts.ErrUniqueViolation):
+		duplicates, dupErr := duplicateEmails(myDatabaseStorage)
+		if dupErr != nil {
+			log.Panicf("Failed to create users index: %s", err.Error())
+		}
+		log.Panicf("Failed to create users index: emails shared by several accounts in __users__: %s; "+
+			"merge or remove the duplicate accounts, then start again", strings.Join(duplicates, ", "))
+	default:
+		log.Panicf("Failed to create users index: %s", err.Error())
+	}
+
+	server.SetJWTSecret(cfg.JWTSecret)
+
+	myServer := server.NewServer(myDatabaseStorage, cfg.Port)
 
 	go func() {
 		if err := myServer.Start(); err != nil && err != http.ErrServerClosed {
 			log.Panicf("Server malfunctions: %s", err.Error())
 		}
 	}()
-	log.Printf("Server started on port: %s", port)
+	log.Printf("Server started on port: %s", cfg.Port)
 
 	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM, syscall.SIGINT)
 
@@ -71,3 +154,18 @@ func main() {
 		log.Println("Server gracefully stopped")
 	}
 }
+
+// duplicateEmails returns the emails held by more than one row of __users__
+func duplicateEmails(db *database.DB) ([]string, error) {
+	rows, err := db.Select().Table("__users__").GroupBy("email").Count().Having("count", ">", 1).
+		OrderBy("email", database.Asc).All()
+	if err != nil {
+		return nil, err
+	}
+
+	emails := make([]string, 0, len(rows))
+	for _, row := range rows {
+		emails = append(emails, fmt.Sprint(row["email"]))
+	}
+	return emails, nil
+}
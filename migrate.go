@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"golangdb/database"
+	"golangdb/migrations"
+	"io"
+	"text/tabwriter"
+	"time"
+)
+
+const migrateUsage = `usage: golangdb [flags] migrate up [-to version]
+       golangdb [flags] migrate down [-steps n]
+       golangdb [flags] migrate status`
+
+// runCommand runs the command given after the flags instead of the server
+func runCommand(ctx context.Context, db *database.DB, args []string, out io.Writer) error {
+	switch args[0] {
+	case "migrate":
+		return runMigrate(ctx, db, args[1:], out)
+	}
+	return fmt.Errorf("unknown command %q", args[0])
+}
+
+func runMigrate(ctx context.Context, db *database.DB, args []string, out io.Writer) error {
+	if len(args) == 0 {
+		return errors.New(migrateUsage)
+	}
+
+	fs := flag.NewFlagSet("migrate "+args[0], flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	to := fs.Int64("to", 0, "apply up to this version (0 for all)")
+	steps := fs.Int("steps", 1, "number of migrations to revert")
+
+	if err := fs.Parse(args[1:]); err != nil {
+		return fmt.Errorf("%w\n%s", err, migrateUsage)
+	}
+
+	switch args[0] {
+	case "up":
+		applied, err := db.MigrateUp(ctx, &migrations.All, *to)
+		for _, m := range applied {
+			fmt.Fprintf(out, "applied  %d %s\n", m.Version, m.Name)
+		}
+		if err == nil && len(applied) == 0 {
+			fmt.Fprintln(out, "nothing to apply")
+		}
+		return err
+
+	case "down":
+		reverted, err := db.MigrateDown(ctx, &migrations.All, *steps)
+		for _, m := range reverted {
+			fmt.Fprintf(out, "reverted %d %s\n", m.Version, m.Name)
+		}
+		if err == nil && len(reverted) == 0 {
+			fmt.Fprintln(out, "nothing to revert")
+		}
+		return err
+
+	case "status":
+		status, err := db.MigrationStatus(ctx, &migrations.All)
+		if err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tSTATE\tAPPLIED AT")
+		for _, st := range status {
+			state, at := "pending", ""
+			if st.Applied {
+				state, at = "applied", st.AppliedAt.Format(time.RFC3339)
+			}
+			if st.Unknown {
+				state = "applied, not registered"
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", st.Version, st.Name, state, at)
+		}
+		return w.Flush()
+	}
+
+	return fmt.Errorf("unknown migrate command %q\n%s", args[0], migrateUsage)
+}
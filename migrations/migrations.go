@@ -0,0 +1,26 @@
+package migrations
+
+import "golangdb/database"
+
+/*
+   Migrations of the server's data
+
+   Add a migration in a file of this package named after it (e.g. 0002_backfill_status.go):
+
+     func init() {
+         All.Register(database.Migration{
+             Version: 2,
+             Name:    "backfill order status",
+             Up: func(ctx context.Context, db *database.DB) error {
+                 _, err := db.Update().Table("orders").Set(map[string]any{"status": "new"}).
+                     Where("status", "not exists", nil).ExecContext(ctx)
+                 return err
+             },
+         })
+     }
+
+   Versions must be unique; a released migration is never edited, a new one fixes it.
+   They run with "golangdb migrate up" or on server start with -auto-migrate.
+*/
+
+var All database.Migrations
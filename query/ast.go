@@ -0,0 +1,110 @@
+package query
+
+/*
+   AST
+
+   Statements keep table and field names as written; the executor maps table names
+   (e.g. adds the server's user scope). Values are literals or placeholders and are only
+   resolved when the statement runs, so parameters never go through the parser.
+*/
+
+type Statement interface {
+	statement()
+}
+
+type SelectStmt struct {
+	// nil means SELECT *
+	Columns []SelectItem
+	Table   string
+	Joins   []JoinClause
+	Where   Expr
+	GroupBy []string
+	Having  Expr
+	OrderBy []OrderItem
+	Limit   *Value
+	Offset  *Value
+}
+
+// SelectItem is a column ("field AS alias") or an aggregate ("SUM(field) AS alias").
+type SelectItem struct {
+	Func     string // lower case aggregate name, "" for a plain column
+	Distinct bool   // COUNT(DISTINCT field)
+	Field    string // "" for COUNT(*)
+	Alias    string
+	Pos      Pos
+}
+
+type JoinClause struct {
+	Table string
+	Left  bool
+	On    [2]string
+}
+
+type OrderItem struct {
+	Field string
+	Desc  bool
+}
+
+type InsertStmt struct {
+	Table   string
+	Columns []string
+	Rows    [][]Value
+}
+
+type UpdateStmt struct {
+	Table string
+	Set   []Assignment
+	Where Expr
+}
+
+type Assignment struct {
+	Field string
+	Value Value
+}
+
+type DeleteStmt struct {
+	Table string
+	Where Expr
+}
+
+func (*SelectStmt) statement() {}
+func (*InsertStmt) statement() {}
+func (*UpdateStmt) statement() {}
+func (*DeleteStmt) statement() {}
+
+type Expr interface {
+	expr()
+}
+
+// LogicalExpr is Left AND Right or Left OR Right.
+type LogicalExpr struct {
+	Op    string // "and" or "or"
+	Left  Expr
+	Right Expr
+}
+
+type NotExpr struct {
+	Expr Expr
+}
+
+// Predicate compares a field with the database where operator Op. Values holds one value
+// for scalar operators, the list for IN/ANY, [low, high] for BETWEEN and nothing for
+// IS NULL and friends. List holds a placeholder bound to a whole list ("IN ?").
+type Predicate struct {
+	Field  string
+	Op     string
+	Values []Value
+	List   *Value
+	Pos    Pos
+}
+
+func (*LogicalExpr) expr() {}
+func (*NotExpr) expr()     {}
+func (*Predicate) expr()   {}
+
+// Value is a literal or, when Param > 0, the Param-th (1-based) parameter.
+type Value struct {
+	Literal any
+	Param   int
+	Pos     Pos
+}
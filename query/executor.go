@@ -0,0 +1,352 @@
+package query
+
+import (
+	"context"
+	"encoding/json"
+	"golangdb/database"
+	"math"
+	"slices"
+)
+
+/*
+   Executor
+
+   Runs parsed statements through the query builders of database.DB, so a statement
+   behaves exactly like the equivalent builder chain (same operators, indexes, schemas
+   and unique constraints). Placeholders are bound to the given parameters as values;
+   they are never parsed, so parameters cannot change the statement.
+*/
+
+type Executor struct {
+	DB *database.DB
+
+	// TableName maps the table names of a statement to stored tables, e.g. to add the
+	// server's user scope. Nil keeps the names as written.
+	TableName func(string) string
+}
+
+// Result of a statement: the rows of a SELECT, the rows changed by INSERT, UPDATE and
+// DELETE, and the ids INSERT assigned, in the order of its VALUES.
+type Result struct {
+	Rows     []map[string]any `json:"rows"`
+	Affected int              `json:"affected"`
+	IDs      []int64          `json:"ids,omitempty"`
+}
+
+// Exec parses src and runs it with params bound to its placeholders.
+func (e *Executor) Exec(ctx context.Context, src string, params ...any) (*Result, error) {
+	stmt, err := Parse(src)
+	if err != nil {
+		return nil, err
+	}
+	return e.Run(ctx, stmt, params...)
+}
+
+func (e *Executor) Run(ctx context.Context, stmt Statement, params ...any) (*Result, error) {
+	b := &binder{params: params}
+
+	switch s := stmt.(type) {
+	case *SelectStmt:
+		return e.runSelect(ctx, s, b)
+	case *InsertStmt:
+		return e.runInsert(ctx, s, b)
+	case *UpdateStmt:
+		return e.runUpdate(ctx, s, b)
+	case *DeleteStmt:
+		return e.runDelete(ctx, s, b)
+	}
+	return nil, errorAt(Pos{Line: 1, Column: 1}, "unsupported statement %T", stmt)
+}
+
+func (e *Executor) table(name string) string {
+	if e.TableName == nil {
+		return name
+	}
+	return e.TableName(name)
+}
+
+func (e *Executor) runSelect(ctx context.Context, s *SelectStmt, b *binder) (*Result, error) {
+	q := e.DB.Select().Table(e.table(s.Table))
+
+	for _, j := range s.Joins {
+		if j.Left {
+			q = q.LeftJoin(e.table(j.Table), j.On[0], j.On[1])
+		} else {
+			q = q.Join(e.table(j.Table), j.On[0], j.On[1])
+		}
+	}
+
+	if s.Where != nil {
+		cond, err := b.condition(s.Where)
+		if err != nil {
+			return nil, err
+		}
+		q = q.WhereCond(cond)
+	}
+
+	aggregating := len(s.GroupBy) > 0
+	for _, item := range s.Columns {
+		aggregating = aggregating || item.Func != ""
+	}
+
+	var columns []string
+	// ORDER BY may name an output alias, but rows are sorted before they are projected
+	aliases := make(map[string]string)
+	for _, item := range s.Columns {
+		if item.Func == "" {
+			if aggregating && !slices.Contains(s.GroupBy, item.Field) {
+				return nil, errorAt(item.Pos, "column %s must appear in GROUP BY or in an aggregate", item.Field)
+			}
+			if item.Alias != "" {
+				columns = append(columns, item.Field+" AS "+item.Alias)
+				aliases[item.Alias] = item.Field
+			} else {
+				columns = append(columns, item.Field)
+			}
+			continue
+		}
+
+		fn := item.Func
+		if item.Distinct {
+			fn = "count_distinct"
+		}
+
+		// the same default names as the builder's aggregates
+		alias := item.Alias
+		if alias == "" {
+			alias = fn
+			if item.Field != "" {
+				alias = fn + "_" + item.Field
+			}
+		}
+
+		q = q.Aggregate(fn, item.Field, alias)
+		columns = append(columns, alias)
+	}
+
+	if len(s.GroupBy) > 0 {
+		q = q.GroupBy(s.GroupBy...)
+	}
+
+	if s.Having != nil {
+		cond, err := b.condition(s.Having)
+		if err != nil {
+			return nil, err
+		}
+		q = q.HavingCond(cond)
+	}
+
+	for _, o := range s.OrderBy {
+		field := o.Field
+		if source, ok := aliases[field]; ok {
+			field = source
+		}
+
+		if o.Desc {
+			q = q.OrderBy(field, database.Desc)
+		} else {
+			q = q.OrderBy(field, database.Asc)
+		}
+	}
+
+	if s.Limit != nil {
+		n, err := b.count(*s.Limit, "LIMIT")
+		if err != nil {
+			return nil, err
+		}
+		q = q.Limit(n)
+	}
+	if s.Offset != nil {
+		n, err := b.count(*s.Offset, "OFFSET")
+		if err != nil {
+			return nil, err
+		}
+		q = q.Offset(n)
+	}
+
+	if len(columns) > 0 {
+		q = q.Columns(columns...)
+	}
+
+	rows, err := q.AllContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if rows == nil {
+		rows = []map[string]any{}
+	}
+	return &Result{Rows: rows}, nil
+}
+
+// runInsert writes all rows of the statement in one atomic batch
+func (e *Executor) runInsert(ctx context.Context, s *InsertStmt, b *binder) (*Result, error) {
+	rows := make([]map[string]any, len(s.Rows))
+
+	for r, values := range s.Rows {
+		row := make(map[string]any, len(values))
+		for i, v := range values {
+			value, err := b.resolve(v)
+			if err != nil {
+				return nil, err
+			}
+			row[s.Columns[i]] = value
+		}
+		rows[r] = row
+	}
+
+	ids, err := e.DB.InsertManyContext(ctx, e.table(s.Table), rows)
+	if err != nil {
+		return nil, err
+	}
+	return &Result{Affected: len(ids), IDs: ids}, nil
+}
+
+func (e *Executor) runUpdate(ctx context.Context, s *UpdateStmt, b *binder) (*Result, error) {
+	values := make(map[string]any, len(s.Set))
+	for _, a := range s.Set {
+		value, err := b.resolve(a.Value)
+		if err != nil {
+			return nil, err
+		}
+		values[a.Field] = value
+	}
+
+	q := e.DB.Update().Table(e.table(s.Table)).Set(values)
+
+	if s.Where != nil {
+		cond, err := b.condition(s.Where)
+		if err != nil {
+			return nil, err
+		}
+		q = q.WhereCond(cond)
+	}
+
+	n, err := q.ExecContext(ctx)
+	return &Result{Affected: n}, err
+}
+
+func (e *Executor) runDelete(ctx context.Context, s *DeleteStmt, b *binder) (*Result, error) {
+	q := e.DB.Delete().Table(e.table(s.Table))
+
+	if s.Where != nil {
+		cond, err := b.condition(s.Where)
+		if err != nil {
+			return nil, err
+		}
+		q = q.WhereCond(cond)
+	}
+
+	n, err := q.ExecAndCountContext(ctx)
+	return &Result{Affected: n}, err
+}
+
+/*
+   Binding values
+*/
+
+type binder struct {
+	params []any
+}
+
+func (b *binder) resolve(v Value) (any, error) {
+	if v.Param == 0 {
+		return v.Literal, nil
+	}
+	if v.Param > len(b.params) {
+		return nil, errorAt(v.Pos, "parameter %d is missing (%d given)", v.Param, len(b.params))
+	}
+	return b.params[v.Param-1], nil
+}
+
+// count resolves a LIMIT or OFFSET value, which must be a whole number >= 0
+func (b *binder) count(v Value, clause string) (int, error) {
+	value, err := b.resolve(v)
+	if err != nil {
+		return 0, err
+	}
+
+	var f float64
+	switch n := value.(type) {
+	case int:
+		f = float64(n)
+	case int64:
+		f = float64(n)
+	case float64:
+		f = n
+	case json.Number:
+		var err error
+		if f, err = n.Float64(); err != nil {
+			return 0, errorAt(v.Pos, "%s needs a number, got %s", clause, n)
+		}
+	default:
+		return 0, errorAt(v.Pos, "%s needs a number, got %T", clause, value)
+	}
+
+	if f < 0 || f != math.Trunc(f) || f > math.MaxInt32 {
+		return 0, errorAt(v.Pos, "%s needs a whole number >= 0, got %v", clause, value)
+	}
+	return int(f), nil
+}
+
+func (b *binder) condition(expr Expr) (*database.Condition, error) {
+	switch e := expr.(type) {
+	case *LogicalExpr:
+		left, err := b.condition(e.Left)
+		if err != nil {
+			return nil, err
+		}
+		right, err := b.condition(e.Right)
+		if err != nil {
+			return nil, err
+		}
+		if e.Op == "or" {
+			return database.Or(left, right), nil
+		}
+		return database.And(left, right), nil
+
+	case *NotExpr:
+		inner, err := b.condition(e.Expr)
+		if err != nil {
+			return nil, err
+		}
+		return database.Not(inner), nil
+
+	case *Predicate:
+		var value any
+
+		switch {
+		case e.List != nil:
+			v, err := b.resolve(*e.List)
+			if err != nil {
+				return nil, err
+			}
+			value = v
+
+		case len(e.Values) == 1 && e.Op != "in" && e.Op != "not in" && e.Op != "any":
+			v, err := b.resolve(e.Values[0])
+			if err != nil {
+				return nil, err
+			}
+			value = v
+
+		case len(e.Values) > 0:
+			list := make([]any, len(e.Values))
+			for i, item := range e.Values {
+				v, err := b.resolve(item)
+				if err != nil {
+					return nil, err
+				}
+				list[i] = v
+			}
+			value = list
+		}
+
+		cond := database.Cond(e.Field, e.Op, value)
+		if err := cond.Err(); err != nil {
+			return nil, errorAt(e.Pos, "%v", err)
+		}
+		return cond, nil
+	}
+
+	return nil, errorAt(Pos{Line: 1, Column: 1}, "unsupported expression %T", expr)
+}
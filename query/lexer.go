@@ -0,0 +1,252 @@
+package query
+
+import (
+	"fmt"
+	"golangdb/errors_consts"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+/*
+   Lexer
+
+   Splits a statement into tokens and records where each token starts. Keywords are plain
+   identifiers here; the parser compares them case-insensitively. Placeholders are "?"
+   (numbered left to right) and "$n" (explicit, 1-based).
+*/
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokQuotedIdent
+	tokNumber
+	tokString
+	tokParam
+	tokSymbol
+)
+
+// Pos is a 1-based line and column in the statement text.
+type Pos struct {
+	Line   int
+	Column int
+}
+
+type token struct {
+	kind  tokenKind
+	text  string
+	param int
+	pos   Pos
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case tokEOF:
+		return "end of statement"
+	case tokString:
+		return strconv.Quote(t.text)
+	case tokQuotedIdent:
+		return `"` + t.text + `"`
+	}
+	return t.text
+}
+
+// Error is a problem with the statement, located by line and column.
+// It matches errors_consts.ErrInvalidStatement with errors.Is.
+type Error struct {
+	Pos
+	Msg string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%v: line %d, column %d: %s", errors_consts.ErrInvalidStatement, e.Line, e.Column, e.Msg)
+}
+
+func (e *Error) Unwrap() error {
+	return errors_consts.ErrInvalidStatement
+}
+
+func errorAt(pos Pos, format string, args ...any) *Error {
+	return &Error{Pos: pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+// two-character symbols, checked before single characters
+var symbols2 = []string{"<=", ">=", "!=", "<>"}
+
+const symbols1 = "=<>(),.*[];-+"
+
+type lexer struct {
+	src    []rune
+	i      int
+	pos    Pos
+	params int
+}
+
+func lex(src string) ([]token, error) {
+	l := &lexer{src: []rune(src), pos: Pos{Line: 1, Column: 1}}
+
+	var tokens []token
+	for {
+		tok, err := l.next()
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, tok)
+		if tok.kind == tokEOF {
+			return tokens, nil
+		}
+	}
+}
+
+func (l *lexer) peek(offset int) rune {
+	if l.i+offset >= len(l.src) {
+		return 0
+	}
+	return l.src[l.i+offset]
+}
+
+func (l *lexer) advance() rune {
+	r := l.src[l.i]
+	l.i++
+	if r == '\n' {
+		l.pos.Line++
+		l.pos.Column = 1
+	} else {
+		l.pos.Column++
+	}
+	return r
+}
+
+func (l *lexer) skipSpaceAndComments() {
+	for l.i < len(l.src) {
+		r := l.peek(0)
+		switch {
+		case unicode.IsSpace(r):
+			l.advance()
+		case r == '-' && l.peek(1) == '-':
+			for l.i < len(l.src) && l.peek(0) != '\n' {
+				l.advance()
+			}
+		default:
+			return
+		}
+	}
+}
+
+func (l *lexer) next() (token, error) {
+	l.skipSpaceAndComments()
+
+	start := l.pos
+	if l.i >= len(l.src) {
+		return token{kind: tokEOF, pos: start}, nil
+	}
+
+	r := l.peek(0)
+	switch {
+	case r == '_' || unicode.IsLetter(r):
+		var b strings.Builder
+		for l.i < len(l.src) && (l.peek(0) == '_' || unicode.IsLetter(l.peek(0)) || unicode.IsDigit(l.peek(0))) {
+			b.WriteRune(l.advance())
+		}
+		return token{kind: tokIdent, text: b.String(), pos: start}, nil
+
+	case unicode.IsDigit(r) || (r == '.' && unicode.IsDigit(l.peek(1))):
+		return l.number(start)
+
+	case r == '\'':
+		text, err := l.quoted('\'', start, "string")
+		return token{kind: tokString, text: text, pos: start}, err
+
+	case r == '"':
+		text, err := l.quoted('"', start, "identifier")
+		if err == nil && text == "" {
+			err = errorAt(start, "empty quoted identifier")
+		}
+		return token{kind: tokQuotedIdent, text: text, pos: start}, err
+
+	case r == '?':
+		l.advance()
+		l.params++
+		return token{kind: tokParam, text: "?", param: l.params, pos: start}, nil
+
+	case r == '$':
+		l.advance()
+		var b strings.Builder
+		for l.i < len(l.src) && unicode.IsDigit(l.peek(0)) {
+			b.WriteRune(l.advance())
+		}
+		n, err := strconv.Atoi(b.String())
+		if err != nil || n < 1 {
+			return token{}, errorAt(start, "placeholder $ needs a number >= 1")
+		}
+		return token{kind: tokParam, text: "$" + b.String(), param: n, pos: start}, nil
+	}
+
+	for _, sym := range symbols2 {
+		if r == rune(sym[0]) && l.peek(1) == rune(sym[1]) {
+			l.advance()
+			l.advance()
+			return token{kind: tokSymbol, text: sym, pos: start}, nil
+		}
+	}
+	if strings.ContainsRune(symbols1, r) {
+		l.advance()
+		return token{kind: tokSymbol, text: string(r), pos: start}, nil
+	}
+
+	return token{}, errorAt(start, "unexpected character %q", r)
+}
+
+func (l *lexer) number(start Pos) (token, error) {
+	var b strings.Builder
+
+	digits := func() {
+		for l.i < len(l.src) && unicode.IsDigit(l.peek(0)) {
+			b.WriteRune(l.advance())
+		}
+	}
+
+	digits()
+	if l.peek(0) == '.' && unicode.IsDigit(l.peek(1)) {
+		b.WriteRune(l.advance())
+		digits()
+	}
+	if r := l.peek(0); r == 'e' || r == 'E' {
+		b.WriteRune(l.advance())
+		if r := l.peek(0); r == '+' || r == '-' {
+			b.WriteRune(l.advance())
+		}
+		if !unicode.IsDigit(l.peek(0)) {
+			return token{}, errorAt(start, "malformed number %q", b.String())
+		}
+		digits()
+	}
+	if r := l.peek(0); r == '_' || unicode.IsLetter(r) {
+		return token{}, errorAt(start, "malformed number %q", b.String()+string(r))
+	}
+
+	return token{kind: tokNumber, text: b.String(), pos: start}, nil
+}
+
+// quoted reads text between quote characters; a doubled quote stands for itself
+func (l *lexer) quoted(quote rune, start Pos, what string) (string, error) {
+	var b strings.Builder
+
+	l.advance()
+	for {
+		if l.i >= len(l.src) {
+			return "", errorAt(start, "unterminated %s", what)
+		}
+		r := l.advance()
+		if r != quote {
+			b.WriteRune(r)
+			continue
+		}
+		if l.peek(0) != quote {
+			return b.String(), nil
+		}
+		b.WriteRune(l.advance())
+	}
+}
@@ -0,0 +1,803 @@
+package query
+
+import (
+	"encoding/json"
+	"golangdb/database"
+	"strconv"
+	"strings"
+)
+
+/*
+   Parser
+
+   A recursive descent parser for the dialect below. Keywords are case-insensitive and
+   cannot be used as bare names; quote them ("order") to use them as fields or tables.
+
+     SELECT * | item [, item ...] FROM table
+         [[INNER] JOIN | LEFT [OUTER] JOIN table ON field = field ...]
+         [WHERE expr] [GROUP BY field, ...] [HAVING expr]
+         [ORDER BY field [ASC | DESC], ...] [LIMIT n] [OFFSET n]
+       item: field [[AS] alias] | COUNT(*) | COUNT([DISTINCT] field) | SUM|AVG|MIN|MAX(field) [[AS] alias]
+     INSERT INTO table (field, ...) VALUES (value, ...) [, (value, ...) ...]
+     UPDATE table SET field = value [, ...] [WHERE expr]
+     DELETE FROM table [WHERE expr]
+
+     expr: NOT expr | expr AND expr | expr OR expr | (expr) | predicate
+     predicate: field = | != | <> | < | > | <= | >= value
+              | field [NOT] IN (value, ...) | field [NOT] IN ?
+              | field [NOT] BETWEEN value AND value
+              | field [NOT] LIKE | ILIKE | CONTAINS | STARTS_WITH | ARRAY_CONTAINS | MATCH value
+              | field [NOT] ANY (value, ...) | field IS [NOT] NULL | field IS [NOT] MISSING
+     field: name, a path such as address.city or tags[0], or orders.total in joins
+     value: 'string' | number | TRUE | FALSE | NULL | ? | $n
+*/
+
+var keywords = map[string]bool{
+	"select": true, "from": true, "where": true, "group": true, "by": true, "having": true,
+	"order": true, "limit": true, "offset": true, "insert": true, "into": true, "values": true,
+	"update": true, "set": true, "delete": true, "join": true, "inner": true, "left": true,
+	"outer": true, "on": true, "and": true, "or": true, "not": true, "in": true,
+	"between": true, "like": true, "ilike": true, "is": true, "null": true, "true": true,
+	"false": true, "as": true, "asc": true, "desc": true, "distinct": true, "missing": true,
+	"contains": true, "starts_with": true, "array_contains": true, "any": true,
+	"match": true,
+}
+
+var aggregateNames = map[string]bool{
+	"count": true, "sum": true, "avg": true, "min": true, "max": true,
+}
+
+var comparisons = map[string]string{
+	"=": "=", "!=": "!=", "<>": "!=", "<": "<", ">": ">", "<=": "<=", ">=": ">=",
+}
+
+// operators written as a keyword followed by one value
+var keywordOperators = map[string]string{
+	"like": "like", "ilike": "ilike", "contains": "contains",
+	"starts_with": "starts_with", "array_contains": "array_contains",
+	"match": "match",
+}
+
+type parser struct {
+	tokens []token
+	i      int
+}
+
+// Parse turns one statement (an optional trailing ';' is allowed) into its AST.
+func Parse(src string) (Statement, error) {
+	tokens, err := lex(src)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{tokens: tokens}
+
+	stmt, err := p.statement()
+	if err != nil {
+		return nil, err
+	}
+
+	p.acceptSymbol(";")
+	if tok := p.cur(); tok.kind != tokEOF {
+		return nil, errorAt(tok.pos, "unexpected %s after the end of the statement", tok)
+	}
+	return stmt, nil
+}
+
+/*
+   token helpers
+*/
+
+func (p *parser) cur() token {
+	return p.tokens[p.i]
+}
+
+func (p *parser) advance() token {
+	tok := p.tokens[p.i]
+	if tok.kind != tokEOF {
+		p.i++
+	}
+	return tok
+}
+
+func (p *parser) isKeyword(word string) bool {
+	tok := p.cur()
+	return tok.kind == tokIdent && strings.EqualFold(tok.text, word)
+}
+
+func (p *parser) acceptKeyword(word string) bool {
+	if p.isKeyword(word) {
+		p.advance()
+		return true
+	}
+	return false
+}
+
+func (p *parser) expectKeyword(word string) error {
+	if !p.acceptKeyword(word) {
+		return p.unexpected(strings.ToUpper(word))
+	}
+	return nil
+}
+
+func (p *parser) isSymbol(sym string) bool {
+	tok := p.cur()
+	return tok.kind == tokSymbol && tok.text == sym
+}
+
+func (p *parser) acceptSymbol(sym string) bool {
+	if p.isSymbol(sym) {
+		p.advance()
+		return true
+	}
+	return false
+}
+
+func (p *parser) expectSymbol(sym string) error {
+	if !p.acceptSymbol(sym) {
+		return p.unexpected("'" + sym + "'")
+	}
+	return nil
+}
+
+func (p *parser) unexpected(expected string) error {
+	tok := p.cur()
+	return errorAt(tok.pos, "expected %s, got %s", expected, tok)
+}
+
+// name reads a table, field or alias name: a non-keyword identifier or a quoted one
+func (p *parser) name(what string) (string, error) {
+	tok := p.cur()
+
+	switch {
+	case tok.kind == tokQuotedIdent:
+		p.advance()
+		return tok.text, nil
+	case tok.kind == tokIdent && !keywords[strings.ToLower(tok.text)]:
+		p.advance()
+		return tok.text, nil
+	}
+	return "", p.unexpected(what)
+}
+
+// field reads a name followed by any number of .name and [index] steps
+func (p *parser) field() (string, error) {
+	first, err := p.name("a field name")
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	b.WriteString(first)
+
+	for {
+		switch {
+		case p.acceptSymbol("."):
+			next, err := p.name("a field name after '.'")
+			if err != nil {
+				return "", err
+			}
+			b.WriteString("." + next)
+
+		case p.isSymbol("["):
+			p.advance()
+			tok := p.cur()
+			if _, err := strconv.Atoi(tok.text); tok.kind != tokNumber || err != nil {
+				return "", p.unexpected("an array index")
+			}
+			p.advance()
+			if err := p.expectSymbol("]"); err != nil {
+				return "", err
+			}
+			b.WriteString("[" + tok.text + "]")
+
+		default:
+			return b.String(), nil
+		}
+	}
+}
+
+func (p *parser) fieldList() ([]string, error) {
+	var fields []string
+	for {
+		field, err := p.field()
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, field)
+
+		if !p.acceptSymbol(",") {
+			return fields, nil
+		}
+	}
+}
+
+/*
+   statements
+*/
+
+func (p *parser) statement() (Statement, error) {
+	switch {
+	case p.isKeyword("select"):
+		return p.selectStmt()
+	case p.isKeyword("insert"):
+		return p.insertStmt()
+	case p.isKeyword("update"):
+		return p.updateStmt()
+	case p.isKeyword("delete"):
+		return p.deleteStmt()
+	}
+	return nil, p.unexpected("SELECT, INSERT, UPDATE or DELETE")
+}
+
+func (p *parser) selectStmt() (*SelectStmt, error) {
+	p.advance()
+
+	stmt := &SelectStmt{}
+
+	if !p.acceptSymbol("*") {
+		for {
+			item, err := p.selectItem()
+			if err != nil {
+				return nil, err
+			}
+			stmt.Columns = append(stmt.Columns, item)
+
+			if !p.acceptSymbol(",") {
+				break
+			}
+		}
+	}
+
+	if err := p.expectKeyword("from"); err != nil {
+		return nil, err
+	}
+
+	var err error
+	if stmt.Table, err = p.name("a table name"); err != nil {
+		return nil, err
+	}
+
+joins:
+	for {
+		left := false
+		switch {
+		case p.acceptKeyword("join"):
+		case p.acceptKeyword("inner"):
+			if err := p.expectKeyword("join"); err != nil {
+				return nil, err
+			}
+		case p.acceptKeyword("left"):
+			p.acceptKeyword("outer")
+			if err := p.expectKeyword("join"); err != nil {
+				return nil, err
+			}
+			left = true
+		default:
+			break joins
+		}
+
+		join, err := p.joinClause(left)
+		if err != nil {
+			return nil, err
+		}
+		stmt.Joins = append(stmt.Joins, join)
+	}
+
+	if p.acceptKeyword("where") {
+		if stmt.Where, err = p.expr(); err != nil {
+			return nil, err
+		}
+	}
+
+	if p.acceptKeyword("group") {
+		if err := p.expectKeyword("by"); err != nil {
+			return nil, err
+		}
+		if stmt.GroupBy, err = p.fieldList(); err != nil {
+			return nil, err
+		}
+	}
+
+	if p.acceptKeyword("having") {
+		if stmt.Having, err = p.expr(); err != nil {
+			return nil, err
+		}
+	}
+
+	if p.acceptKeyword("order") {
+		if err := p.expectKeyword("by"); err != nil {
+			return nil, err
+		}
+		for {
+			field, err := p.field()
+			if err != nil {
+				return nil, err
+			}
+
+			item := OrderItem{Field: field}
+			if p.acceptKeyword("desc") {
+				item.Desc = true
+			} else {
+				p.acceptKeyword("asc")
+			}
+			stmt.OrderBy = append(stmt.OrderBy, item)
+
+			if !p.acceptSymbol(",") {
+				break
+			}
+		}
+	}
+
+	if p.acceptKeyword("limit") {
+		v, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		stmt.Limit = &v
+	}
+
+	if p.acceptKeyword("offset") {
+		v, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		stmt.Offset = &v
+	}
+
+	return stmt, nil
+}
+
+func (p *parser) selectItem() (SelectItem, error) {
+	tok := p.cur()
+	item := SelectItem{Pos: tok.pos}
+
+	fn := strings.ToLower(tok.text)
+	if tok.kind == tokIdent && aggregateNames[fn] && p.tokens[p.i+1].text == "(" {
+		p.advance()
+		p.advance()
+
+		item.Func = fn
+
+		switch {
+		case fn == "count" && p.acceptSymbol("*"):
+		default:
+			if p.acceptKeyword("distinct") {
+				if fn != "count" {
+					return item, errorAt(tok.pos, "DISTINCT is only supported in COUNT")
+				}
+				item.Distinct = true
+			}
+
+			field, err := p.field()
+			if err != nil {
+				return item, err
+			}
+			item.Field = field
+		}
+
+		if err := p.expectSymbol(")"); err != nil {
+			return item, err
+		}
+	} else {
+		field, err := p.field()
+		if err != nil {
+			return item, err
+		}
+		item.Field = field
+	}
+
+	explicit := p.acceptKeyword("as")
+	if explicit || p.cur().kind == tokQuotedIdent || (p.cur().kind == tokIdent && !keywords[strings.ToLower(p.cur().text)]) {
+		alias, err := p.name("an alias")
+		if err != nil {
+			return item, err
+		}
+		item.Alias = alias
+	}
+
+	return item, nil
+}
+
+func (p *parser) joinClause(left bool) (JoinClause, error) {
+	join := JoinClause{Left: left}
+
+	var err error
+	if join.Table, err = p.name("a table name"); err != nil {
+		return join, err
+	}
+	if err := p.expectKeyword("on"); err != nil {
+		return join, err
+	}
+	if join.On[0], err = p.field(); err != nil {
+		return join, err
+	}
+	if err := p.expectSymbol("="); err != nil {
+		return join, err
+	}
+	if join.On[1], err = p.field(); err != nil {
+		return join, err
+	}
+	return join, nil
+}
+
+func (p *parser) insertStmt() (*InsertStmt, error) {
+	p.advance()
+
+	if err := p.expectKeyword("into"); err != nil {
+		return nil, err
+	}
+
+	stmt := &InsertStmt{}
+
+	var err error
+	if stmt.Table, err = p.name("a table name"); err != nil {
+		return nil, err
+	}
+
+	if err := p.expectSymbol("("); err != nil {
+		return nil, err
+	}
+	for {
+		column, err := p.name("a column name")
+		if err != nil {
+			return nil, err
+		}
+		stmt.Columns = append(stmt.Columns, column)
+
+		if !p.acceptSymbol(",") {
+			break
+		}
+	}
+	if err := p.expectSymbol(")"); err != nil {
+		return nil, err
+	}
+
+	if err := p.expectKeyword("values"); err != nil {
+		return nil, err
+	}
+
+	for {
+		start := p.cur().pos
+		if err := p.expectSymbol("("); err != nil {
+			return nil, err
+		}
+
+		row, err := p.valueList()
+		if err != nil {
+			return nil, err
+		}
+		if len(row) != len(stmt.Columns) {
+			return nil, errorAt(start, "%d values for %d columns", len(row), len(stmt.Columns))
+		}
+		stmt.Rows = append(stmt.Rows, row)
+
+		if !p.acceptSymbol(",") {
+			return stmt, nil
+		}
+	}
+}
+
+func (p *parser) updateStmt() (*UpdateStmt, error) {
+	p.advance()
+
+	stmt := &UpdateStmt{}
+
+	var err error
+	if stmt.Table, err = p.name("a table name"); err != nil {
+		return nil, err
+	}
+
+	if err := p.expectKeyword("set"); err != nil {
+		return nil, err
+	}
+
+	for {
+		field, err := p.name("a column name")
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expectSymbol("="); err != nil {
+			return nil, err
+		}
+		v, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		stmt.Set = append(stmt.Set, Assignment{Field: field, Value: v})
+
+		if !p.acceptSymbol(",") {
+			break
+		}
+	}
+
+	if p.acceptKeyword("where") {
+		if stmt.Where, err = p.expr(); err != nil {
+			return nil, err
+		}
+	}
+	return stmt, nil
+}
+
+func (p *parser) deleteStmt() (*DeleteStmt, error) {
+	p.advance()
+
+	if err := p.expectKeyword("from"); err != nil {
+		return nil, err
+	}
+
+	stmt := &DeleteStmt{}
+
+	var err error
+	if stmt.Table, err = p.name("a table name"); err != nil {
+		return nil, err
+	}
+
+	if p.acceptKeyword("where") {
+		if stmt.Where, err = p.expr(); err != nil {
+			return nil, err
+		}
+	}
+	return stmt, nil
+}
+
+/*
+   expressions
+*/
+
+func (p *parser) expr() (Expr, error) {
+	left, err := p.andExpr()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.acceptKeyword("or") {
+		right, err := p.andExpr()
+		if err != nil {
+			return nil, err
+		}
+		left = &LogicalExpr{Op: "or", Left: left, Right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) andExpr() (Expr, error) {
+	left, err := p.notExpr()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.acceptKeyword("and") {
+		right, err := p.notExpr()
+		if err != nil {
+			return nil, err
+		}
+		left = &LogicalExpr{Op: "and", Left: left, Right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) notExpr() (Expr, error) {
+	if p.acceptKeyword("not") {
+		inner, err := p.notExpr()
+		if err != nil {
+			return nil, err
+		}
+		return &NotExpr{Expr: inner}, nil
+	}
+
+	if p.acceptSymbol("(") {
+		inner, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expectSymbol(")"); err != nil {
+			return nil, err
+		}
+		return inner, nil
+	}
+
+	return p.predicate()
+}
+
+func (p *parser) predicate() (Expr, error) {
+	pos := p.cur().pos
+
+	field, err := p.field()
+	if err != nil {
+		return nil, err
+	}
+
+	pred := &Predicate{Field: field, Pos: pos}
+
+	tok := p.cur()
+	if op, ok := comparisons[tok.text]; ok && tok.kind == tokSymbol {
+		p.advance()
+		v, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		pred.Op = op
+		pred.Values = []Value{v}
+		return pred, nil
+	}
+
+	if p.acceptKeyword("is") {
+		negated := p.acceptKeyword("not")
+		switch {
+		case p.acceptKeyword("null"):
+			pred.Op = "is null"
+		case p.acceptKeyword("missing"):
+			pred.Op = "not exists"
+		default:
+			return nil, p.unexpected("NULL or MISSING")
+		}
+		if negated {
+			pred.Op = map[string]string{"is null": "is not null", "not exists": "exists"}[pred.Op]
+		}
+		return pred, nil
+	}
+
+	negated := p.acceptKeyword("not")
+
+	switch {
+	case p.acceptKeyword("in"):
+		pred.Op = "in"
+		if negated {
+			pred.Op = "not in"
+			negated = false
+		}
+		if err := p.listOperand(pred); err != nil {
+			return nil, err
+		}
+
+	case p.acceptKeyword("any"):
+		pred.Op = "any"
+		if err := p.listOperand(pred); err != nil {
+			return nil, err
+		}
+
+	case p.acceptKeyword("between"):
+		low, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expectKeyword("and"); err != nil {
+			return nil, err
+		}
+		high, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		pred.Op = "between"
+		pred.Values = []Value{low, high}
+
+	default:
+		op, ok := keywordOperators[strings.ToLower(p.cur().text)]
+		if !ok || p.cur().kind != tokIdent {
+			if negated {
+				return nil, p.unexpected("IN, ANY, BETWEEN, LIKE, ILIKE, CONTAINS, STARTS_WITH, ARRAY_CONTAINS or MATCH")
+			}
+			return nil, p.unexpected("an operator")
+		}
+		p.advance()
+
+		v, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		pred.Op = op
+		pred.Values = []Value{v}
+	}
+
+	if negated {
+		return &NotExpr{Expr: pred}, nil
+	}
+	return pred, nil
+}
+
+// listOperand reads "(value, ...)" or a placeholder holding the whole list
+func (p *parser) listOperand(pred *Predicate) error {
+	if p.cur().kind == tokParam {
+		v, err := p.value()
+		if err != nil {
+			return err
+		}
+		pred.List = &v
+		return nil
+	}
+
+	if err := p.expectSymbol("("); err != nil {
+		return err
+	}
+	values, err := p.valueList()
+	if err != nil {
+		return err
+	}
+	pred.Values = values
+	return nil
+}
+
+// valueList reads "value, ...)" after an opening parenthesis
+func (p *parser) valueList() ([]Value, error) {
+	var values []Value
+	for {
+		v, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+
+		if !p.acceptSymbol(",") {
+			break
+		}
+	}
+	if err := p.expectSymbol(")"); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+func (p *parser) value() (Value, error) {
+	tok := p.cur()
+	v := Value{Pos: tok.pos}
+
+	switch {
+	case tok.kind == tokParam:
+		p.advance()
+		v.Param = tok.param
+		return v, nil
+
+	case tok.kind == tokString:
+		p.advance()
+		v.Literal = tok.text
+		return v, nil
+
+	case tok.kind == tokNumber:
+		p.advance()
+		return v, v.setNumber(tok.text, false)
+
+	case tok.kind == tokSymbol && (tok.text == "-" || tok.text == "+"):
+		p.advance()
+		num := p.cur()
+		if num.kind != tokNumber {
+			return v, p.unexpected("a number")
+		}
+		p.advance()
+		return v, v.setNumber(num.text, tok.text == "-")
+
+	case p.acceptKeyword("true"):
+		v.Literal = true
+		return v, nil
+
+	case p.acceptKeyword("false"):
+		v.Literal = false
+		return v, nil
+
+	case p.acceptKeyword("null"):
+		return v, nil
+	}
+
+	return v, p.unexpected("a value")
+}
+
+// setNumber stores integers as int64 and everything else (or what overflows) as its
+// json.Number text, which the database compares exactly
+func (v *Value) setNumber(text string, negative bool) error {
+	if negative {
+		text = "-" + text
+	}
+
+	if !strings.ContainsAny(text, ".eE") {
+		if n, err := strconv.ParseInt(text, 10, 64); err == nil {
+			v.Literal = n
+			return nil
+		}
+	}
+
+	if _, err := database.ParseDecimal(text); err != nil {
+		return errorAt(v.Pos, "bad number %s", text)
+	}
+	v.Literal = json.Number(text)
+	return nil
+}
@@ -0,0 +1,116 @@
+package main_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"golangdb/database"
+	"golangdb/errors_consts"
+	"golangdb/query"
+	"path/filepath"
+	"testing"
+)
+
+func TestQueryStatements(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "db.data")
+	walPath := filepath.Join(dir, "db.wal")
+
+	storage, err := database.OpenDB(dbPath, walPath, database.WalSizeLimit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer storage.Close()
+
+	exec := &query.Executor{
+		DB:        database.NewDB(storage),
+		TableName: func(name string) string { return "user:1:" + name },
+	}
+	ctx := context.Background()
+
+	if _, err := exec.Exec(ctx, "INSERT INTO customers (id, city) VALUES (9, NULL)"); err == nil {
+		t.Fatal("expected NULL to be rejected without a schema")
+	}
+
+	res, err := exec.Exec(ctx, "INSERT INTO customers (id, name, city) VALUES (1, 'ann', 'Oslo'), (2, ?, $3), (3, 'o''neil', ?)",
+		"bob", "Paris", "Bergen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Affected != 3 || fmt.Sprint(res.IDs) != "[1 2 3]" {
+		t.Fatalf("unexpected insert result %+v", res)
+	}
+
+	exec.Exec(ctx, "INSERT INTO orders (customer_id, total) VALUES (1, 10), (1, 25.5), (2, 7)")
+
+	res, err = exec.Exec(ctx, `
+		SELECT name, city AS town FROM customers
+		WHERE (city = 'Oslo' OR name LIKE 'o%') AND NOT id IN (2, 4)
+		ORDER BY name DESC
+		LIMIT ?`, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Rows) != 2 || res.Rows[0]["name"] != "o'neil" || res.Rows[1]["town"] != "Oslo" {
+		t.Fatalf("unexpected select result %v", res.Rows)
+	}
+
+	// ORDER BY an output alias sorts by its source field
+	res, err = exec.Exec(ctx, "SELECT total AS amount FROM orders ORDER BY amount DESC")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Rows) != 3 || fmt.Sprint([]any{res.Rows[0]["amount"], res.Rows[1]["amount"], res.Rows[2]["amount"]}) != "[25.5 10 7]" {
+		t.Fatalf("unexpected order by alias result %v", res.Rows)
+	}
+
+	res, err = exec.Exec(ctx, `
+		SELECT customers.name, COUNT(*), SUM(orders.total) AS spent
+		FROM orders JOIN customers ON orders.customer_id = customers.id
+		GROUP BY customers.name
+		HAVING count > 1`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Rows) != 1 || res.Rows[0]["customers.name"] != "ann" || fmt.Sprint(res.Rows[0]["spent"]) != "35.5" {
+		t.Fatalf("unexpected aggregate result %v", res.Rows)
+	}
+
+	// a parameter is a value, never part of the statement
+	res, err = exec.Exec(ctx, "SELECT * FROM customers WHERE name = ?", "x' OR '1'='1")
+	if err != nil || len(res.Rows) != 0 {
+		t.Fatalf("parameter changed the statement: %v %v", res, err)
+	}
+
+	res, err = exec.Exec(ctx, "UPDATE customers SET city = ? WHERE id BETWEEN 2 AND 3", "Rome")
+	if err != nil || res.Affected != 2 {
+		t.Fatalf("unexpected update result %+v %v", res, err)
+	}
+
+	res, err = exec.Exec(ctx, "DELETE FROM customers WHERE city = 'Rome';")
+	if err != nil || res.Affected != 2 {
+		t.Fatalf("unexpected delete result %+v %v", res, err)
+	}
+
+	errs := []struct {
+		src          string
+		line, column int
+	}{
+		{"SELECT * FORM customers", 1, 10},
+		{"SELECT *\nFROM customers\nWHERE name = 'x", 3, 14},
+		{"SELECT * FROM customers WHERE id = ?", 1, 36},
+		{"SELECT name, COUNT(*) FROM customers", 1, 8},
+		{"DELETE FROM customers WHERE id = 1 LIMIT 1", 1, 36},
+	}
+	for _, c := range errs {
+		_, err := exec.Exec(ctx, c.src)
+
+		var qerr *query.Error
+		if !errors.As(err, &qerr) || !errors.Is(err, errors_consts.ErrInvalidStatement) {
+			t.Fatalf("%q: expected a query error, got %v", c.src, err)
+		}
+		if qerr.Line != c.line || qerr.Column != c.column {
+			t.Fatalf("%q: expected line %d column %d, got %v", c.src, c.line, c.column, err)
+		}
+	}
+}
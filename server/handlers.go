@@ -1,12 +1,16 @@
 package server
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"golangdb/database"
 	"golangdb/errors_consts"
+	"golangdb/query"
 	"log"
 	"net/http"
+	"strings"
 )
 
 type SignUpAndLoginRequest struct {
@@ -15,10 +19,10 @@ type SignUpAndLoginRequest struct {
 }
 
 type User struct {
-	ID       int
-	Email    string
-	Password string
-	IsAdmin  bool
+	ID       int64  `db:"id"`
+	Email    string `db:"email"`
+	Password string `db:"password"`
+	IsAdmin  bool   `db:"is_admin"`
 }
 
 type InsertRequest struct {
@@ -26,25 +30,129 @@ type InsertRequest struct {
 	Values map[string]any `json:"values"`
 }
 
+type BulkInsertRequest struct {
+	Table string           `json:"table"`
+	Rows  []map[string]any `json:"rows"`
+}
+
+type BulkInsertResponse struct {
+	IDs []int64 `json:"ids"`
+}
+
 type SelectRequest struct {
+	Table   string         `json:"table"`
+	Where   *WhereRequest  `json:"where,omitempty"`
+	OrderBy []OrderRequest `json:"order_by,omitempty"`
+	Limit   int            `json:"limit,omitempty"`
+	Offset  int            `json:"offset,omitempty"`
+	Columns []string       `json:"columns,omitempty"`
+	Exclude []string       `json:"exclude,omitempty"`
+
+	Aggregates []AggregateRequest `json:"aggregates,omitempty"`
+	GroupBy    []string           `json:"group_by,omitempty"`
+	Having     *WhereRequest      `json:"having,omitempty"`
+
+	Joins []JoinRequest `json:"joins,omitempty"`
+
+	// Explain returns the query plan instead of the rows
+	Explain bool `json:"explain,omitempty"`
+}
+
+// JoinRequest joins Table on Left = Right, e.g. "orders.customer_id" = "customers.id".
+// Type is "inner" (default) or "left".
+type JoinRequest struct {
+	Table string `json:"table"`
+	Left  string `json:"left"`
+	Right string `json:"right"`
+	Type  string `json:"type,omitempty"`
+}
+
+type AggregateRequest struct {
+	Func  string `json:"func"`
+	Field string `json:"field,omitempty"`
+	As    string `json:"as,omitempty"`
+}
+
+type OrderRequest struct {
+	Field string `json:"field"`
+	Dir   string `json:"dir,omitempty"`
+}
+
+type DeleteRequest struct {
 	Table string        `json:"table"`
 	Where *WhereRequest `json:"where,omitempty"`
 }
 
-type DeleteRequest struct {
-	Table string `json:"table"`
-	Where *WhereRequest
+type UpdateRequest struct {
+	Table string         `json:"table"`
+	Set   map[string]any `json:"set"`
+	Where *WhereRequest  `json:"where,omitempty"`
 }
 
+// WhereRequest is either a single comparison (field/op/value) or a group.
+// When several parts are given in one object they are combined with AND.
 type WhereRequest struct {
-	Field string `json:"field"`
-	Op    string `json:"op"`
-	Value any    `json:"value"`
+	Field string `json:"field,omitempty"`
+	Op    string `json:"op,omitempty"`
+	Value any    `json:"value,omitempty"`
+
+	And []WhereRequest `json:"and,omitempty"`
+	Or  []WhereRequest `json:"or,omitempty"`
+	Not *WhereRequest  `json:"not,omitempty"`
+}
+
+func (wr *WhereRequest) Condition() *database.Condition {
+	var parts []*database.Condition
+
+	if wr.Field != "" || wr.Op != "" {
+		parts = append(parts, database.Cond(wr.Field, wr.Op, wr.Value))
+	}
+
+	if wr.And != nil {
+		parts = append(parts, database.And(conditions(wr.And)...))
+	}
+
+	if wr.Or != nil {
+		parts = append(parts, database.Or(conditions(wr.Or)...))
+	}
+
+	if wr.Not != nil {
+		parts = append(parts, database.Not(wr.Not.Condition()))
+	}
+
+	switch len(parts) {
+	case 0:
+		return database.Cond("", "", nil)
+	case 1:
+		return parts[0]
+	}
+	return database.And(parts...)
+}
+
+func conditions(reqs []WhereRequest) []*database.Condition {
+	out := make([]*database.Condition, 0, len(reqs))
+	for i := range reqs {
+		out = append(out, reqs[i].Condition())
+	}
+	return out
+}
+
+// decodeRequest reads the JSON body into v. Numbers inside values stay json.Number, so an
+// id or amount reaches storage with every digit the client sent.
+func decodeRequest(r *http.Request, v any) error {
+	dec := json.NewDecoder(r.Body)
+	dec.UseNumber()
+	return dec.Decode(v)
+}
+
+// the client went away or the request deadline passed while the query was running
+func isCancelled(err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
 }
 
 func (s *Server) SingUpHandler(w http.ResponseWriter, r *http.Request) {
 	var req SignUpAndLoginRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+	if err := decodeRequest(r, &req); err != nil {
 		log.Println("Failed to decode: ", err)
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
@@ -52,7 +160,7 @@ func (s *Server) SingUpHandler(w http.ResponseWriter, r *http.Request) {
 
 	defer r.Body.Close()
 
-	existing, err := s.Database.Select().Table("__users__").Where("email", "=", req.Email).All()
+	existing, err := s.Database.Select().Table("__users__").Where("email", "=", req.Email).AllContext(r.Context())
 	if err != nil {
 		log.Println("Failed to select user: ", err)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
@@ -60,7 +168,7 @@ func (s *Server) SingUpHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if len(existing) > 0 {
-		http.Error(w, "User already exists", http.StatusBadRequest)
+		http.Error(w, "User already exists", http.StatusConflict)
 		return
 	}
 
@@ -76,10 +184,15 @@ func (s *Server) SingUpHandler(w http.ResponseWriter, r *http.Request) {
 		"email":    req.Email,
 		"password": encUserPass,
 		"is_admin": false,
-	}).ExecAndReturnID()
+	}).ExecAndReturnIDContext(r.Context())
 
 	if err != nil {
 		log.Println("Failed to insert user: ", err)
+		// a concurrent sign-up with the same email won the unique index on __users__.email
+		if errors.Is(err, errors_consts.ErrUniqueViolation) {
+			http.Error(w, "User already exists", http.StatusConflict)
+			return
+		}
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
 	}
@@ -107,7 +220,7 @@ func (s *Server) SingUpHandler(w http.ResponseWriter, r *http.Request) {
 func (s *Server) LoginHandler(w http.ResponseWriter, r *http.Request) {
 	var req SignUpAndLoginRequest
 
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+	if err := decodeRequest(r, &req); err != nil {
 		log.Println("Failed to decode: ", err)
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
@@ -115,16 +228,13 @@ func (s *Server) LoginHandler(w http.ResponseWriter, r *http.Request) {
 
 	defer r.Body.Close()
 
-	users, err := s.Database.Select().Table("__users__").Where("email", "=", req.Email).All()
+	query := s.Database.Select().Table("__users__").Where("email", "=", req.Email)
+
+	users, err := database.SelectIntoContext[User](r.Context(), query)
 
 	if err != nil {
 		log.Println("Failed to select user: ", err)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
-	}
-
-	if err != nil {
-		log.Println("Failed to decode: ", err)
-		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
@@ -135,41 +245,13 @@ func (s *Server) LoginHandler(w http.ResponseWriter, r *http.Request) {
 
 	user := users[0]
 
-	hashedUserPass, ok := user["password"].(string)
-
-	if !ok {
-		log.Println("Failed to decode password: ", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
-		return
-	}
-
-	if err := ComparePasswords(hashedUserPass, req.Password); err != nil {
+	if err := ComparePasswords(user.Password, req.Password); err != nil {
 		log.Println("Failed to compare passwords: ", err)
 		http.Error(w, "Invalid credentials", http.StatusUnauthorized)
 		return
 	}
 
-	num, ok := user["id"].(json.Number)
-	if !ok {
-		log.Println("Failed to decode id: ", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
-		return
-	}
-
-	id, err := num.Int64()
-	if err != nil {
-		log.Println("Failed to decode id: ", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
-		return
-	}
-	isAdmin, ok := user["is_admin"].(bool)
-	if !ok {
-		log.Println("Failed to decode is_admin: ", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
-		return
-	}
-
-	token, err := GenerateJWT(id, isAdmin)
+	token, err := GenerateJWT(user.ID, user.IsAdmin)
 	if err != nil {
 		log.Println("Failed to generate JWT: ", err)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
@@ -202,7 +284,7 @@ func (s *Server) InsertHandler(w http.ResponseWriter, r *http.Request) {
 
 	var req InsertRequest
 
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+	if err := decodeRequest(r, &req); err != nil {
 		log.Println("Failed to decode: ", err)
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
@@ -212,14 +294,24 @@ func (s *Server) InsertHandler(w http.ResponseWriter, r *http.Request) {
 
 	table := fmt.Sprintf("user:%d:%s", user.UserID, req.Table)
 
-	err := s.Database.Insert().Table(table).Values(req.Values).Exec()
+	err := s.Database.Insert().Table(table).Values(req.Values).ExecContext(r.Context())
 
 	if err != nil {
 		log.Println("Failed to insert: ", err)
-		if errors.Is(err, errors_consts.ErrEmptyName) || errors.Is(err, errors_consts.ErrEmptyValues) {
+		if isCancelled(err) {
+			http.Error(w, "Request cancelled", http.StatusRequestTimeout)
+			return
+		}
+		if errors.Is(err, errors_consts.ErrEmptyName) || errors.Is(err, errors_consts.ErrEmptyValues) ||
+			errors.Is(err, errors_consts.ErrSchemaViolation) || errors.Is(err, errors_consts.ErrInvalidValue) {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
+		if errors.Is(err, errors_consts.ErrUniqueViolation) || errors.Is(err, errors_consts.ErrRowExists) ||
+			errors.Is(err, errors_consts.ErrForeignKeyViolation) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
 	}
@@ -227,6 +319,58 @@ func (s *Server) InsertHandler(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusCreated)
 }
 
+// post, all rows in one atomic batch
+
+func (s *Server) BulkInsertHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := r.Context().Value(userContextKey).(*Claims)
+	if !ok {
+		log.Println("No user in context (bulk insert handler)")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req BulkInsertRequest
+
+	if err := decodeRequest(r, &req); err != nil {
+		log.Println("Failed to decode: ", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	defer r.Body.Close()
+
+	table := fmt.Sprintf("user:%d:%s", user.UserID, req.Table)
+
+	ids, err := s.Database.InsertManyContext(r.Context(), table, req.Rows)
+
+	if err != nil {
+		log.Println("Failed to bulk insert: ", err)
+		if isCancelled(err) {
+			http.Error(w, "Request cancelled", http.StatusRequestTimeout)
+			return
+		}
+		if errors.Is(err, errors_consts.ErrEmptyName) || errors.Is(err, errors_consts.ErrEmptyValues) ||
+			errors.Is(err, errors_consts.ErrSchemaViolation) || errors.Is(err, errors_consts.ErrInvalidValue) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if errors.Is(err, errors_consts.ErrUniqueViolation) || errors.Is(err, errors_consts.ErrRowExists) ||
+			errors.Is(err, errors_consts.ErrForeignKeyViolation) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusCreated)
+
+	if err := json.NewEncoder(w).Encode(BulkInsertResponse{IDs: ids}); err != nil {
+		log.Println("Failed to encode: ", err)
+	}
+}
+
 // get
 
 func (s *Server) SelectHandler(w http.ResponseWriter, r *http.Request) {
@@ -239,7 +383,7 @@ func (s *Server) SelectHandler(w http.ResponseWriter, r *http.Request) {
 
 	var req SelectRequest
 
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+	if err := decodeRequest(r, &req); err != nil {
 		log.Println("Failed to decode: ", err)
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
@@ -259,19 +403,65 @@ func (s *Server) SelectHandler(w http.ResponseWriter, r *http.Request) {
 
 	query := s.Database.Select().Table(table)
 
+	for _, j := range req.Joins {
+		switch strings.ToLower(j.Type) {
+		case "", "inner":
+			query = query.Join(prefix+j.Table, j.Left, j.Right)
+		case "left":
+			query = query.LeftJoin(prefix+j.Table, j.Left, j.Right)
+		default:
+			http.Error(w, fmt.Sprintf("unknown join type %q", j.Type), http.StatusBadRequest)
+			return
+		}
+	}
+
 	if req.Where != nil {
-		query = query.Where(
-			req.Where.Field,
-			req.Where.Op,
-			req.Where.Value,
-		)
+		query = query.WhereCond(req.Where.Condition())
+	}
+
+	for _, o := range req.OrderBy {
+		query = query.OrderBy(o.Field, database.SortOrder(o.Dir))
 	}
 
-	rows, err := query.All()
+	for _, a := range req.Aggregates {
+		query = query.Aggregate(a.Func, a.Field, a.As)
+	}
+
+	if len(req.GroupBy) > 0 {
+		query = query.GroupBy(req.GroupBy...)
+	}
+
+	if req.Having != nil {
+		query = query.HavingCond(req.Having.Condition())
+	}
+
+	query = query.Limit(req.Limit).Offset(req.Offset)
+
+	if len(req.Columns) > 0 {
+		query = query.Columns(req.Columns...)
+	}
+
+	if len(req.Exclude) > 0 {
+		query = query.Exclude(req.Exclude...)
+	}
+
+	var result any
+	var err error
+
+	if req.Explain {
+		result, err = query.ExplainContext(r.Context())
+	} else {
+		result, err = query.AllContext(r.Context())
+	}
 
 	if err != nil {
 		log.Println("Failed to select: ", err)
-		if errors.Is(err, errors_consts.ErrEmptyName) {
+		if isCancelled(err) {
+			http.Error(w, "Request cancelled", http.StatusRequestTimeout)
+			return
+		}
+		if errors.Is(err, errors_consts.ErrEmptyName) || errors.Is(err, errors_consts.ErrInvalidWhere) ||
+			errors.Is(err, errors_consts.ErrInvalidQuery) {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
@@ -281,7 +471,7 @@ func (s *Server) SelectHandler(w http.ResponseWriter, r *http.Request) {
 
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
 
-	if err := json.NewEncoder(w).Encode(rows); err != nil {
+	if err := json.NewEncoder(w).Encode(result); err != nil {
 		log.Println("Failed to encode: ", err)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
@@ -303,7 +493,7 @@ func (s *Server) DeleteHandler(w http.ResponseWriter, r *http.Request) {
 
 	defer r.Body.Close()
 
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+	if err := decodeRequest(r, &req); err != nil {
 		log.Println("Failed to decode: ", err)
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
@@ -314,18 +504,467 @@ func (s *Server) DeleteHandler(w http.ResponseWriter, r *http.Request) {
 	query := s.Database.Delete().Table(table)
 
 	if req.Where != nil {
-		query = query.Where(
-			req.Where.Field,
-			req.Where.Op,
-			req.Where.Value,
-		)
+		query = query.WhereCond(req.Where.Condition())
 	}
 
-	if err := query.Exec(); err != nil {
+	if err := query.ExecContext(r.Context()); err != nil {
 		log.Println("Failed to delete: ", err)
+		if isCancelled(err) {
+			http.Error(w, "Request cancelled", http.StatusRequestTimeout)
+			return
+		}
+		if errors.Is(err, errors_consts.ErrEmptyName) || errors.Is(err, errors_consts.ErrInvalidWhere) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if errors.Is(err, errors_consts.ErrForeignKeyViolation) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// patch
+
+func (s *Server) UpdateHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := r.Context().Value(userContextKey).(*Claims)
+
+	if !ok {
+		log.Println("No user in context (update handler)")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req UpdateRequest
+
+	defer r.Body.Close()
+
+	if err := decodeRequest(r, &req); err != nil {
+		log.Println("Failed to decode: ", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	table := fmt.Sprintf("user:%d:%s", user.UserID, req.Table)
+
+	query := s.Database.Update().Table(table).Set(req.Set)
+
+	if req.Where != nil {
+		query = query.WhereCond(req.Where.Condition())
+	}
+
+	updated, err := query.ExecContext(r.Context())
+
+	if err != nil {
+		log.Println("Failed to update: ", err)
+		if isCancelled(err) {
+			http.Error(w, "Request cancelled", http.StatusRequestTimeout)
+			return
+		}
+		if errors.Is(err, errors_consts.ErrEmptyName) || errors.Is(err, errors_consts.ErrEmptyValues) ||
+			errors.Is(err, errors_consts.ErrUpdateID) || errors.Is(err, errors_consts.ErrInvalidWhere) ||
+			errors.Is(err, errors_consts.ErrSchemaViolation) || errors.Is(err, errors_consts.ErrInvalidValue) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if errors.Is(err, errors_consts.ErrUniqueViolation) || errors.Is(err, errors_consts.ErrForeignKeyViolation) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+	if err := json.NewEncoder(w).Encode(map[string]int{"updated": updated}); err != nil {
+		log.Println("Failed to encode: ", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+}
+
+// query
+
+type QueryRequest struct {
+	Statement string `json:"statement"`
+	Params    []any  `json:"params,omitempty"`
+}
+
+func (s *Server) QueryHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := r.Context().Value(userContextKey).(*Claims)
+	if !ok {
+		log.Println("No user in context (query handler)")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req QueryRequest
+
+	if err := decodeRequest(r, &req); err != nil {
+		log.Println("Failed to decode: ", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	defer r.Body.Close()
+
+	var prefix string
+
+	if user.IsAdmin {
+		prefix = "user:"
+	} else {
+		prefix = fmt.Sprintf("user:%d:", user.UserID)
+	}
+
+	executor := &query.Executor{
+		DB: s.Database,
+		TableName: func(name string) string {
+			return prefix + name
+		},
+	}
+
+	result, err := executor.Exec(r.Context(), req.Statement, req.Params...)
+
+	if err != nil {
+		log.Println("Failed to run query: ", err)
+		if isCancelled(err) {
+			http.Error(w, "Request cancelled", http.StatusRequestTimeout)
+			return
+		}
+		if errors.Is(err, errors_consts.ErrInvalidStatement) || errors.Is(err, errors_consts.ErrEmptyName) ||
+			errors.Is(err, errors_consts.ErrEmptyValues) || errors.Is(err, errors_consts.ErrUpdateID) ||
+			errors.Is(err, errors_consts.ErrInvalidWhere) || errors.Is(err, errors_consts.ErrInvalidQuery) ||
+			errors.Is(err, errors_consts.ErrSchemaViolation) || errors.Is(err, errors_consts.ErrInvalidValue) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if errors.Is(err, errors_consts.ErrUniqueViolation) || errors.Is(err, errors_consts.ErrRowExists) ||
+			errors.Is(err, errors_consts.ErrForeignKeyViolation) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		log.Println("Failed to encode: ", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+}
+
+/*
+   Table catalog, scoped to the tables of the calling user
+*/
+
+type TableRequest struct {
+	Table string `json:"table"`
+}
+
+type RenameTableRequest struct {
+	Table string `json:"table"`
+	Name  string `json:"name"`
+}
+
+type ListTablesResponse struct {
+	Tables []string `json:"tables"`
+}
+
+func catalogError(w http.ResponseWriter, err error) {
+	log.Println("Catalog operation failed: ", err)
+	switch {
+	case isCancelled(err):
+		http.Error(w, "Request cancelled", http.StatusRequestTimeout)
+	case errors.Is(err, errors_consts.ErrEmptyName):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, errors_consts.ErrTableNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, errors_consts.ErrTableExists) || errors.Is(err, errors_consts.ErrForeignKeyViolation):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+func (s *Server) ListTablesHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := r.Context().Value(userContextKey).(*Claims)
+	if !ok {
+		log.Println("No user in context (list tables handler)")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	prefix := fmt.Sprintf("user:%d:", user.UserID)
+
+	tables, err := s.Database.ListTablesContext(r.Context(), prefix)
+
+	if err != nil {
+		catalogError(w, err)
+		return
+	}
+
+	for i, name := range tables {
+		tables[i] = strings.TrimPrefix(name, prefix)
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+	if err := json.NewEncoder(w).Encode(ListTablesResponse{Tables: tables}); err != nil {
+		log.Println("Failed to encode: ", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+}
+
+func (s *Server) DescribeTableHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := r.Context().Value(userContextKey).(*Claims)
+	if !ok {
+		log.Println("No user in context (describe table handler)")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req TableRequest
+
+	defer r.Body.Close()
+
+	if err := decodeRequest(r, &req); err != nil {
+		log.Println("Failed to decode: ", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if req.Table == "" {
+		catalogError(w, errors_consts.ErrEmptyName)
+		return
+	}
+
+	info, err := s.Database.DescribeTableContext(r.Context(), fmt.Sprintf("user:%d:%s", user.UserID, req.Table))
+
+	if err != nil {
+		catalogError(w, err)
+		return
+	}
+
+	// the namespace stays internal
+	info.Name = req.Table
+	for i := range info.Indexes {
+		info.Indexes[i].Table = req.Table
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+	if err := json.NewEncoder(w).Encode(info); err != nil {
+		log.Println("Failed to encode: ", err)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
 	}
+}
+
+func (s *Server) RenameTableHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := r.Context().Value(userContextKey).(*Claims)
+	if !ok {
+		log.Println("No user in context (rename table handler)")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req RenameTableRequest
+
+	defer r.Body.Close()
+
+	if err := decodeRequest(r, &req); err != nil {
+		log.Println("Failed to decode: ", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if req.Table == "" || req.Name == "" {
+		catalogError(w, errors_consts.ErrEmptyName)
+		return
+	}
+
+	err := s.Database.RenameTable(fmt.Sprintf("user:%d:%s", user.UserID, req.Table), fmt.Sprintf("user:%d:%s", user.UserID, req.Name))
+
+	if err != nil {
+		catalogError(w, err)
+		return
+	}
 
 	w.WriteHeader(http.StatusNoContent)
 }
+
+func (s *Server) DropTableHandler(w http.ResponseWriter, r *http.Request) {
+	s.dropTable(w, r, false)
+}
+
+func (s *Server) TruncateTableHandler(w http.ResponseWriter, r *http.Request) {
+	s.dropTable(w, r, true)
+}
+
+func (s *Server) dropTable(w http.ResponseWriter, r *http.Request, truncate bool) {
+	user, ok := r.Context().Value(userContextKey).(*Claims)
+	if !ok {
+		log.Println("No user in context (drop table handler)")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req TableRequest
+
+	defer r.Body.Close()
+
+	if err := decodeRequest(r, &req); err != nil {
+		log.Println("Failed to decode: ", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if req.Table == "" {
+		catalogError(w, errors_consts.ErrEmptyName)
+		return
+	}
+
+	table := fmt.Sprintf("user:%d:%s", user.UserID, req.Table)
+
+	var err error
+	if truncate {
+		err = s.Database.TruncateTable(table)
+	} else {
+		err = s.Database.DropTable(table)
+	}
+
+	if err != nil {
+		catalogError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+/*
+   Full-text search, scoped to the tables of the calling user
+*/
+
+type SearchRequest struct {
+	Table  string        `json:"table"`
+	Field  string        `json:"field"`
+	Query  string        `json:"query"`
+	Where  *WhereRequest `json:"where"`
+	Limit  int           `json:"limit"`
+	Offset int           `json:"offset"`
+}
+
+type SearchResponse struct {
+	Hits []database.SearchHit `json:"hits"`
+}
+
+type TextIndexRequest struct {
+	Table string `json:"table"`
+	Field string `json:"field"`
+}
+
+func searchError(w http.ResponseWriter, err error) {
+	log.Println("Search failed: ", err)
+	switch {
+	case isCancelled(err):
+		http.Error(w, "Request cancelled", http.StatusRequestTimeout)
+	case errors.Is(err, errors_consts.ErrEmptyName) || errors.Is(err, errors_consts.ErrInvalidWhere) ||
+		errors.Is(err, errors_consts.ErrInvalidQuery) || errors.Is(err, errors_consts.ErrIndexNotFound):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, errors_consts.ErrIndexExists):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+func (s *Server) SearchHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := r.Context().Value(userContextKey).(*Claims)
+	if !ok {
+		log.Println("No user in context (search handler)")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req SearchRequest
+
+	defer r.Body.Close()
+
+	if err := decodeRequest(r, &req); err != nil {
+		log.Println("Failed to decode: ", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if req.Table == "" {
+		searchError(w, errors_consts.ErrEmptyName)
+		return
+	}
+
+	query := s.Database.Search().
+		Table(fmt.Sprintf("user:%d:%s", user.UserID, req.Table)).
+		Match(req.Field, req.Query).
+		Limit(req.Limit).
+		Offset(req.Offset)
+
+	if req.Where != nil {
+		query = query.WhereCond(req.Where.Condition())
+	}
+
+	hits, err := query.AllContext(r.Context())
+
+	if err != nil {
+		searchError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+	if err := json.NewEncoder(w).Encode(SearchResponse{Hits: hits}); err != nil {
+		log.Println("Failed to encode: ", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+}
+
+func (s *Server) CreateTextIndexHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := r.Context().Value(userContextKey).(*Claims)
+	if !ok {
+		log.Println("No user in context (create text index handler)")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req TextIndexRequest
+
+	defer r.Body.Close()
+
+	if err := decodeRequest(r, &req); err != nil {
+		log.Println("Failed to decode: ", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if req.Table == "" {
+		searchError(w, errors_consts.ErrEmptyName)
+		return
+	}
+
+	err := s.Database.CreateTextIndex(fmt.Sprintf("user:%d:%s", user.UserID, req.Table), req.Field)
+
+	if err != nil {
+		searchError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+}
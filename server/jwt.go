@@ -15,6 +15,20 @@ type contextKey string
 
 const userContextKey contextKey = contextKey("user")
 
+// jwtSecret overrides the JWT_SECRET environment variable when set through SetJWTSecret.
+var jwtSecret string
+
+func SetJWTSecret(secret string) {
+	jwtSecret = secret
+}
+
+func signingKey() []byte {
+	if jwtSecret != "" {
+		return []byte(jwtSecret)
+	}
+	return []byte(os.Getenv("JWT_SECRET"))
+}
+
 type Claims struct {
 	UserID  int64 `,json:"user_id"`
 	IsAdmin bool  `,json:"is_admin"`
@@ -38,8 +52,8 @@ func JWTmiddleware(next http.Handler) http.Handler {
 		claims := &Claims{}
 
 		token, err := jwt.ParseWithClaims(tokenStr, claims, func(token *jwt.Token) (interface{}, error) {
-			secret := []byte(os.Getenv("JWT_SECRET"))
-			if secret == nil {
+			secret := signingKey()
+			if len(secret) == 0 {
 				return nil, errors.New("JWT_SECRET is not set")
 			}
 			return secret, nil
@@ -56,7 +70,10 @@ func JWTmiddleware(next http.Handler) http.Handler {
 }
 
 func GenerateJWT(userId int64, isAdmin bool) (string, error) {
-	secret := os.Getenv("JWT_SECRET")
+	secret := signingKey()
+	if len(secret) == 0 {
+		return "", errors.New("JWT_SECRET is not set")
+	}
 
 	claims := Claims{
 		UserID:  userId,
@@ -68,7 +85,7 @@ func GenerateJWT(userId int64, isAdmin bool) (string, error) {
 	}
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString([]byte(secret))
+	return token.SignedString(secret)
 }
 
 func AdminOnly(next http.Handler) http.Handler {
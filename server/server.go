@@ -49,8 +49,20 @@ func (s *Server) Routes() {
 	s.Router.Group(func(r chi.Router) {
 		r.Use(JWTmiddleware)
 		r.Post("/create", s.InsertHandler)
+		r.Post("/create/bulk", s.BulkInsertHandler)
 		r.Delete("/delete", s.DeleteHandler)
+		r.Patch("/update", s.UpdateHandler)
 		r.Get("/get", s.SelectHandler)
+		r.Post("/query", s.QueryHandler)
+
+		r.Get("/tables", s.ListTablesHandler)
+		r.Get("/tables/describe", s.DescribeTableHandler)
+		r.Post("/tables/rename", s.RenameTableHandler)
+		r.Post("/tables/truncate", s.TruncateTableHandler)
+		r.Delete("/tables/drop", s.DropTableHandler)
+
+		r.Get("/search", s.SearchHandler)
+		r.Post("/search/index", s.CreateTextIndexHandler)
 
 		r.Route("/admin", func(r chi.Router) {
 			r.Use(AdminOnly)